@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+)
+
+// auditEntry records where the version information for a single module query
+// was sourced from, as reported by 'go list'. The Origin field is opaque to
+// us, so it is serialized exactly as the go command reported it.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Query   string    `json:"query,omitempty"`
+	Version string    `json:"version,omitempty"`
+	Origin  any       `json:"origin,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+var audit struct {
+	sync.Mutex
+	entries []auditEntry
+}
+
+func recordAudit(results []modupgrade.Module) {
+	if *auditLog == "" {
+		return
+	}
+
+	// Module queries are made concurrently when upgrading all dependencies
+	audit.Lock()
+	defer audit.Unlock()
+
+	now := time.Now()
+	for _, result := range results {
+		entry := auditEntry{
+			Time:    now,
+			Path:    result.Path,
+			Query:   result.Query,
+			Version: result.Version,
+			Origin:  result.Origin,
+		}
+		if result.Error != nil {
+			entry.Error = result.Error.Err
+		}
+		audit.entries = append(audit.entries, entry)
+	}
+}
+
+func writeAuditLog(filePath string) error {
+	audit.Lock()
+	defer audit.Unlock()
+
+	entries := audit.entries
+	if entries == nil {
+		entries = []auditEntry{}
+	}
+
+	out, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error encoding audit log: %s", err)
+	}
+
+	// The audit log is replaced atomically, so that it is always valid JSON
+	if err := modupgrade.WriteFile(filePath, append(out, '\n')); err != nil {
+		return fmt.Errorf("error writing audit log %s: %s", filePath, err)
+	}
+	return nil
+}
+
+// auditLogFailed is set once writing the audit log has failed, and the error
+// has been reported, so that it isn't reported again as the tool exits.
+var auditLogFailed bool
+
+// saveAuditLog writes the audit log, if one was asked for. It's called (by
+// exit and fatalf) however the tool exits, including on errors, so that the
+// module queries made before an error are still recorded.
+func saveAuditLog() error {
+	if *auditLog == "" || auditLogFailed {
+		return nil
+	}
+	if err := writeAuditLog(*auditLog); err != nil {
+		auditLogFailed = true
+		return err
+	}
+	return nil
+}
+
+// exit saves the audit log, then exits with the given status code. It's
+// used instead of os.Exit, which exits without running deferred calls.
+func exit(code int) {
+	if err := saveAuditLog(); err != nil {
+		log.Fatalf("Error writing audit log: %s", err)
+	}
+	os.Exit(code)
+}
+
+// fatalf logs the error, then saves the audit log and exits with status 1. It's
+// used instead of log.Fatalf, which exits without running deferred calls.
+func fatalf(format string, args ...any) {
+	log.Printf(format, args...)
+	if err := saveAuditLog(); err != nil {
+		log.Printf("Error writing audit log: %s", err)
+	}
+	os.Exit(1)
+}
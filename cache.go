@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+)
+
+// versionCacheFile is the name of the file within the -cache-dir directory
+// that holds the cached upgrade versions.
+const versionCacheFile = "versions.json"
+
+// versionCacheEntry is the result of looking up the upgrade versions of a
+// dependency, as cached between runs with -cache-dir.
+type versionCacheEntry struct {
+	modupgrade.Versions
+	Time time.Time `json:"time"`
+}
+
+// versionCache holds the cached upgrade versions, keyed by versionCacheKey.
+// It's loaded from the -cache-dir directory when first used (unless -no-cache
+// is given, in which case every lookup is made afresh, replacing the cached
+// results), and saved with saveVersionCache.
+var versionCache struct {
+	sync.Mutex
+	loaded  bool
+	changed bool
+	entries map[string]versionCacheEntry
+}
+
+// versionCacheKey returns the key of a dependency's upgrade versions in the
+// cache. The versions depend on the dependency's current version (e.g. for
+// +incompatible versions), and on the flags that affect which are found.
+func versionCacheKey(path, version string) string {
+	return fmt.Sprintf("%s@%s pre=%t max-gap=%d", path, version, *pre, *maxGap)
+}
+
+// fileVersionCache caches the versions looked up by the upgrader in the
+// -cache-dir directory, where they're reused until they're older than
+// -cache-ttl.
+type fileVersionCache struct{}
+
+func (fileVersionCache) Get(path, version string) (modupgrade.Versions, bool) {
+	entry, ok := cachedVersions(versionCacheKey(path, version))
+	if ok && *verbose {
+		fmt.Fprintf(stdout, "%s - using versions cached at %s\n", path, entry.Time.Format(time.RFC3339))
+	}
+	return entry.Versions, ok
+}
+
+func (fileVersionCache) Put(path, version string, versions modupgrade.Versions) {
+	versionCache.Lock()
+	defer versionCache.Unlock()
+	versionCache.entries[versionCacheKey(path, version)] = versionCacheEntry{
+		Versions: versions,
+		Time:     time.Now(),
+	}
+	versionCache.changed = true
+}
+
+// cachedVersions returns the cached entry with the given key, if there is one
+// that hasn't expired.
+func cachedVersions(key string) (versionCacheEntry, bool) {
+	versionCache.Lock()
+	defer versionCache.Unlock()
+
+	if !versionCache.loaded {
+		versionCache.loaded = true
+		versionCache.entries = map[string]versionCacheEntry{}
+		if !*noCache {
+			// A cache that can't be read is only a missed optimization
+			if err := loadVersionCache(); err != nil {
+				fmt.Fprintf(stdout, "Warning: ignoring version cache: %s\n", err)
+			}
+		}
+	}
+
+	entry, ok := versionCache.entries[key]
+	return entry, ok
+}
+
+// loadVersionCache reads the cached entries from the -cache-dir directory,
+// skipping those that are older than -cache-ttl (so that they're removed from
+// the cache when it's saved).
+func loadVersionCache() error {
+	b, err := ioutil.ReadFile(filepath.Join(*cacheDir, versionCacheFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading cache file: %s", err)
+	}
+
+	var entries map[string]versionCacheEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return fmt.Errorf("error parsing cache file: %s", err)
+	}
+	for key, entry := range entries {
+		if time.Since(entry.Time) < *cacheTTL {
+			versionCache.entries[key] = entry
+		} else {
+			versionCache.changed = true
+		}
+	}
+	return nil
+}
+
+// saveVersionCache writes the cached entries to the -cache-dir directory, if
+// any have changed.
+func saveVersionCache() error {
+	versionCache.Lock()
+	defer versionCache.Unlock()
+
+	if !versionCache.changed {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(versionCache.entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding cache: %s", err)
+	}
+	if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+		return fmt.Errorf("error creating cache directory: %s", err)
+	}
+	if err := modupgrade.WriteFile(filepath.Join(*cacheDir, versionCacheFile), b); err != nil {
+		return fmt.Errorf("error writing cache file: %s", err)
+	}
+	versionCache.changed = false
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// compareDependency prints a unified diff of the module's go.mod file against
+// what it would be after upgrading the given dependency (to the given version,
+// or to its latest major version if none is given), including any change to
+// the go directive the upgrade requires. Unlike -dry-run and -diff, only the
+// go.mod file is considered: no imports are rewritten, and nothing is written.
+func compareDependency(ctx context.Context, dir, path, version string) {
+	file := readModFile(dir)
+	before := formatModFile(file)
+
+	upgrades := upgradeDependency(ctx, dir, file, path, version)
+	if len(upgrades) == 0 {
+		return
+	}
+
+	if *goVersion != "" {
+		setGoVersion(ctx, dir, file)
+	} else {
+		updateGoDirective(ctx, dir, file, upgrades)
+	}
+
+	if d := unifiedDiff("go.mod", before, formatModFile(file)); d != "" {
+		fmt.Fprint(stdout, d)
+	}
+}
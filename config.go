@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileName is the name of the config file looked for in the module
+// directory, and tomlConfigFileName the name of the same file in TOML.
+const (
+	configFileName     = ".upgrade.yaml"
+	tomlConfigFileName = ".upgrade.toml"
+)
+
+// configFiles returns the paths of the config files to load, in increasing
+// order of precedence: the user's config file, then the module's (in YAML,
+// then in TOML).
+func configFiles(dir string) []string {
+	var files []string
+	if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".config", "upgrade", "config.yaml"))
+	}
+	return append(files, filepath.Join(dir, configFileName), filepath.Join(dir, tomlConfigFileName))
+}
+
+// configAliases maps the long names that can be given to options in config
+// files to the names of the single-letter flags they set.
+var configAliases = map[string]string{
+	"dir":         "d",
+	"verbose":     "v",
+	"interactive": "i",
+	"dry-run":     "n",
+}
+
+// loadConfig sets the default values of any flags that weren't given
+// explicitly on the command line from the config files, if they exist. Config
+// file keys are flag names (e.g. "proxy", or "max-gap"), or the long names of
+// single-letter flags (e.g. "verbose"), and list values are joined with
+// commas.
+func loadConfig(dir string) error {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for _, filePath := range configFiles(dir) {
+		b, err := ioutil.ReadFile(filePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading config file %s: %s", filePath, err)
+		}
+
+		var config map[string]any
+		if filepath.Ext(filePath) == ".toml" {
+			err = toml.Unmarshal(b, &config)
+		} else {
+			err = yaml.Unmarshal(b, &config)
+		}
+		if err != nil {
+			return fmt.Errorf("error parsing config file %s: %s", filePath, err)
+		}
+
+		for key, value := range config {
+			name := key
+			if alias, ok := configAliases[key]; ok {
+				name = alias
+			}
+			if flag.Lookup(name) == nil {
+				return fmt.Errorf("unknown option in config file %s: %s", filePath, key)
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := flag.Set(name, configValue(value)); err != nil {
+				return fmt.Errorf("invalid value for option %s in config file %s: %s", key, filePath, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValue converts a value parsed from a config file into the string form
+// expected by a flag.
+func configValue(value any) string {
+	if values, ok := value.([]any); ok {
+		var strs []string
+		for _, v := range values {
+			strs = append(strs, fmt.Sprint(v))
+		}
+		return strings.Join(strs, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// addIgnoredModules adds the given module paths to the ignore-module list in
+// the module's config file (or to the exclude list, if that's the name it's
+// given there), creating the file or the list if necessary. Paths already in
+// the list aren't added again, and the rest of the file is kept as it is.
+func addIgnoredModules(dir string, paths []string) error {
+	filePath := filepath.Join(dir, configFileName)
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error reading config file %s: %s", filePath, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("error parsing config file %s: %s", filePath, err)
+	}
+	if doc.Kind == 0 { // Empty (or missing) file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	config := doc.Content[0]
+	if config.Kind != yaml.MappingNode {
+		return fmt.Errorf("error parsing config file %s: not a mapping of option names to values", filePath)
+	}
+
+	var list *yaml.Node
+	for i := 0; i+1 < len(config.Content); i += 2 {
+		if name := config.Content[i].Value; name == "ignore-module" || name == "exclude" {
+			list = config.Content[i+1]
+			break
+		}
+	}
+	if list == nil {
+		list = &yaml.Node{Kind: yaml.SequenceNode}
+		config.Content = append(config.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "ignore-module"}, list)
+	}
+
+	// A comma-separated value is turned into a list, to add to
+	if list.Kind == yaml.ScalarNode {
+		var items []*yaml.Node
+		for _, path := range strings.Split(list.Value, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				items = append(items, &yaml.Node{Kind: yaml.ScalarNode, Value: path})
+			}
+		}
+		*list = yaml.Node{Kind: yaml.SequenceNode, Content: items}
+	}
+	if list.Kind != yaml.SequenceNode {
+		return fmt.Errorf("invalid value for option ignore-module in config file %s: not a list", filePath)
+	}
+
+	listed := map[string]bool{}
+	for _, item := range list.Content {
+		listed[item.Value] = true
+	}
+	for _, path := range paths {
+		if !listed[path] {
+			list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: path})
+			listed[path] = true
+		}
+	}
+	list.Style = 0 // One path per line, even if the list was empty ("[]")
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return fmt.Errorf("error encoding config file %s: %s", filePath, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("error encoding config file %s: %s", filePath, err)
+	}
+
+	if err := modupgrade.WriteFile(filePath, buf.Bytes()); err != nil {
+		return fmt.Errorf("error writing config file %s: %s", filePath, err)
+	}
+	return nil
+}
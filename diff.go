@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// diffContext is the number of unchanged lines shown around each change in
+// unified diff output (the same as 'diff -u' and 'git diff').
+const diffContext = 3
+
+// printDiffs prints a unified diff of the changes the upgrade makes to the
+// go.mod file and to each rewritten file, and reports whether there were any.
+// File names are relative to the module directory, with the a/ and b/ prefixes
+// used by git, so that the output can be applied with 'patch -p1'.
+func printDiffs(w io.Writer, dir string, f *modfile.File, files []file) (bool, error) {
+	changed := false
+
+	modFilePath := filepath.Join(dir, "go.mod")
+	before, err := ioutil.ReadFile(modFilePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading module file %s: %s", modFilePath, err)
+	}
+	if d := unifiedDiff("go.mod", before, formatModFile(f)); d != "" {
+		fmt.Fprint(w, d)
+		changed = true
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false, fmt.Errorf("error getting absolute path of module directory: %s", err)
+	}
+	for _, file := range files {
+		before, err := ioutil.ReadFile(file.name)
+		if err != nil {
+			return false, fmt.Errorf("error reading file %s: %s", file.name, err)
+		}
+		after, err := formatFile(file)
+		if err != nil {
+			return false, err
+		}
+
+		name := file.name
+		if rel, err := filepath.Rel(absDir, file.name); err == nil {
+			name = rel
+		}
+		if d := unifiedDiff(filepath.ToSlash(name), before, after); d != "" {
+			fmt.Fprint(w, d)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// diffOp is a single line of an edit script: an unchanged line (' '), a
+// removed line ('-'), or an added line ('+').
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the differences between the before and after contents
+// of the named file in unified diff format, or an empty string if they are the
+// same.
+func unifiedDiff(name string, before, after []byte) string {
+	if string(before) == string(after) {
+		return ""
+	}
+
+	ops := diffLines(splitLines(string(before)), splitLines(string(after)))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", name, name)
+
+	// Group the changes into hunks, merging changes separated by no more
+	// than twice the number of context lines
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*diffContext {
+				break
+			}
+			end = next
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		writeHunk(&b, ops, start, end)
+		i = end
+	}
+	return b.String()
+}
+
+// writeHunk writes the hunk of the edit script between the given indexes,
+// preceded by its header.
+func writeHunk(b *strings.Builder, ops []diffOp, start, end int) {
+	// Line numbers are 1-based, and count the lines before the hunk in each
+	// version of the file
+	oldLine, newLine := 1, 1
+	for _, op := range ops[:start] {
+		if op.kind != '+' {
+			oldLine++
+		}
+		if op.kind != '-' {
+			newLine++
+		}
+	}
+
+	oldCount, newCount := 0, 0
+	for _, op := range ops[start:end] {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+
+	// An empty range refers to the line before it
+	if oldCount == 0 {
+		oldLine--
+	}
+	if newCount == 0 {
+		newLine--
+	}
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+
+	for _, op := range ops[start:end] {
+		b.WriteByte(op.kind)
+		b.WriteString(op.line)
+		if !strings.HasSuffix(op.line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+func hunkRange(line, count int) string {
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// splitLines splits s into lines, keeping the trailing newline of each.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns an edit script that turns the old lines into the new ones,
+// based on their longest common subsequence. Rewriting imports only changes a
+// few lines of each file, so the common prefix and suffix are trimmed first, to
+// keep the (quadratic) comparison of the remaining lines small.
+func diffLines(oldLines, newLines []string) []diffOp {
+	prefix := 0
+	for prefix < len(oldLines) && prefix < len(newLines) && oldLines[prefix] == newLines[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldLines)-prefix && suffix < len(newLines)-prefix &&
+		oldLines[len(oldLines)-1-suffix] == newLines[len(newLines)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range oldLines[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+
+	a := oldLines[prefix : len(oldLines)-suffix]
+	b := newLines[prefix : len(newLines)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+
+	for _, line := range oldLines[len(oldLines)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestUnifiedDiff(t *testing.T) {
+	const before = `package sample
+
+import (
+	"fmt"
+
+	"github.com/some/dependency"
+)
+
+func Hello() {
+	fmt.Println(dependency.Hello())
+}
+`
+	const after = `package sample
+
+import (
+	"fmt"
+
+	"github.com/some/dependency/v2"
+)
+
+func Hello() {
+	fmt.Println(dependency.Hello())
+}
+`
+	// Unchanged blank lines are prefixed with a space, like any other line
+	const expected = "--- a/sample.go\n" +
+		"+++ b/sample.go\n" +
+		"@@ -3,7 +3,7 @@\n" +
+		" import (\n" +
+		" \t\"fmt\"\n" +
+		" \n" +
+		"-\t\"github.com/some/dependency\"\n" +
+		"+\t\"github.com/some/dependency/v2\"\n" +
+		" )\n" +
+		" \n" +
+		" func Hello() {\n"
+	if d := unifiedDiff("sample.go", []byte(before), []byte(after)); d != expected {
+		t.Errorf("Unexpected diff:\n%s\nExpected:\n%s", d, expected)
+	}
+
+	if d := unifiedDiff("sample.go", []byte(before), []byte(before)); d != "" {
+		t.Errorf("Expected no diff for identical files, got:\n%s", d)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// stringList is a flag.Value that collects a list of strings. The flag can be
+// given multiple times, and each value can contain a comma-separated list.
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+// match reports whether s matches any of the patterns in the list, using the
+// syntax of path.Match (e.g. "golang.org/x/*"). As in the go command's package
+// patterns, a trailing "/..." matches any path with the preceding prefix (e.g.
+// "golang.org/x/..." also matches "golang.org/x/foo/v2").
+func (l stringList) match(s string) bool {
+	for _, pattern := range l {
+		if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+			if s == prefix || strings.HasPrefix(s, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePatterns returns an error if any of the patterns in the list is
+// malformed.
+func (l stringList) validatePatterns() error {
+	for _, pattern := range l {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
+}
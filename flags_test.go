@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestStringListMatch(t *testing.T) {
+	patterns := stringList{"golang.org/x/*", "github.com/myorg/..."}
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "golang.org/x/mod", expected: true},
+		{path: "golang.org/x/mod/v2", expected: false}, // * doesn't match /
+		{path: "github.com/myorg", expected: true},
+		{path: "github.com/myorg/repo/v3", expected: true},
+		{path: "github.com/myorganization/repo", expected: false},
+		{path: "github.com/other/repo", expected: false},
+	}
+	for _, test := range tests {
+		if got := patterns.match(test.path); got != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.path, test.expected, got)
+		}
+	}
+}
+
+func TestIgnoreModuleAlias(t *testing.T) {
+	t.Cleanup(func() { ignoreModules = nil })
+
+	// Both flags add to the same list of patterns
+	if err := flag.Set("ignore-module", "github.com/foo/bar"); err != nil {
+		t.Fatalf("Error setting -ignore-module: %s", err)
+	}
+	if err := flag.Set("exclude", "golang.org/x/*"); err != nil {
+		t.Fatalf("Error setting -exclude: %s", err)
+	}
+
+	for _, path := range []string{"github.com/foo/bar", "golang.org/x/mod"} {
+		if !ignoreModules.match(path) {
+			t.Errorf("%s: expected module to be ignored", path)
+		}
+	}
+	if ignoreModules.match("github.com/foo/baz") {
+		t.Errorf("github.com/foo/baz: expected module not to be ignored")
+	}
+}
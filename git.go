@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// commitUpgrades creates a git commit containing the changes made by the
+// given upgrades to the given files (the module's go.mod and go.sum files,
+// and the files whose imports were rewritten). The commit is skipped if there
+// are no changes to commit.
+func commitUpgrades(ctx context.Context, dir string, upgrades []upgrade, filenames []string) error {
+	var summaries []string
+	for _, upgrade := range upgrades {
+		summaries = append(summaries, upgrade.String())
+	}
+	msg := strings.ReplaceAll(*commitMsg, "{upgrades}", strings.Join(summaries, ", "))
+
+	// Only stage the files the upgrade modified, skipping those that don't
+	// exist (e.g. a go.sum file, if the module has no dependencies), which
+	// git would otherwise fail on
+	args := []string{"-C", dir, "add", "-A", "--"}
+	for _, filename := range filenames {
+		if _, err := os.Stat(filename); err == nil {
+			args = append(args, filename)
+		}
+	}
+	if out, err := exec.CommandContext(ctx, "git", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error executing 'git add' command: %s\n%s", err, out)
+	}
+
+	// 'git diff --cached --quiet' exits with status 1 if there are staged
+	// changes, and 0 if there aren't
+	if err := exec.CommandContext(ctx, "git", "-C", dir, "diff", "--cached", "--quiet").Run(); err == nil {
+		if *verbose {
+			fmt.Fprintln(stdout, "No changes to commit")
+		}
+		return nil
+	} else if _, ok := err.(*exec.ExitError); !ok {
+		return fmt.Errorf("error executing 'git diff' command: %s", err)
+	}
+
+	if out, err := exec.CommandContext(ctx, "git", "-C", dir, "commit", "-m", msg).CombinedOutput(); err != nil {
+		return fmt.Errorf("error executing 'git commit' command: %s\n%s", err, out)
+	}
+	fmt.Fprintf(stdout, "Committed: %s\n", msg)
+	return nil
+}
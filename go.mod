@@ -3,8 +3,10 @@ module github.com/nathanjcochran/upgrade
 go 1.22
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	golang.org/x/mod v0.17.0
 	golang.org/x/tools v0.20.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sync v0.7.0 // indirect
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/version"
+
+	"golang.org/x/mod/modfile"
+)
+
+// requiredGoVersion returns the highest go version declared in the go.mod
+// files of the upgraded dependencies (at their new versions), along with the
+// path of the dependency that declares it. The version is empty if none of
+// them declare one.
+func requiredGoVersion(ctx context.Context, dir string, upgrades []upgrade) (string, string, error) {
+	var queries []string
+	for _, u := range upgrades {
+		if u.NewVersion != "" {
+			queries = append(queries, u.NewPath+"@"+u.NewVersion)
+		}
+	}
+	return highestGoVersion(ctx, dir, queries)
+}
+
+// highestGoVersion returns the highest go version declared in the go.mod files
+// of the given module versions (as path@version queries), along with the path
+// of the module that declares it.
+func highestGoVersion(ctx context.Context, dir string, queries []string) (string, string, error) {
+	if len(queries) == 0 {
+		return "", "", nil
+	}
+
+	results, err := lister.ListModules(ctx, dir, nil, queries...)
+	if err != nil {
+		return "", "", fmt.Errorf("error getting module info: %s", err)
+	}
+
+	var goVersion, path string
+	for _, result := range results {
+		if result.Error != nil || result.GoVersion == "" {
+			continue
+		}
+		if goVersion == "" || version.Compare("go"+result.GoVersion, "go"+goVersion) > 0 {
+			goVersion, path = result.GoVersion, result.Path
+		}
+	}
+	return goVersion, path, nil
+}
+
+// updateGoDirective raises the go directive in the go.mod file if any of the
+// upgraded dependencies require a higher go version, when the -go-update flag
+// is given. Otherwise, it only warns about it, since raising the go directive
+// changes the semantics of the module (e.g. of loop variables in go 1.22).
+func updateGoDirective(ctx context.Context, dir string, file *modfile.File, upgrades []upgrade) {
+	required, path, err := requiredGoVersion(ctx, dir, upgrades)
+	if err != nil {
+		fatalf("Error getting go versions required by upgraded modules: %s", err)
+	}
+	if required == "" {
+		return
+	}
+
+	var current string
+	if file.Go != nil {
+		current = file.Go.Version
+	}
+	if current != "" && version.Compare("go"+required, "go"+current) <= 0 {
+		return
+	}
+
+	if !*goUpdate {
+		fmt.Fprintf(stdout, "Warning: %s requires go %s, but go.mod declares go %s (use -go-update to update the go directive)\n",
+			path, required, current,
+		)
+		return
+	}
+
+	if err := file.AddGoStmt(required); err != nil {
+		fatalf("Error updating go directive to %s: %s", required, err)
+	}
+	fmt.Fprintf(stdout, "Warning: go directive updated from %s to %s (required by %s), which can change the semantics of the module\n",
+		current, required, path,
+	)
+
+	updateToolchainDirective(file)
+}
+
+// validGoVersion reports whether the given version (e.g. "1.22", or "1.22.1")
+// is a valid go version for the go directive.
+func validGoVersion(v string) bool {
+	return version.IsValid("go"+v) && modfile.GoVersionRE.MatchString(v)
+}
+
+// setGoVersion sets the go directive in the go.mod file to the version given
+// with -goversion, regardless of the upgrades. It warns if the version is lower
+// than the go version required by any of the module's dependencies, since the
+// go command would then refuse to build the module (or raise it again).
+func setGoVersion(ctx context.Context, dir string, file *modfile.File) {
+	var queries []string
+	for _, require := range file.Require {
+		queries = append(queries, require.Mod.Path+"@"+require.Mod.Version)
+	}
+	required, path, err := highestGoVersion(ctx, dir, queries)
+	if err != nil {
+		fatalf("Error getting go versions required by dependencies: %s", err)
+	}
+
+	var current string
+	if file.Go != nil {
+		current = file.Go.Version
+	}
+	if current != *goVersion {
+		if err := file.AddGoStmt(*goVersion); err != nil {
+			fatalf("Error updating go directive to %s: %s", *goVersion, err)
+		}
+		fmt.Fprintf(stdout, "go directive updated from %s to %s\n", current, *goVersion)
+	}
+
+	if required != "" && version.Compare("go"+*goVersion, "go"+required) < 0 {
+		fmt.Fprintf(stdout, "Warning: %s requires go %s, but go.mod declares go %s\n",
+			path, required, *goVersion,
+		)
+	}
+
+	updateToolchainDirective(file)
+}
+
+// updateToolchainDirective removes the toolchain directive from the go.mod file
+// if it names an older toolchain than the one implied by the go directive
+// (e.g. "toolchain go1.21.3" after updating to "go 1.22"), which would otherwise
+// be inconsistent. The go command itself omits the toolchain directive when the
+// go directive implies it. Custom toolchains (e.g. "go1.22.1-custom") are kept
+// if they are new enough, as is "toolchain default".
+func updateToolchainDirective(file *modfile.File) {
+	if file.Toolchain == nil || file.Go == nil || file.Toolchain.Name == "default" {
+		return
+	}
+
+	toolchain := file.Toolchain.Name
+	if version.Compare(toolchain, "go"+file.Go.Version) >= 0 {
+		return
+	}
+
+	file.DropToolchainStmt()
+	fmt.Fprintf(stdout, "Warning: toolchain directive %s removed, since it is older than go %s (which implies the minimum toolchain)\n",
+		toolchain, file.Go.Version,
+	)
+}
@@ -1,145 +1,166 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
-	"go/format"
 	"go/token"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"golang.org/x/mod/module"
+	"github.com/nathanjcochran/upgrade/modupgrade"
 	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/imports"
 )
 
-type upgrade struct {
-	oldPath string
-	newPath string
-}
+// upgrade and rewrite are the library's types, used throughout the command.
+type (
+	upgrade = modupgrade.Upgrade
+	rewrite = modupgrade.Rewrite
+)
 
 type file struct {
-	name string
-	ast  *ast.File
-	fset *token.FileSet
+	name     string
+	ast      *ast.File
+	fset     *token.FileSet
+	rewrites []rewrite // Import paths that were rewritten
 }
 
-func rewriteImports(dir string, upgrades []upgrade) error {
-	if len(upgrades) == 0 {
-		return nil
-	}
+// fileErrors are the errors rewriting the imports of individual files, which
+// are skipped with -continue-on-error.
+type fileErrors = modupgrade.FileErrors
 
-	upgradeMap := map[string]string{}
+// rewriteImports rewrites the import paths affected by the given upgrades in
+// the module's .go files. The files are only modified in memory: the returned
+// files must be written to disk with writeFiles. With -continue-on-error, files
+// whose imports can't be rewritten are skipped, and the returned fileErrors
+// (alongside the files that were rewritten) lists them.
+func rewriteImports(ctx context.Context, dir string, upgrades []upgrade) ([]file, error) {
+	// Paths can be the same in case of minor version update, in which case
+	// there's nothing to rewrite (or to load)
+	pathChanged := false
 	for _, upgrade := range upgrades {
-		upgradeMap[upgrade.oldPath] = upgrade.newPath
+		if upgrade.NewPath != upgrade.OldPath {
+			pathChanged = true
+		}
+	}
+	if !pathChanged {
+		return nil, nil
 	}
 
-	absDir, err := filepath.Abs(dir)
+	pkgs, err := loadPackages(ctx, dir)
 	if err != nil {
-		return fmt.Errorf("error getting absolute path of module directory: %s", err)
+		return nil, fmt.Errorf("error loading packages: %s", err)
 	}
 
-	pkgs, err := loadPackages(dir)
-	if err != nil {
-		return fmt.Errorf("error loading packages: %s", err)
+	rewritten, err := newUpgrader(dir, nil, modupgrade.WithPackages(pkgs)).RewriteImports(ctx, upgrades)
+	var skipped fileErrors
+	if !errors.As(err, &skipped) && err != nil {
+		return nil, err
 	}
 
-	var (
-		modified     = []file{}
-		filesVisited = map[string]bool{}
-	)
-	for _, pkg := range pkgs {
-		if *verbose {
-			fmt.Printf("Package: %s\n", pkg.PkgPath)
-		}
-		for i, fileAST := range pkg.Syntax {
-			filename := pkg.CompiledGoFiles[i]
-
-			// Skip the file if it isn't located within the module directory.
-			// This is particularly important for preventing changes to "test
-			// binary" files, which are typically located in the user's
-			// $HOME/.cache/go-build/ directory, and should not be modified
-			// (but are returned when loading test packages).
-			// NOTE: This feels a little hacky, but I could not find a more
-			// reliable way to identify the test binary package or ignore its
-			// files. See: https://github.com/nathanjcochran/upgrade/issues/2.
-			if !strings.HasPrefix(filename, absDir) {
-				continue
+	modified := []file{}
+	for _, f := range rewritten {
+		// With -diff, the rewrites are shown in the diff instead (and with
+		// -v, they've been shown already)
+		if *dryRun && !*showDiff && !*verbose {
+			fmt.Fprintf(stdout, "%s:\n", f.Name)
+			for _, rewrite := range f.Rewrites {
+				fmt.Fprintf(stdout, "\t%s -> %s\n", rewrite.OldImportPath, rewrite.NewImportPath)
 			}
+		}
+		modified = append(modified, file{
+			name:     f.Name,
+			ast:      f.AST,
+			fset:     f.Fset,
+			rewrites: f.Rewrites,
+		})
+	}
 
-			// Skip the file if we've already visited it (including test
-			// packages means some files can appear more than once)
-			if filesVisited[filename] {
-				continue
-			}
-			filesVisited[filename] = true
-
-			var found bool
-			for _, fileImp := range fileAST.Imports {
-				importPath := strings.Trim(fileImp.Path.Value, "\"")
-
-				// We have to actually compare module paths, not just import
-				// path prefixes. Imagine upgrading dep to dep/v5, but dep/v3
-				// is also installed. If we only looked at import paths, we'd
-				// be liable to get dep/v5/v3, which is invalid.
-				impPkg, exists := pkg.Imports[importPath]
-				if !exists {
-					return fmt.Errorf("error getting package information for import %s: %s", importPath, err)
-				}
-
-				// NOTE: Some imports, such as standard library packages, do
-				// not have a corresponding module. In these case, we default
-				// to the package name as it was specified in the import
-				// statement (it won't be updated).
-				modulePath := importPath
-				if impPkg.Module != nil {
-					modulePath = impPkg.Module.Path
-				}
-
-				if newPath, ok := upgradeMap[modulePath]; ok {
-					if !found {
-						found = true
-						if *verbose {
-							fmt.Printf("%s:\n", filename)
-						}
-					}
-
-					newImportPath := strings.Replace(importPath, modulePath, newPath, 1)
-					if err := module.CheckImportPath(newImportPath); err != nil {
-						return fmt.Errorf("invalid import path after upgrade: %s", newImportPath)
-					}
-					fileImp.Path.Value = fmt.Sprintf("\"%s\"", newImportPath)
-
-					if *verbose {
-						fmt.Printf("\t%s -> %s\n", importPath, newImportPath)
-					}
-				}
-			}
+	if len(skipped) > 0 {
+		return modified, skipped
+	}
+	return modified, nil
+}
 
-			// If any of the file's import paths were updated, write it to disk
-			if found {
-				modified = append(modified, file{
-					name: filename,
-					ast:  fileAST,
-					fset: pkg.Fset,
-				})
+// rewriteModuleImports rewrites the import paths affected by the given
+// upgrades in the module's .go files (and, with -vendor, in its vendored
+// files), in memory. With -no-rewrite, it only warns that they need rewriting.
+// With -continue-on-error, the files that were skipped are returned as an
+// error, alongside the rest.
+func rewriteModuleImports(ctx context.Context, dir string, upgrades []upgrade) ([]file, error) {
+	if *noRewrite {
+		for _, upgrade := range upgrades {
+			if upgrade.NewPath != upgrade.OldPath {
+				fmt.Fprintf(stdout, "Warning: import paths not rewritten (-no-rewrite): the module won't build until imports of %s are changed to %s\n",
+					upgrade.OldPath, upgrade.NewPath,
+				)
 			}
 		}
+		return nil, nil
 	}
 
-	// Write modified files at the end, to avoid issues with "go list"
-	// during the process (in case the upgrade breaks the build)
-	for _, file := range modified {
+	files, err := rewriteImports(ctx, dir, upgrades)
+	var skipped fileErrors
+	if !errors.As(err, &skipped) && err != nil {
+		fatalf("Error rewriting imports: %s", err)
+	}
+	if *vendor && hasVendorDir(dir) {
+		vendorFiles, err := rewriteVendorImports(dir, upgrades)
+		if err != nil {
+			fatalf("Error rewriting vendored imports: %s", err)
+		}
+		files = append(files, vendorFiles...)
+	}
+	if len(skipped) > 0 {
+		return files, skipped
+	}
+	return files, nil
+}
+
+// writeFiles writes the rewritten files to disk, and returns the absolute paths
+// of the files written (which, if there's an error, are those written before
+// it).
+func writeFiles(files []file) ([]string, error) {
+	var written []string
+	for _, file := range files {
 		if err := writeFile(file); err != nil {
-			return fmt.Errorf("error writing file: %s", err)
+			return written, fmt.Errorf("error writing file: %s", err)
+		}
+		filename, err := filepath.Abs(file.name)
+		if err != nil {
+			return written, fmt.Errorf("error getting absolute path of file %s: %s", file.name, err)
 		}
+		written = append(written, filename)
 	}
-	return nil
+	return written, nil
 }
 
-func loadPackages(dir string) ([]*packages.Package, error) {
+// loadProgressDelay is how long loading packages can take before a progress
+// message is printed.
+const loadProgressDelay = 10 * time.Second
+
+func loadPackages(ctx context.Context, dir string) ([]*packages.Package, error) {
+	// Loading packages is subject to its own timeout, rather than -timeout
+	ctx, cancel := withoutTimeout(ctx)
+	defer cancel()
+	if *loadTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *loadTimeout)
+		defer cancel()
+	}
+
+	// Loading packages can be slow for large modules (especially the first
+	// time), so let the user know the tool hasn't hung
+	timer := time.AfterFunc(loadProgressDelay, func() {
+		fmt.Fprintln(stdout, "Still loading packages... (this may take a while for large modules)")
+	})
+	defer timer.Stop()
+
 	cfg := &packages.Config{
+		Context: ctx,
 		Mode: packages.NeedName |
 			packages.NeedCompiledGoFiles |
 			packages.NeedImports |
@@ -148,10 +169,37 @@ func loadPackages(dir string) ([]*packages.Package, error) {
 			packages.NeedSyntax |
 			packages.NeedModule,
 		Tests: true, // Necessary to rewrite imports in _test.go files
+
+		// Files over -max-file-size are skipped, so aren't parsed in full
+		ParseFile: modupgrade.ParseFile(*maxFileSize),
 	}
-	loadPath := fmt.Sprintf("%s/...", path.Clean(dir))
-	pkgs, err := packages.Load(cfg, loadPath)
+	cfg.Env = goEnv()
+	if *cgoEnabled {
+		if cfg.Env == nil {
+			cfg.Env = os.Environ()
+		}
+		cfg.Env = append(cfg.Env, "CGO_ENABLED=1")
+	}
+
+	// Files excluded by build constraints (e.g. //go:build linux) are only
+	// loaded (and therefore only rewritten) if their tags are given
+	if *tags != "" {
+		cfg.BuildFlags = []string{"-tags", *tags}
+	}
+
+	// Load the packages relative to the module directory itself, rather
+	// than the current working directory (which may be outside the module)
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path of module directory: %s", err)
+	}
+	cfg.Dir = absDir
+
+	pkgs, err := packages.Load(cfg, "./...")
 	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out loading package info after %s (see -load-timeout)", *loadTimeout)
+		}
 		return nil, fmt.Errorf("error loading package info: %s", err)
 	}
 
@@ -159,19 +207,66 @@ func loadPackages(dir string) ([]*packages.Package, error) {
 		return nil, fmt.Errorf("failed to find/load package info")
 	}
 
+	// Packages that use cgo can't be loaded properly when cgo is disabled
+	// (e.g. CGO_ENABLED=0), so give the user a targeted suggestion
+	for _, pkg := range pkgs {
+		for _, pkgErr := range pkg.Errors {
+			if isCgoError(pkgErr.Msg) {
+				return nil, fmt.Errorf("package %s requires cgo, but cgo appears to be disabled (try running with -cgo-enabled, or CGO_ENABLED=1): %s",
+					pkg.PkgPath, pkgErr.Msg,
+				)
+			}
+		}
+	}
+
 	return pkgs, nil
 }
 
+var cgoErrors = []string{
+	"could not import C",
+	"cgo preprocessing failed",
+	"C source files not allowed when not using cgo",
+}
+
+func isCgoError(msg string) bool {
+	for _, cgoErr := range cgoErrors {
+		if strings.Contains(msg, cgoErr) {
+			return true
+		}
+	}
+	return false
+}
+
 func writeFile(file file) error {
-	f, err := os.Create(file.name)
+	out, err := formatFile(file)
 	if err != nil {
-		return fmt.Errorf("error opening file %s: %s", file.name, err)
+		return err
 	}
-	defer f.Close()
-
-	if err := format.Node(f, file.fset, file.ast); err != nil {
+	if err := modupgrade.WriteFile(file.name, out); err != nil {
 		return fmt.Errorf("error writing file %s: %s", file.name, err)
 	}
-
 	return nil
 }
+
+// formatFile returns the formatted contents of the rewritten file. With
+// -goimports, its imports are also sorted and grouped as by 'goimports -local'
+// (standard library packages first, then third-party packages, then the
+// packages of the prefixes in imports.LocalPrefix).
+func formatFile(file file) ([]byte, error) {
+	out, err := modupgrade.File{Name: file.name, AST: file.ast, Fset: file.fset}.Format()
+	if err != nil || !*goimports {
+		return out, err
+	}
+
+	// Only the existing imports are regrouped: none are added or removed
+	out, err = imports.Process(file.name, out, &imports.Options{
+		FormatOnly: true,
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error running goimports on file %s: %s", file.name, err)
+	}
+	return out, nil
+}
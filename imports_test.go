@@ -0,0 +1,188 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/imports"
+)
+
+const testSource = `package sample
+
+import (
+	"fmt"
+
+	"github.com/some/dependency"
+)
+
+func Hello() {
+	fmt.Println(dependency.Hello())
+}
+`
+
+func parseTestFile(t *testing.T) file {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), "sample.go")
+	if err := ioutil.WriteFile(filename, []byte(testSource), 0644); err != nil {
+		t.Fatalf("Error writing test file: %s", err)
+	}
+
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing test file: %s", err)
+	}
+
+	return file{
+		name: filename,
+		ast:  fileAST,
+		fset: fset,
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	f := parseTestFile(t)
+	f.ast.Imports[1].Path.Value = `"github.com/some/dependency/v2"`
+
+	if err := writeFile(f); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(f.name)
+	if err != nil {
+		t.Fatalf("Error reading written file: %s", err)
+	}
+	expected := strings.Replace(testSource, "github.com/some/dependency", "github.com/some/dependency/v2", 1)
+	if string(b) != expected {
+		t.Errorf("Unexpected file contents:\n%s\nExpected:\n%s", b, expected)
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	f := parseTestFile(t)
+	f.ast.Imports[1].Path.Value = `"github.com/some/dependency/v2"`
+
+	// The written files are reported by absolute path, even if given by a
+	// relative one
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Error getting working directory: %s", err)
+	}
+	expected := f.name
+	if f.name, err = filepath.Rel(wd, f.name); err != nil {
+		t.Fatalf("Error getting relative path: %s", err)
+	}
+
+	written, err := writeFiles([]file{f})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(written) != 1 || written[0] != expected {
+		t.Errorf("Expected written files [%s], got %v", expected, written)
+	}
+}
+
+func TestWriteFileFormatError(t *testing.T) {
+	f := parseTestFile(t)
+
+	// A bad declaration makes format.Node fail: files with grouped imports
+	// are printed and re-parsed in order to sort their imports, and the
+	// printed declaration can't be parsed
+	f.ast.Decls = append(f.ast.Decls, &ast.BadDecl{})
+
+	err := writeFile(f)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), f.name) {
+		t.Errorf("Expected error to include filename %s, got: %s", f.name, err)
+	}
+
+	// The original file must not have been truncated or partially written
+	b, readErr := ioutil.ReadFile(f.name)
+	if readErr != nil {
+		t.Fatalf("Error reading file: %s", readErr)
+	}
+	if string(b) != testSource {
+		t.Errorf("Original file was modified:\n%s", b)
+	}
+}
+
+func TestWriteFileMissingDirectory(t *testing.T) {
+	f := parseTestFile(t)
+	f.name = filepath.Join(t.TempDir(), "missing", "sample.go")
+
+	if err := writeFile(f); err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+}
+
+func TestWriteFileGoimports(t *testing.T) {
+	orig, origPrefix := *goimports, imports.LocalPrefix
+	*goimports, imports.LocalPrefix = true, "example.com/sample"
+	t.Cleanup(func() { *goimports, imports.LocalPrefix = orig, origPrefix })
+
+	// All the imports are in a single group, which goimports splits into
+	// standard library, third-party, and local groups
+	const source = `package sample
+
+import (
+	"example.com/sample/util"
+	"fmt"
+	"github.com/some/dependency"
+)
+`
+	const expected = `package sample
+
+import (
+	"fmt"
+
+	"github.com/some/dependency/v2"
+
+	"example.com/sample/util"
+)
+`
+	filename := filepath.Join(t.TempDir(), "sample.go")
+	if err := ioutil.WriteFile(filename, []byte(source), 0644); err != nil {
+		t.Fatalf("Error writing test file: %s", err)
+	}
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing test file: %s", err)
+	}
+	fileAST.Imports[2].Path.Value = `"github.com/some/dependency/v2"`
+
+	if err := writeFile(file{name: filename, ast: fileAST, fset: fset}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error reading written file: %s", err)
+	}
+	if string(b) != expected {
+		t.Errorf("Unexpected file contents:\n%s\nExpected:\n%s", b, expected)
+	}
+}
+
+func TestIsCgoError(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"could not import C (no metadata for C)":                    true,
+		"C source files not allowed when not using cgo: answer.c":   true,
+		"cgo preprocessing failed":                                  true,
+		"undefined: dependency.Hello":                               false,
+		"no required module provides package github.com/foo/bar/v2": false,
+	} {
+		if got := isCgoError(msg); got != want {
+			t.Errorf("isCgoError(%q) = %t, want %t", msg, got, want)
+		}
+	}
+}
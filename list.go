@@ -5,69 +5,297 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
-	"time"
+	"strings"
+	"sync"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
 )
 
-func list(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "go", "list", "-mod=mod", "./...")
+// list runs 'go list' on every package in the module (rather than only the
+// one in the module directory, which may not have any .go files), so that the
+// go command updates the go.mod file with any requirements the upgrade
+// changed. It returns the import paths of the packages, which are empty if the
+// module doesn't contain any.
+func list(ctx context.Context, dir string) ([]string, error) {
+	// The -mod=mod flag can't be used in workspace mode
+	args := []string{"list", "-mod=mod", "./..."}
+	if inWorkspace(ctx, dir) {
+		args = []string{"list", "./..."}
+	}
 
-	if err := cmd.Run(); err != nil {
-		if err := err.(*exec.ExitError); err != nil {
-			fmt.Println(string(err.Stderr)) // TODO: Remove
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = dir
+	cmd.Env = goEnv()
+
+	out, err := cmd.Output()
+	if err != nil {
+		if timedOut(ctx) {
+			return nil, fmt.Errorf("timed out after %s executing 'go list' command (see -timeout)", *timeout)
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("error executing 'go list' command: %s: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("error executing 'go list' command: %s", err)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// tidy runs 'go mod tidy' in the module directory, to add any missing
+// requirements (and remove unused ones) after the upgrade.
+func tidy(ctx context.Context, dir string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "mod", "tidy")
+	cmd.Dir = dir
+	cmd.Env = goEnv()
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if *verbose && stderr.Len() > 0 {
+		fmt.Fprintf(stdout, "Warning: 'go mod tidy' output:\n%s", stderr.String())
+	}
+	if err != nil {
+		if timedOut(ctx) {
+			return fmt.Errorf("timed out after %s executing 'go mod tidy' command (see -timeout)", *timeout)
 		}
-		return fmt.Errorf("error executing 'go list' command: %s", err)
+		return fmt.Errorf("error executing 'go mod tidy' command: %s: %s", err, strings.TrimSpace(stderr.String()))
 	}
 	return nil
 }
 
-// From "go help list" output
-type Module struct {
-	Path       string       // module path
-	Query      string       // version query corresponding to this version
-	Version    string       // module version
-	Versions   []string     // available module versions
-	Replace    *Module      // replaced by this module
-	Time       *time.Time   // time version was created
-	Update     *Module      // available update (with -u)
-	Main       bool         // is this the main module?
-	Indirect   bool         // module is only indirectly needed by main module
-	Dir        string       // directory holding local copy of files, if any
-	GoMod      string       // path to go.mod file describing module, if any
-	GoVersion  string       // go version used in module
-	Retracted  []string     // retraction information, if any (with -retracted or -u)
-	Deprecated string       // deprecation message, if any (with -u)
-	Error      *ModuleError // error loading module
-	Origin     any          // provenance of module
-	Reuse      bool         // reuse of old module info is safe
+// verify runs 'go mod verify' in the module directory, to check that the
+// dependencies in the module cache (including the upgraded ones) haven't been
+// modified since they were downloaded.
+func verify(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "go", "mod", "verify")
+	cmd.Dir = dir
+	cmd.Env = goEnv()
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if timedOut(ctx) {
+			return fmt.Errorf("timed out after %s executing 'go mod verify' command (see -timeout)", *timeout)
+		}
+		return fmt.Errorf("error executing 'go mod verify' command: %s (the module cache may be corrupted: try running 'go clean -modcache'):\n%s", err, strings.TrimSpace(string(out)))
+	}
+	if *verbose {
+		fmt.Fprint(stdout, string(out))
+	}
+	return nil
 }
 
-type ModuleError struct {
-	Err string // the error itself
+// build runs 'go build ./...' in the module directory, to check that it still
+// compiles after the upgrade (e.g. that the new major version of a dependency
+// didn't remove an API the module uses). The build errors are returned.
+func build(ctx context.Context, dir string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "build", "./...")
+	cmd.Dir = dir
+	cmd.Env = goEnv()
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error executing 'go build ./...' command: %s:\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
 }
 
-func listModules(ctx context.Context, modulePaths ...string) ([]Module, error) {
-	cmd := exec.CommandContext(ctx,
-		"go", append([]string{"list", "-m", "-u", "-e", "-json", "-mod=readonly"},
-			modulePaths...,
-		)...,
-	)
+// download runs 'go mod download' in the module directory, to populate the
+// module cache with the upgraded modules. It returns an error listing any
+// modules that failed to download.
+func download(ctx context.Context, dir string) error {
+	cmd := exec.CommandContext(ctx, "go", "mod", "download", "-json")
+	cmd.Dir = dir
+	cmd.Env = goEnv()
 	out, err := cmd.Output()
-	if err != nil {
-		if err := err.(*exec.ExitError); err != nil {
-			fmt.Println(string(err.Stderr)) // TODO: Remove
-		}
-		return nil, fmt.Errorf("error executing 'go list -m -u -e -json -mod=readonly' command: %s", err)
+	if err != nil && timedOut(ctx) {
+		return fmt.Errorf("timed out after %s executing 'go mod download' command (see -timeout)", *timeout)
 	}
 
-	var results []Module
+	// The go command exits with a non-zero status if any module failed to
+	// download, but still reports each module (and its error, if any)
+	var failed []string
 	decoder := json.NewDecoder(bytes.NewReader(out))
 	for decoder.More() {
-		var result Module
+		var result struct {
+			Path    string
+			Version string
+			Error   string
+		}
 		if err := decoder.Decode(&result); err != nil {
-			return nil, fmt.Errorf("error parsing results of 'go list -m -u -e -json -mod=readonly' command: %s", err)
+			return fmt.Errorf("error parsing results of 'go mod download' command: %s", err)
+		}
+		if result.Error != "" {
+			failed = append(failed, fmt.Sprintf("%s %s: %s", result.Path, result.Version, result.Error))
+		} else if *verbose {
+			fmt.Fprintf(stdout, "Downloaded %s %s\n", result.Path, result.Version)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to download %d module(s):\n\t%s", len(failed), strings.Join(failed, "\n\t"))
+	}
+	if err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("error executing 'go mod download' command: %s: %s", err, strings.TrimSpace(string(err.Stderr)))
+		}
+		return fmt.Errorf("error executing 'go mod download' command: %s", err)
+	}
+	return nil
+}
+
+// goEnv returns the environment in which to run the go command, or nil to
+// inherit the environment of the tool itself.
+func goEnv() []string {
+	if *proxy == "" && *private == "" {
+		return nil
+	}
+
+	env := os.Environ()
+
+	// GONOSUMDB is set explicitly as well, since an inherited value would
+	// otherwise take precedence over the one implied by GOPRIVATE
+	if *private != "" {
+		env = append(env, "GOPRIVATE="+*private, "GONOSUMDB="+*private)
+	}
+
+	if *proxy != "" {
+		env = append(env, "GOPROXY="+*proxy)
+
+		// Modules fetched directly from their origin (e.g. private modules)
+		// often aren't in the checksum database, so don't consult it for them
+		if *proxy == "direct" {
+			env = append(env, "GONOSUMDB=*")
+		}
+	}
+	return env
+}
+
+// inWorkspace reports whether the go command runs in workspace mode in the
+// given directory.
+func inWorkspace(ctx context.Context, dir string) bool {
+	cmd := exec.CommandContext(ctx, "go", "env", "GOWORK")
+	cmd.Dir = dir
+	cmd.Env = goEnv()
+	out, err := cmd.Output()
+	if err != nil {
+		return false
+	}
+
+	gowork := strings.TrimSpace(string(out))
+	return gowork != "" && gowork != "off"
+}
+
+// lister is used to query module information. It is a variable so that tests
+// can provide module information without shelling out to 'go list'.
+var lister modupgrade.Lister = goLister{}
+
+// goLister queries module information using the 'go list -m' command, in
+// read-only mode. It also records the information needed for auditing, and
+// warns about deprecated modules.
+type goLister struct{}
+
+func (goLister) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]modupgrade.Module, error) {
+	results, err := runListModules(ctx, dir, "-mod=readonly", extraFlags, modulePaths)
+	if err != nil && isSumUpdateError(err) {
+		if !*allowSumUpdates {
+			return nil, fmt.Errorf("%s (run 'go mod tidy' to update go.sum before running upgrade, or use -allow-sum-updates)", err)
+		}
+		if *verbose {
+			fmt.Fprintln(stdout, "go.sum is missing checksums: retrying with -mod=mod")
 		}
-		results = append(results, result)
+		results, err = runListModules(ctx, dir, "-mod=mod", extraFlags, modulePaths)
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	recordAudit(results)
+	warnDeprecations(results)
 	return results, nil
 }
+
+// deprecationsWarned holds the deprecation messages of the deprecated modules
+// that have already been warned about, so that each is only warned about once.
+var deprecationsWarned struct {
+	sync.Mutex
+	messages map[string]string
+}
+
+// warnDeprecations prints a warning for each deprecated module in the results.
+// The warning is printed even when not in verbose mode, since the deprecation
+// message usually tells the user what to do instead (e.g. which module to
+// migrate to).
+func warnDeprecations(results []modupgrade.Module) {
+	deprecationsWarned.Lock()
+	defer deprecationsWarned.Unlock()
+
+	for _, result := range results {
+		if _, ok := deprecationsWarned.messages[result.Path]; result.Deprecated == "" || ok {
+			continue
+		}
+		if deprecationsWarned.messages == nil {
+			deprecationsWarned.messages = map[string]string{}
+		}
+		deprecationsWarned.messages[result.Path] = result.Deprecated
+		fmt.Fprintf(stdout, "WARNING: %s is deprecated: %s\n", result.Path, result.Deprecated)
+	}
+}
+
+func runListModules(ctx context.Context, dir, modFlag string, extraFlags, modulePaths []string) ([]modupgrade.Module, error) {
+	l := modupgrade.GoLister{ModFlag: modFlag, Env: goEnv()}
+	results, err := l.ListModules(ctx, dir, extraFlags, modulePaths...)
+	if err != nil {
+		exitIfInterrupted(ctx)
+		if listErr, ok := err.(*modupgrade.ListError); ok {
+			if *verbose {
+				fmt.Fprint(os.Stderr, listErr.Stderr)
+			}
+			if timedOut(ctx) {
+				return nil, fmt.Errorf("timed out after %s executing '%s' command (see -timeout)", *timeout, listErr.Cmd)
+			}
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// timedOut reports whether the deadline set by the -timeout flag has been
+// exceeded.
+func timedOut(ctx context.Context) bool {
+	return ctx.Err() == context.DeadlineExceeded
+}
+
+// withoutTimeout returns a copy of ctx that isn't subject to the deadline set
+// by the -timeout flag, but which is still cancelled if ctx itself is (e.g.
+// because the tool is interrupted). It's used for work that the timeout
+// doesn't apply to, like loading packages.
+func withoutTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	untimed, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		if ctx.Err() == context.Canceled {
+			cancel()
+		}
+	})
+	return untimed, func() {
+		stop()
+		cancel()
+	}
+}
+
+// isSumUpdateError reports whether the 'go list' command failed because
+// go.sum is missing checksums that -mod=readonly prevents it from adding.
+func isSumUpdateError(err error) bool {
+	listErr, ok := err.(*modupgrade.ListError)
+	return ok && strings.Contains(listErr.Stderr, "updates to go.sum needed")
+}
+
+// listFlags returns the extra flags to pass to 'go list -m' when querying
+// module versions.
+func listFlags() []string {
+	if *checkRetracted {
+		return []string{"-retracted"}
+	}
+	return nil
+}
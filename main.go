@@ -2,22 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path"
-	"strconv"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/nathanjcochran/upgrade/modupgrade"
 	"golang.org/x/mod/modfile"
-	"golang.org/x/mod/module"
 	"golang.org/x/mod/semver"
+	"golang.org/x/tools/imports"
 )
 
-const usage = `Usage: %s [-d dir] [-v] [module] [version]
+const usage = `Usage: %s [-d dir] [-v] [-i] [options] [module] [version]
 
 Upgrades the major version of a module, or the major version of one of its
 dependencies, by editing the module's go.mod file and the corresponding import
@@ -29,19 +34,205 @@ its module path (or adding the version component, if necessary).
 
 The same behavior is triggered by supplying the module's own path for the
 [module] argument. However, in that form, a target [version] can also be given,
-making it possible to jump several major versions at once, or to downgrade
-versions.
+making it possible to jump several major versions at once, or, if the
+[-downgrade] flag is given, to downgrade versions (e.g. when reverting a
+mistaken upgrade).
 
 If the module path of a dependency is given, upgrades the dependency to the
 specified version, or, if no version is given, to the highest major version
 available.
 
+The dependency must be required in the go.mod file. If it isn't (e.g. if it's
+only a transitive dependency), the [-require] flag adds a requirement on it
+first, at the version selected in the module graph (or at its latest version,
+if it's not in the graph at all), as 'go get' would, so that it can be adopted
+at a higher major version than the one the module's dependencies use.
+
 If the special target "all" is given, attempts to upgrade all direct
 dependencies in the go.mod file to the highest major version available.
+Dependencies that have been pinned, or that match one of the patterns in the
+[-ignore-module patterns] flag (e.g. -ignore-module='golang.org/x/*', using the
+syntax of Go's path.Match), are skipped (and counted as excluded in the
+summary). The [-exclude] flag is an alias of [-ignore-module]. Conversely, if
+the [-filter patterns] flag is given, only the dependencies that match one of
+its patterns are upgraded (e.g. -filter=github.com/myorg/...). In both flags, a
+pattern ending in "/..." matches any module path with the preceding prefix, and
+the flag can be repeated.
+
+If the [-since date] flag is given along with the "all" target, dependencies
+whose current version was published after the given date (e.g.
+-since=2024-01-01) are skipped, since they were upgraded recently (e.g. by
+someone else, since the last batch of upgrades). Dependencies whose current
+version has no known publication time are upgraded as usual.
+
+If the [-indirect] flag is given along with the "all" target, indirect
+dependencies are upgraded as well. Note that upgrading indirect dependencies
+can have wider cascading effects than upgrading direct ones, since other
+dependencies rely on them.
+
+Only the requirements in the go.mod file (which, as of go 1.17, include every
+module that provides a package the module imports) are upgraded. The
+[-transitive] flag, given along with the "all" target, also reports the other
+modules in the full dependency graph (as listed by 'go list -m all') that have
+a higher major version available. These are only required by the module's
+dependencies, so can't be upgraded by the module itself, but may be holding its
+dependencies (and so the module) back. They are listed after the upgrades,
+and don't affect the exit status.
+
+If the special target "all" is given in the root directory of a workspace
+(i.e. a directory containing a go.work file), the dependencies of every module
+listed in the workspace's use directives are upgraded, one module at a time.
+Modules that other modules in the workspace depend on are upgraded first. If
+the [-work-sync] flag is given, 'go work sync' is run afterwards.
+
+If the module is part of a workspace (i.e. a go.work file is found in its
+directory, or one of its parents, or is named by GOWORK), the go command runs
+in workspace mode, and may consider the other modules in the workspace when
+looking up versions or loading packages. A warning is printed in that case
+(other than when upgrading the whole workspace). The [-no-work] flag
+disables workspace mode (by setting GOWORK=off for every go command the tool
+runs), so that only the module itself is upgraded.
+
+The [-work] flag applies any other target to every module in the workspace in
+the same way. When upgrading a single dependency, modules that don't require it
+are skipped.
+
+The [-recurse] flag performs the upgrade in every module found within the
+module directory (i.e. every directory containing a go.mod file, except within
+vendor and testdata directories, or directories beginning with "." or "_"), one
+module at a time. An error in one module doesn't stop the others from being
+upgraded: all errors are reported at the end.
+
+The [-manifest] flag reads a list of upgrades from a JSON or YAML file (chosen
+by its .json, .yaml or .yml extension) instead of the command line, and makes
+them in the order listed. Each entry gives a "module" to upgrade, and
+optionally a "version" and the "dir" of the module to upgrade it in (relative
+to the manifest file, and the module directory if not given). Every entry is
+validated before any upgrade is made, and the first upgrade that fails stops
+the rest. Setting "dryRun: true" in the manifest makes every upgrade a dry run,
+regardless of the command line.
+
+If the special target "pin" is given, pins all direct dependencies in the
+go.mod file at their current versions, by appending a "// pinned: do not
+upgrade" comment to each require line. Versions are not modified. To allow a
+pinned dependency to be upgraded again, remove the comment. The [-upgrade-yaml]
+flag adds the pinned dependencies to the ignore-module list in the module's
+.upgrade.yaml file instead (remove a dependency from the list to allow it to be
+upgraded again). Give the [-inline-comments] flag as well to do both.
+
+If the special target "security" is given, cross-references the direct
+dependencies in the go.mod file with the Go vulnerability database (or the
+database given by the GOVULNDB environment variable), and reports each known
+vulnerability affecting them, along with the version that fixes it (which may
+be a higher major version). Nothing is modified.
+
+If the special target "versions" is given, followed by a [module], lists the
+latest version of every available major version of the module, from its
+current major version upwards (including those with only pre-releases), along
+with the date each was released, and whether it is retracted or deprecated.
+Nothing is modified.
+
+If the special target "compare" is given, followed by a [module] (and
+optionally a [version]), prints a unified diff of the go.mod file against what
+it would be after upgrading the module as described above, including any change
+to the go directive. Unlike [-dry-run], only the go.mod file is considered:
+import paths are not rewritten. Nothing is modified.
+
+If the special target "migrate" is given, followed by a [module] (and
+optionally a [version]), upgrades the module as described above, and then runs
+'go mod tidy', 'go build ./...' and 'go test ./...' in the module directory,
+reporting whether each step succeeded. If a step fails, the remaining steps are
+skipped, but the upgrade itself is kept, unless the [-stop-on-error] flag is
+given, in which case it is rolled back.
+
+If an upgraded dependency requires a higher go version (in its own go.mod file)
+than the one declared by the module, a warning is printed. The [-go-update] flag
+raises the go directive in the go.mod file to the required version instead.
+Since the go directive affects the semantics of the module (e.g. the scoping of
+loop variables, as of go 1.22), this is only done when asked for, and a warning
+is still printed. If the go.mod file has a toolchain directive that names an
+older toolchain than the new go version (e.g. "toolchain go1.21.3", after
+raising the go directive to 1.22), it is removed, as the go command would do.
+
+The [-goversion version] flag sets the go directive in the go.mod file to the
+given version (e.g. -goversion=1.22), along with the upgrade, whether it is
+higher or lower than the current one. It can be used when upgrading the module
+itself, a dependency, or all dependencies. A warning is printed if any
+dependency requires a higher go version than the given one.
+
+Only the files included in the build by default are rewritten. Files that are
+only built with certain build tags (e.g. "//go:build integration") can be
+included by passing the tags with the [-tags] flag (e.g. -tags=integration). The
+"ignore" tag is never needed: files with "//go:build ignore" are intentionally
+excluded from every build, and never need to be rewritten.
+
+Module paths in //go:generate directives (e.g. "//go:generate go run
+github.com/foo/bar/cmd/gen") are rewritten just like import paths, unless the
+[-skip-go-generate] flag is given (e.g. if the directives contain strings that
+look like module paths, but aren't).
+
+The [-no-rewrite] flag only updates the go.mod file, leaving all .go files
+untouched (e.g. to rewrite the import paths with another tool, or as a first
+step of a larger refactoring). The module won't build until the import paths
+are rewritten, so a warning is printed for each module whose path changed. It
+can't be combined with the "migrate" target or the [-tidy] flag.
+
+Rewritten files are formatted like gofmt does, which sorts the imports within
+each group, but leaves the groups as they are. The [-goimports] flag also groups
+the imports of rewritten files like 'goimports -local' does: standard library
+packages first, then third-party packages, then the module's own packages. It is
+off by default, since it can change more lines than the rewritten imports.
+
+Some major versions reorganize a module: e.g. github.com/foo/bar/v2 may split the
+package github.com/foo/bar/extra out into the module github.com/foo/extra. The
+[-remap old=new] flag rewrites such paths along with the upgrade (e.g.
+-remap=github.com/foo/bar/extra=github.com/foo/extra), and requires the latest
+version of the new module (or the version given with old=new@version). The most
+specific path applies, so the remapped package isn't rewritten to
+github.com/foo/bar/v2/extra. The flag can be repeated.
+
+Named imports keep their names when rewritten, unless the name is just the one
+the old path is imported as by default (its last element, ignoring any major
+version suffix), and the new path's differs (e.g. extra "github.com/foo/bar/extra",
+remapped to github.com/foo/other). The import is then renamed to match the new
+path, along with its uses in the file, unless the new name is already in use.
+
+The [-module-map file] flag reads such mappings from a file instead, which is
+useful when many modules are renamed at once: the imports of all of them are
+rewritten in a single pass. Each line holds an old path and a new module path
+(optionally with a version), separated by whitespace, as in 'go mod edit
+-replace' (e.g. "example.com/old example.com/new@v1.2.3"). Blank lines, and
+comments starting with "#", are ignored. To apply the mappings without
+upgrading anything else, give the special target "remap".
+
+The [-replace old=new] flag adds a replace directive to go.mod along with the
+upgrade, as 'go mod edit -replace' does, e.g. to build and test against a local
+copy of the upgraded dependency before it's published (e.g.
+-replace=github.com/foo/bar/v2=../bar). The old path can have a version, to only
+replace that version. The new path is either a local directory, or a module
+path with a version (e.g. -replace=github.com/foo/bar/v2=github.com/me/bar/v2@v2.0.1).
+The require line is upgraded as usual. The flag can be repeated.
+
+Before modifying any files, the tool saves a snapshot of their original
+contents (along with a manifest listing them) in the .upgrade-snapshot directory
+in the module directory. The snapshot is removed once the upgrade completes
+successfully. If the upgrade fails part way through (e.g. after rewriting some
+files, but not others), the special target "rollback" restores the files from
+the snapshot, undoing the upgrade. The tool refuses to upgrade a module with a
+leftover snapshot, until it has been rolled back or removed.
 
 If given, [module] must be a fully qualified module path, as written in the
 go.mod file. It must include the major version component, if applicable. For
-example: "github.com/nathanjcochran/upgrade/v2".
+example: "github.com/nathanjcochran/upgrade/v2". Modules that follow the
+gopkg.in convention of a ".vN" suffix (e.g. "gopkg.in/yaml.v2") are upgraded to
+the path with the corresponding suffix (e.g. "gopkg.in/yaml.v3").
+
+Dependencies required at a "+incompatible" version (i.e. a major version above
+v1 published before the module adopted go.mod files) are upgraded to the
+module-aware major versions above it. A higher "+incompatible" version, which
+keeps the same module path, is reported in a note, offered by
+[-interactive-pick-version], and can be upgraded to by giving its version
+(e.g. "upgrade github.com/docker/docker v27").
 
 If [version] is given, it must be a valid semver module version. It can be
 provided with any level of major/minor/patch specificity - e.g. 'v2', 'v2.3',
@@ -49,8 +240,18 @@ provided with any level of major/minor/patch specificity - e.g. 'v2', 'v2.3',
 provided version is taken into account (the minor/patch versions are ignored).
 When upgrading a dependency, the tool will attempt to upgrade to the highest
 available matching version, unless the target major version of the dependency
-is already required, in which case it will maintain the existing minor/patch
-version.
+is already required at a matching version, in which case it will maintain the
+existing minor/patch version (e.g. v2.3.1 matches v2 and v2.3, but not
+v2.5.1). Otherwise, the requirement is updated to the given version, so a
+dependency can also be upgraded to a specific minor or patch version within the
+major version it's already at (e.g. "upgrade github.com/foo/bar/v2 v2.5.1").
+
+When a dependency is upgraded, any replace directives that refer to it are
+updated to refer to the new module path and version. Replacements with local
+directories are left untouched (with a warning).
+Exclude directives that refer to it are re-added under the new module path and
+version (the original ones are kept, since they still apply if the old version
+remains in the build).
 
 NOTE: This tool does not add version tags in any version control systems. Its
 only external dependency is the "go list" command.
@@ -60,474 +261,1264 @@ directory. The [-d dir] flag can be provided to override that behavior.
 
 The [-v] flag turns on verbose output.
 
+The [-vendor] flag also rewrites import paths in the .go files in the module's
+vendor directory (if there is one), and updates the module paths and versions
+listed in vendor/modules.txt. Note that the vendored source code of the
+upgraded modules themselves is not replaced: run 'go mod vendor' to do so.
+
+The [-download] flag runs 'go mod download' in the module directory after the
+upgrade has been applied, to populate the module cache with the upgraded
+modules (e.g. before moving to a restricted environment). Any modules that
+fail to download are reported. It runs before any other post-upgrade steps.
+
+The [-verify] flag runs 'go mod verify' in the module directory after the
+upgrade has been applied (and after [-download], if given), to check that the
+dependencies in the module cache, including the upgraded ones, haven't been
+modified since they were downloaded. If verification fails, its output is
+printed, and the tool exits with a non-zero status: the module cache can then be
+cleaned with 'go clean -modcache'.
+
+The [-tidy] flag runs 'go mod tidy' in the module directory after the upgrade
+has been applied, so that the go.mod and go.sum files are left in a consistent
+state. If it fails, the tool exits with a non-zero status.
+
+The [-build] flag runs 'go build ./...' in the module directory after the
+upgrade has been applied (and after [-tidy], if given), to check that the
+module still compiles (e.g. that the new major version of a dependency didn't
+remove an API it uses). If the build fails, its errors are printed, and the
+tool exits with a non-zero status. The [-build-fix] flag does the same, but
+also rolls back the upgrade, so that the module is never left broken. Neither
+flag has any effect with the "migrate" target, which builds the module anyway.
+
+The [-commit] flag creates a git commit containing the changes made by the
+upgrade, once it has been applied successfully. Only the files the upgrade
+modified (go.mod, go.sum, and the rewritten files) are staged, so other
+uncommitted changes are left as they are. The commit message can be set with
+the [-commit-msg message] flag, in which "{upgrades}" is replaced with a
+summary of the upgrades (the default is "upgrade: {upgrades}"). The commit is
+skipped if there is nothing to commit.
+
+The tool exits with status 0 if at least one module was upgraded, 2 if there was
+nothing to upgrade (e.g. the dependency is already at its highest major
+version), and 1 on error, so that scripts can tell whether anything changed.
+The [-n] and [-check] flags use their own exit statuses, described below.
+
+If the tool is interrupted (by SIGINT, e.g. Ctrl+C, or SIGTERM), any go
+commands in progress are cancelled, and it stops once it's safe to: the go.mod
+file and rewritten imports are never left partially written. It prints how many
+of the upgrades it planned were applied (e.g. "interrupted; 2/5 upgrades
+applied") to stderr, and exits with status 130. A second signal stops it
+immediately.
+
+The [-n] (or [-dry-run]) flag prints the changes the upgrade would make (each
+rewritten import path, and each line added to or removed from the go.mod file),
+without writing any files. The tool exits with status 0 if there are changes to
+make, 3 if there is nothing to change (e.g. the module is already at the target
+version), and 1 on error.
+
+The [-diff] flag prints the changes to the go.mod file and to each rewritten file
+in unified diff format (as printed by 'git diff'), rather than listing the
+rewritten import paths. The output can be applied with 'patch -p1'. Combined with
+the [-n] flag, the diff is printed without writing any files.
+
+The [-check] flag checks whether any dependency can be upgraded to a higher
+major version, without modifying anything (it implies the "all" target). Each
+available upgrade is printed, and the tool exits with status 2 if there are any,
+0 if all dependencies are at their latest major version, and 1 on error. It can
+be combined with the [-json] flag for machine-readable output: a JSON array of
+the available upgrades, each with its current_path, current_version,
+upgrade_path and upgrade_version, and whether the upgrade version is deprecated
+or retracted (one array per line for each module of a workspace). Unlike the
+[-n] flag, which shows what the tool would change, it only asserts that nothing
+is out of date (e.g. in a CI pipeline).
+
+The [-silent] (or [-q]) flag suppresses all output except errors (which are
+printed to stderr), so that the tool prints nothing on success. It can't be
+combined with the [-v] or [-i] flags.
+
+The [-json] flag suppresses all human-readable output, and instead prints a JSON
+object describing the changes: the module path, and for each upgrade, the old
+and new module paths and versions, along with each file whose imports were
+rewritten (and the old and new import paths). When upgrading all dependencies
+of a workspace, one JSON object is printed per line for each module. With the
+"security" target, one JSON object is printed per line for each vulnerability
+found. Prompts (e.g. with the [-i] flag) are written to stderr, so
+they aren't mixed into the JSON output.
+
+The [-summary] flag prints a summary table after upgrading all dependencies:
+the number of dependencies checked, upgraded, already at their latest major
+version, skipped (e.g. pinned), excluded (with [-ignore-module]), and skipped
+due to errors, along with the total time taken. In JSON mode, the summary is
+included in the JSON object instead.
+
+The [-summary-only] flag suppresses all per-file and per-import output, and
+only prints the module upgrades themselves, followed by a final summary of the
+number of modules upgraded and files rewritten. It overrides the [-v] flag.
+
+The [-print-plan] flag prints the full upgrade plan (the modules to be upgraded,
+and the files whose imports would be rewritten for each one) and exits, without
+modifying anything.
+
+The [-check-retracted] flag causes retraction information to be queried for
+every module version discovered (by passing the -retracted flag to 'go list').
+Retracted versions are reported in verbose output.
+
+Regardless, if the version being upgraded to has been retracted (which only
+happens if no unretracted version is available), a warning is printed,
+including the reason for the retraction. The [-strict] flag causes the tool to
+refuse to upgrade to a retracted version instead.
+
+When upgrading all dependencies, the [-strict] flag also causes the tool to fail
+(without upgrading anything) if any dependency has no higher major version
+available, listing every such dependency. This can be used in CI to enforce
+that all dependencies are upgraded together. Dependencies that are ignored
+(with [-ignore-module]), filtered out, or pinned are exempt.
+
+If a module being upgraded (or being upgraded to) has been deprecated by its
+author, a warning is printed, including the deprecation message.
+
+The [-interactive-pick-version] flag presents a numbered menu of the available
+major versions of each dependency being upgraded (rather than automatically
+choosing the highest one), and asks which one to upgrade to.
+
+The [-staged] flag upgrades a single dependency one major version at a time
+(e.g. v1 to v2, then v2 to v3), rather than straight to the highest major
+version (or the given version). Each stage upgrades to the highest version of
+its major version, and is applied in full (including the [-build], [-tidy] and
+other post-upgrade steps) before the next one, so that breaking changes can be
+dealt with (and found) one major version at a time. Major versions with only
+pre-release versions available are skipped. When migrating, only the last stage
+is migrated. The flag can't be used in modes that don't apply the upgrade.
+
+By default, major versions with only pre-release versions available (e.g.
+v3.0.0-rc.1) are skipped. The [-pre] flag causes them to be considered as well,
+although stable versions are still preferred: a pre-release version is only
+upgraded to if no stable upgrade version is available.
+
+Dependencies occasionally skip a major version (e.g. v2 and v4 exist, but v3
+does not), so by default, the search for higher major versions of a dependency
+tolerates one missing major version, and stops at the second consecutive one.
+The [-max-gap number] flag sets the number of consecutive missing major versions
+tolerated (with 0, the search stops at the first missing major version).
+
+When upgrading all dependencies, the available versions of each dependency are
+looked up concurrently. The [-concurrency number] flag limits how many are
+looked up at once (the default is the number of CPUs).
+
+Looking up the versions of many dependencies can take a while, even with a fast
+module proxy. The [-cache-dir directory] flag caches the versions found for each
+dependency (at its current version) in the given directory, and reuses them in
+later runs, until they're older than the [-cache-ttl duration] flag (the
+default is 24h). The [-no-cache] flag ignores the cached versions, looking
+them all up afresh (and replacing the cached ones). Higher major versions
+published since the versions were cached aren't found until they expire.
+
+Higher major versions of a dependency are queried in batches, one 'go list'
+call per batch. The [-batch number] flag sets the number of major versions per
+batch (between 1 and 100, the default is 1). Larger batches mean fewer 'go list'
+calls for modules with many major versions, which can be faster with a fast
+module proxy, but also mean querying major versions that don't exist. Since
+dependencies are looked up concurrently, up to [-concurrency] times [-batch]
+module versions can be queried at once.
+
+The [-binary-search] flag finds the highest major version of each dependency by
+querying exponentially increasing major versions (e.g. v3, v4, v6, v10, v18, in a
+single call) to find an upper bound, then binary searching below it. This makes
+far fewer 'go list' calls for modules with many major versions, but can miss the
+highest major version if some major versions were skipped. The tool falls back
+to querying each major version in turn if a query fails for any other reason
+than the version not existing. It has no effect with the
+[-interactive-pick-version] or [-staged] flags, which need every major version.
+
+The [-i] flag turns on interactive mode. Before anything is modified, the full
+upgrade plan (the modules to be upgraded, and the number of files whose imports
+will be rewritten) is displayed, and the changes are only applied if confirmed.
+When upgrading all dependencies, each upgrade is confirmed individually instead:
+answering "q" skips the remaining upgrades, but applies those already accepted.
+Interactive mode can only be used from a terminal.
+
+The [-proxy value] flag sets the GOPROXY used when running the go command
+(e.g. to query module versions from an internal module mirror), overriding the
+environment. It accepts the full GOPROXY syntax, including "direct", "off" and
+comma-separated lists of proxies. If it is exactly "direct", the checksum
+database is not consulted either (GONOSUMDB=*), since modules fetched directly
+are often private.
+
+The [-private list] flag sets the GOPRIVATE and GONOSUMDB environment variables
+used when running the go command to the given comma-separated list of module
+path prefixes (e.g. "github.com/mycorp/*,git.mycorp.com"), overriding the
+environment. Private modules are then fetched directly from their origin, and
+not looked up in the checksum database, as with a regular 'go build'.
+
+The [-audit-log file] flag records the provenance ("Origin") information
+reported by the go command for every module version queried, and writes it to
+the given file as JSON. This can be used to verify that module versions were
+sourced from approved proxies. The file is written however the tool exits, so
+it includes the queries made before any error.
+
+The [-report file] flag writes a record of each upgrade applied to the given file
+as JSON: the time, the module upgraded, the old and new module paths and
+versions, the files modified, and any warnings about the new version (e.g. if it
+is retracted or deprecated). Records are appended to the file if it already
+exists. The file is rewritten (atomically) after each module's upgrade, so that
+it is valid, and complete up to that point, even if the tool is interrupted.
+
+The [-timeout duration] flag limits how long the tool can spend running the go
+command (e.g. 'go list', to query module versions from the module proxy) in
+total. This can be useful when a slow proxy or network would otherwise cause
+the tool to hang. It does not apply to loading packages (see below), or to the
+build and tests run by the "migrate" target.
+
+The [-load-timeout duration] flag limits how long loading the module's packages
+(in order to rewrite their imports) can take. Loading can be slow for large
+modules, particularly the first time.
+
+If the module contains packages that use cgo, but cgo is disabled in the
+environment (e.g. CGO_ENABLED=0), those packages can't be loaded. The
+[-cgo-enabled] flag forces cgo to be enabled when loading packages.
+
+The [-max-file-size bytes] flag skips rewriting imports in any .go file larger
+than the given size, printing a warning instead. Such files are only parsed as
+far as their imports. This can be useful in modules containing very large
+generated files.
+
+By default, the upgrade is aborted (without modifying anything) if the imports
+of any file can't be rewritten (e.g. because the rewritten import path would be
+invalid). The [-continue-on-error] flag skips such files instead, so that the
+rest are still rewritten and the upgrade applied. The tool then exits with a
+non-zero status, listing every file that was skipped and why, so that they can
+be fixed manually.
+
+Default values for any of the options below can be set in a .upgrade.yaml file
+in the module directory, or in $HOME/.config/upgrade/config.yaml (the former
+takes precedence). Keys are option names, without the leading dash (e.g.
+"proxy" or "max-gap"). The single-letter options can also be given their long
+names: "dir" (-d), "verbose" (-v), "interactive" (-i) and "dry-run" (-n). List
+values are joined with commas. Options given on the command line always take
+precedence over config files. For example:
+
+	tidy: true
+	verbose: true
+	timeout: 2m
+	ignore-module:
+	  - github.com/some/dependency
+	  - github.com/other/dependency
+
+The module's config file can also be written in TOML, as .upgrade.toml (which
+takes precedence over .upgrade.yaml, if both exist).
+
 Options:
 `
 
 var (
-	dir     = flag.String("d", ".", "Module directory path")
-	verbose = flag.Bool("v", false, "verbose output")
+	dir             = flag.String("d", ".", "Module directory path")
+	verbose         = flag.Bool("v", false, "verbose output")
+	silent          = flag.Bool("silent", false, "Suppress all output except errors")
+	auditLog        = flag.String("audit-log", "", "Write module provenance information to the given JSON `file`")
+	reportFile      = flag.String("report", "", "Write a record of each upgrade applied (including the files modified, and any warnings) to the given JSON `file`")
+	ignoreModules   stringList
+	filterModules   stringList
+	remaps          stringList
+	replaces        stringList
+	checkRetracted  = flag.Bool("check-retracted", false, "Query retraction information when discovering module versions")
+	interactive     = flag.Bool("i", false, "Show the upgrade plan and ask for confirmation before applying it")
+	printPlan       = flag.Bool("print-plan", false, "Print the upgrade plan, including the files affected by each upgrade, without applying it")
+	pickVersion     = flag.Bool("interactive-pick-version", false, "Choose from the available major versions of each dependency, rather than upgrading to the highest one")
+	maxGap          = flag.Int("max-gap", 1, "Tolerate this `number` of consecutive missing major versions when searching for higher ones")
+	cgoEnabled      = flag.Bool("cgo-enabled", false, "Force cgo to be enabled (CGO_ENABLED=1) when loading the module's packages")
+	loadTimeout     = flag.Duration("load-timeout", 5*time.Minute, "Maximum `duration` to spend loading the module's packages (0 means no limit)")
+	commit          = flag.Bool("commit", false, "Create a git commit after a successful upgrade")
+	commitMsg       = flag.String("commit-msg", "upgrade: {upgrades}", "Commit `message` used with -commit ({upgrades} is replaced with a summary of the upgrades)")
+	downgrade       = flag.Bool("downgrade", false, "Allow the module's own major version to be moved to a lower version")
+	private         = flag.String("private", "", "Comma-separated `list` of module path prefixes of private modules (sets GOPRIVATE and GONOSUMDB, overriding the environment)")
+	proxy           = flag.String("proxy", "", "GOPROXY `value` to use when querying module versions (overrides the environment)")
+	strict          = flag.Bool("strict", false, "Refuse to upgrade to retracted versions, and fail if any dependency has no higher major version when upgrading all dependencies")
+	pre             = flag.Bool("pre", false, "Consider pre-release versions (e.g. v3.0.0-rc.1) of major versions that have no stable release")
+	timeout         = flag.Duration("timeout", 0, "Maximum `duration` to spend on 'go list' (and other go command) invocations, in total (0 means no limit)")
+	recurse         = flag.Bool("recurse", false, "Perform the upgrade in every module found within the module directory (recursively)")
+	noWork          = flag.Bool("no-work", false, "Disable workspace mode (set GOWORK=off) for every go command run, to upgrade only the module itself when it's part of a workspace")
+	work            = flag.Bool("work", false, "Perform the upgrade in every module of the workspace rooted in the module directory")
+	workSync        = flag.Bool("work-sync", false, "When upgrading all dependencies of a workspace, run 'go work sync' afterwards")
+	stopOnError     = flag.Bool("stop-on-error", false, "When migrating, roll back the upgrade if any subsequent step fails")
+	maxFileSize     = flag.Int64("max-file-size", 0, "Skip rewriting imports in .go files larger than the given number of `bytes` (0 means no limit)")
+	continueOnError = flag.Bool("continue-on-error", false, "Skip files whose imports can't be rewritten, rather than aborting the upgrade (the tool still fails, listing them, once the rest are rewritten)")
+	batchSize       = flag.Int("batch", 1, "The `number` of major versions of a dependency to query per 'go list' call (between 1 and 100)")
+	staged          = flag.Bool("staged", false, "Upgrade the dependency one major version at a time (e.g. v1 to v2, then v2 to v3), rather than straight to the target version")
+	binarySearch    = flag.Bool("binary-search", false, "Find the highest major version of each dependency with a binary search, rather than querying each major version in turn")
+	concurrency     = flag.Int("concurrency", 0, "Maximum `number` of dependencies to look up versions for concurrently (0 means the number of CPUs)")
+	check           = flag.Bool("check", false, "Check whether any dependency can be upgraded to a higher major version, without modifying anything (exits with status 2 if so)")
+	dryRun          = flag.Bool("n", false, "Dry run: print the changes that would be made, without writing any files")
+	showDiff        = flag.Bool("diff", false, "Print a unified diff of the changes to go.mod and each rewritten file (with -n, without writing them)")
+	indirect        = flag.Bool("indirect", false, "Also upgrade indirect dependencies when upgrading all dependencies (can have wider cascading effects than upgrading direct ones)")
+	cacheDir        = flag.String("cache-dir", "", "Cache the upgrade versions found for each dependency when upgrading all dependencies in the given `directory`, to reuse in later runs")
+	cacheTTL        = flag.Duration("cache-ttl", 24*time.Hour, "Maximum age (as a `duration`) of the cached upgrade versions used with -cache-dir")
+	noCache         = flag.Bool("no-cache", false, "Ignore the upgrade versions cached with -cache-dir (replacing them with the versions found)")
+	since           = flag.String("since", "", "When upgrading all dependencies, skip those whose current version was published after the given `date` (e.g. 2024-01-01), i.e. that were upgraded recently")
+	requireMissing  = flag.Bool("require", false, "Add a requirement on the given dependency to go.mod (at the version in the module graph, or its latest version) if it isn't required already, then upgrade it")
+	transitive      = flag.Bool("transitive", false, "When upgrading all dependencies, also report the modules in the full dependency graph (not required in go.mod) that have a higher major version")
+	goVersion       = flag.String("goversion", "", "Set the go directive in go.mod to the given `version` (e.g. 1.22), along with the upgrade")
+	goUpdate        = flag.Bool("go-update", false, "Raise the go directive in go.mod if an upgraded dependency requires a higher go version")
+	noRewrite       = flag.Bool("no-rewrite", false, "Only update go.mod, without rewriting import paths in .go files (the module won't build until they are rewritten)")
+	tags            = flag.String("tags", "", "Comma-separated `list` of build tags to load packages with, so that the imports of files that require them are rewritten too")
+	goimports       = flag.Bool("goimports", false, "Sort and group the imports of rewritten files like 'goimports -local' does, with the module's own packages last")
+	skipGoGenerate  = flag.Bool("skip-go-generate", false, "Don't rewrite module paths in //go:generate directives")
+	vendor          = flag.Bool("vendor", false, "Also rewrite import paths in the vendor directory, and update vendor/modules.txt")
+	runDownload     = flag.Bool("download", false, "Run 'go mod download' after a successful upgrade, to populate the module cache")
+	runVerify       = flag.Bool("verify", false, "Run 'go mod verify' after a successful upgrade, to check the module cache isn't corrupted")
+	runTidy         = flag.Bool("tidy", false, "Run 'go mod tidy' after a successful upgrade")
+	runBuild        = flag.Bool("build", false, "Run 'go build ./...' after a successful upgrade, and fail if the module doesn't compile")
+	buildFix        = flag.Bool("build-fix", false, "Like -build, but roll back the upgrade if the module doesn't compile")
+	jsonOutput      = flag.Bool("json", false, "Print a JSON report of the changes, rather than human-readable output")
+	showSummary     = flag.Bool("summary", false, "Print a summary table after upgrading all dependencies")
+	summaryOnly     = flag.Bool("summary-only", false, "Only print the module upgrades and a final summary (overrides -v)")
+	moduleMap       = flag.String("module-map", "", "Read extra module path mappings to rewrite (as with -remap) from the given `file`, one 'old new[@version]' pair per line")
+	manifestFile    = flag.String("manifest", "", "Read the upgrades to make (each a module, with an optional version and module directory) from the given JSON or YAML `file`, and make them in order")
+	allowSumUpdates = flag.Bool("allow-sum-updates", false, "Retry querying module versions with -mod=mod if go.sum is missing checksums")
+	upgradeYAML     = flag.Bool("upgrade-yaml", false, "With the pin target, add the pinned dependencies to the ignore-module list in the module's .upgrade.yaml file")
+	inlineComments  = flag.Bool("inline-comments", false, "With the pin target, mark each pinned require line with a comment (the default, unless -upgrade-yaml is given)")
 )
 
+func init() {
+	flag.BoolVar(dryRun, "dry-run", false, "Same as -n")
+	flag.BoolVar(silent, "q", false, "Same as -silent")
+	flag.Var(&ignoreModules, "ignore-module", "Comma-separated list of module path `patterns` (e.g. golang.org/x/*) to skip when upgrading all dependencies (can be repeated)")
+	flag.Var(&ignoreModules, "exclude", "Same as -ignore-module (the preferred name), for module path `patterns`")
+	flag.Var(&filterModules, "filter", "Comma-separated list of module path `patterns` (e.g. github.com/myorg/...) to limit upgrading all dependencies to (can be repeated)")
+	flag.Var(&replaces, "replace", "Comma-separated list of old[@version]=new[@version] module `replacements` to add to go.mod along with the upgrade, as with 'go mod edit -replace', e.g. to build against a local copy of the upgraded dependency (can be repeated)")
+	flag.Var(&remaps, "remap", "Comma-separated list of extra old=new[@version] module `paths` to rewrite along with the upgrade, e.g. for a package split out into its own module (can be repeated)")
+}
+
 func main() {
 	flag.Usage = func() {
 		if _, err := fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0]); err != nil {
-			log.Fatalf("Error outputting usage message: %s", err)
+			fatalf("Error outputting usage message: %s", err)
 		}
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	file := readModFile(*dir)
+	// Options from config files only apply if not given on the command line
+	if err := loadConfig(*dir); err != nil {
+		fatalf("Error loading config: %s", err)
+	}
+
+	if *goVersion != "" && !validGoVersion(*goVersion) {
+		fatalf("Invalid -goversion value: %s (must be a go version, e.g. 1.22)", *goVersion)
+	}
+	if *since != "" {
+		date, err := parseSince(*since)
+		if err != nil {
+			fatalf("Invalid -since value: %s", err)
+		}
+		sinceDate = date
+	}
+	if *cacheTTL < 0 {
+		fatalf("Invalid -cache-ttl value: %s (must not be negative)", *cacheTTL)
+	}
+	if *maxGap < 0 {
+		fatalf("Invalid -max-gap value: %d (must not be negative)", *maxGap)
+	}
+	if *batchSize < 1 || *batchSize > 100 {
+		fatalf("Invalid -batch value: %d (must be between 1 and 100)", *batchSize)
+	}
+	if err := ignoreModules.validatePatterns(); err != nil {
+		fatalf("Invalid -ignore-module value: %s", err)
+	}
+	if err := filterModules.validatePatterns(); err != nil {
+		fatalf("Invalid -filter value: %s", err)
+	}
+	if *moduleMap != "" {
+		entries, err := readModuleMap(*moduleMap)
+		if err != nil {
+			fatalf("Error reading -module-map file: %s", err)
+		}
+		remaps = append(remaps, entries...)
+	}
+	for _, remap := range remaps {
+		if _, _, _, err := parseRemap(remap); err != nil {
+			fatalf("Invalid -remap value: %s", err)
+		}
+	}
+	for _, replace := range replaces {
+		if _, err := parseReplace(replace); err != nil {
+			fatalf("Invalid -replace value: %s", err)
+		}
+	}
+	if *concurrency < 0 {
+		fatalf("Invalid -concurrency value: %d (must not be negative)", *concurrency)
+	}
+	if *concurrency == 0 {
+		*concurrency = runtime.NumCPU()
+	}
+	if *jsonOutput {
+		stdout = ioutil.Discard
+	}
+
+	// Silent mode contradicts any flag that asks for more output (or for
+	// interaction), so don't let one silently win over the other
+	if *silent {
+		if *verbose {
+			fatalf("The -silent flag can't be used with the -v flag")
+		}
+		if *interactive {
+			fatalf("The -silent flag can't be used with the -i flag")
+		}
+		stdout = ioutil.Discard
+	}
+	if *summaryOnly && *verbose {
+		fmt.Fprintln(stdout, "Warning: -summary-only overrides -v, verbose output disabled")
+		*verbose = false
+	}
 
 	path := flag.Arg(0)
 	version := flag.Arg(1)
 
-	switch path {
-	case "", file.Module.Mod.Path:
-		upgradeModule(file, version)
-	case "all":
-		upgradeAllDependencies(file)
-	default:
-		upgradeDependency(file, path, version)
+	migrating := path == "migrate"
+	if migrating {
+		path = flag.Arg(1)
+		version = flag.Arg(2)
+		if path == "" {
+			fatalf("A module path must be given with the migrate target")
+		}
 	}
 
-	writeModFile(*dir, file)
+	// The upgrades listed in a manifest are made instead of a target given
+	// on the command line
+	var upgradeList *manifest
+	if *manifestFile != "" {
+		if path != "" {
+			fatalf("The -manifest flag can't be used with a target: %s", flag.Arg(0))
+		}
+		if *recurse || *work || *staged || *check {
+			fatalf("The -manifest flag can't be used with the -recurse, -work, -staged or -check flags")
+		}
+		m, err := readManifest(*manifestFile, *dir)
+		if err != nil {
+			fatalf("Error reading -manifest file: %s", err)
+		}
+		// A dry run requested by the manifest can't be overridden
+		if m.DryRun && !*dryRun {
+			fmt.Fprintln(stdout, "Dry run requested by manifest file, no files will be written")
+			*dryRun = true
+		}
+		upgradeList = m
+	}
 
-	// Run 'go list' after writing the updated go.mod file, in case there are
-	// transitive dependencies that need to be updated in the go.mod file
-	// (otherwise, the user's go.mod file would change again the next time they
-	// ran go install, go get, go list, etc.)
-	if err := list(context.Background()); err != nil {
-		log.Fatalf("Error finalizing transitive dependency versions: %s", err)
+	if path == "remap" && len(remaps) == 0 {
+		fatalf("The remap target requires module path mappings, given with -remap or -module-map")
 	}
-}
 
-func readModFile(dir string) *modfile.File {
-	// Read and parse the go.mod file
-	filePath := path.Join(dir, "go.mod")
-	b, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		log.Fatalf("Error reading module file %s: %s", filePath, err)
+	// Checking for upgrades is always done for all dependencies
+	if *check {
+		if path == "" {
+			path = "all"
+		}
+		if path != "all" || migrating {
+			fatalf("The -check flag checks all dependencies, and can't be given another target: %s", flag.Arg(0))
+		}
+		if *interactive {
+			fatalf("The -check flag can't be used with the -i flag")
+		}
 	}
 
-	file, err := modfile.Parse(filePath, b, nil)
-	if err != nil {
-		log.Fatalf("Error parsing module file %s: %s", filePath, err)
+	if *requireMissing && upgradeList == nil && (path == "" || path == "all" || path == "remap" || !upgradeTarget(path)) {
+		fatalf("The -require flag requires a dependency to upgrade")
+	}
+	if *since != "" && path != "all" {
+		fatalf("The -since flag can only be used when upgrading all dependencies")
+	}
+	if *transitive && path != "all" {
+		fatalf("The -transitive flag can only be used when upgrading all dependencies")
 	}
 
-	return file
-}
+	// Restoring the files modified by a failed upgrade doesn't involve
+	// upgrading anything
+	if path == "rollback" {
+		if err := rollback(*dir); err != nil {
+			fatalf("Error rolling back upgrade: %s", err)
+		}
+		return
+	}
 
-func writeModFile(dir string, f *modfile.File) {
-	// Format and re-write the module file
-	f.SortBlocks()
-	f.Cleanup()
-	out, err := f.Format()
-	if err != nil {
-		log.Fatalf("Error formatting module file: %s", err)
+	// The build and tests run when migrating would fail with the old import
+	// paths, and tidying would add the old module paths back
+	if *noRewrite && migrating {
+		fatalf("The -no-rewrite flag can't be used with the migrate target")
+	}
+	if *noRewrite && *runTidy {
+		fatalf("The -no-rewrite flag can't be used with the -tidy flag")
 	}
 
-	filePath := path.Join(dir, "go.mod")
-	if err := ioutil.WriteFile(filePath, out, 0644); err != nil {
-		log.Fatalf("Error writing module file %s: %s", filePath, err)
+	// Upgrading in stages applies each stage in turn, so only makes sense
+	// for a single dependency, and for modes that apply the upgrade
+	if *staged {
+		if path == "" || path == "all" || path == "remap" || !upgradeTarget(path) {
+			fatalf("The -staged flag requires a dependency to upgrade")
+		}
+		if *dryRun || *printPlan || *check || *pickVersion || *work {
+			fatalf("The -staged flag can't be used with the -n, -print-plan, -check, -interactive-pick-version or -work flags")
+		}
+		// The extra mappings would be applied again by each stage
+		if len(remaps) > 0 || *moduleMap != "" {
+			fatalf("The -staged flag can't be used with the -remap or -module-map flags")
+		}
 	}
-}
 
-func upgradeModule(file *modfile.File, version string) {
-	path := file.Module.Mod.Path
+	if *interactive && !(isTerminal(os.Stdin) && isTerminal(os.Stdout)) {
+		fatalf("The -i flag can only be used from a terminal")
+	}
+	if *commit {
+		if _, err := exec.LookPath("git"); err != nil {
+			fatalf("The -commit flag requires git to be installed: %s", err)
+		}
+	}
+
+	ctx, stop := notifyInterrupt()
+	defer stop()
+
+	// The timeout applies to every invocation of the go command made while
+	// querying module versions, even when they're made concurrently
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
 
-	if version != "" {
-		if !semver.IsValid(version) {
-			log.Fatalf("Invalid upgrade version: %s", version)
+	// Listing a module's versions doesn't involve upgrading anything
+	if path == "versions" {
+		if version == "" {
+			fatalf("A module path must be given with the versions target")
 		}
+		printVersions(ctx, *dir, version)
+		exit(0) // Writes the audit log
+	}
 
-		// Truncate the minor/patch versions
-		version = semver.Major(version)
+	// Comparing go.mod files doesn't write anything either
+	if path == "compare" {
+		if version == "" {
+			fatalf("A module path must be given with the compare target")
+		}
+		compareDependency(ctx, *dir, version, flag.Arg(2))
+		exit(0) // Writes the audit log
 	}
 
-	// Figure out what the post-upgrade module path should be
-	// (if version is empty, simply increment the version number)
-	newPath, err := upgradePath(path, version)
-	if err != nil {
-		log.Fatalf("Error upgrading module path %s to %s: %s",
-			path, version, err,
-		)
+	// Every go command the tool runs (including those run by the tool itself,
+	// with -recurse) inherits the environment
+	if *noWork {
+		if *work {
+			fatalf("The -no-work flag can't be used with the -work flag")
+		}
+		if err := os.Setenv("GOWORK", "off"); err != nil {
+			fatalf("Error disabling workspace mode: %s", err)
+		}
+	}
+
+	if *work {
+		if !isWorkspaceRoot(*dir) {
+			fatalf("The -work flag requires a go.work file in the module directory: %s", *dir)
+		}
+		if path == "" {
+			fatalf("The -work flag can't be used to upgrade the modules in a workspace themselves: give a dependency or special target")
+		}
 	}
 
-	fmt.Printf("%s -> %s\n", path, newPath)
+	// When upgrading all dependencies from the root of a workspace (or if
+	// explicitly asked to), upgrade every module in the workspace
+	workspaceRoot := isWorkspaceRoot(*dir) && !*noWork
+	if workFile := findWorkFile(*dir); workFile != "" && !*work && !(path == "all" && workspaceRoot) && !*recurse {
+		fmt.Fprintf(stdout, "Warning: workspace mode is active (%s), so the go command may consider other modules in the workspace (use -no-work to only upgrade this module)\n", workFile)
+	}
+	if upgradeList != nil {
+		upgradeManifest(ctx, upgradeList)
+	} else if *recurse {
+		upgradeRecursive(ctx, *dir)
+	} else if *work || (path == "all" && workspaceRoot) {
+		upgradeWorkspace(ctx, *dir, path, version, migrating)
+	} else if *staged {
+		runStaged(ctx, *dir, path, version, migrating)
+	} else {
+		run(ctx, *dir, path, version, migrating)
+	}
 
-	if err := file.AddModuleStmt(newPath); err != nil {
-		log.Fatalf("Error upgrading module to %s: %s", newPath, err)
+	// In check mode, exit with a distinct code if there are upgrades
+	// available, so that the tool can be used as a CI check
+	if *check {
+		if upgradesAvailable {
+			fmt.Fprintln(stdout, "Upgrades available")
+			exit(2)
+		}
+		fmt.Fprintln(stdout, "All dependencies are at their latest major version")
+		exit(0)
+	}
+
+	// In dry-run mode, exit with a distinct code if there was nothing to
+	// change, so that the tool can be used as a lint check
+	if *dryRun && !dryRunChanges {
+		fmt.Fprintln(stdout, "Nothing to change")
+		exit(3)
 	}
 
-	// Rewrite import paths in files
-	if err := rewriteImports(*dir, []upgrade{{oldPath: path, newPath: newPath}}); err != nil {
-		log.Fatalf("Error rewriting imports: %s", err)
+	// Otherwise, exit with a distinct code if nothing was upgraded, so that
+	// scripts can tell whether anything changed (targets that don't upgrade
+	// anything, and modes that don't apply changes, are exempt)
+	if upgradeTarget(path) && !*dryRun && !*printPlan && !upgradesApplied {
+		fmt.Fprintln(stdout, "No upgrades available")
+		exit(2)
 	}
+	exit(0) // Writes the audit log
 }
 
-func upgradeDependency(file *modfile.File, path, version string) {
-	// Validate and parse the module path
-	if err := module.CheckPath(path); err != nil {
-		log.Fatalf("Invalid module path %s: %s", path, err)
+// upgradeTarget reports whether the given target upgrades modules, as opposed
+// to a special target that does something else (e.g. "pin").
+func upgradeTarget(path string) bool {
+	switch path {
+	case "pin", "security", "rollback":
+		return false
 	}
+	return true
+}
+
+// dryRunChanges records whether any changes would have been made in dry-run
+// mode.
+var dryRunChanges bool
+
+// upgradesApplied records whether any upgrade was written to disk.
+var upgradesApplied bool
+
+// upgradesAvailable records whether any dependency could be upgraded in check
+// mode.
+var upgradesAvailable bool
+
+// run performs the requested upgrade of the module in the given directory.
+func run(ctx context.Context, dir, path, version string, migrating bool) {
+	start := time.Now()
+	file := readModFile(dir)
+	modulePath := file.Module.Mod.Path
 
 	var (
-		newPath     string
-		fullVersion string
+		upgrades []upgrade
+		summary  *upgradeSummary // Only when upgrading all dependencies
+		pinned   []string        // Only when pinning dependencies
 	)
-	switch version {
-	case "":
-		// If no target major version was given, call 'go list -m'
-		// to find the highest available major version
-		var err error
-		fullVersion, err = getUpgradeVersion(path)
-		if err != nil {
-			log.Fatalf("Error finding upgrade version: %s", err)
+	switch path {
+	case "", file.Module.Mod.Path:
+		upgrades = upgradeModule(dir, file, version)
+	case "all":
+		upgrades, summary = upgradeAllDependencies(ctx, dir, file)
+	case "remap":
+		// Only the module path mappings are applied (below)
+	case "pin":
+		pinned = pinDependencies(file)
+	case "security":
+		reportVulnerabilities(ctx, dir, file)
+		return
+	default:
+		upgrades = upgradeDependency(ctx, dir, file, path, version)
+	}
+
+	// Lookups that failed because the tool was interrupted are otherwise
+	// indistinguishable from lookups that found nothing to upgrade
+	exitIfInterrupted(ctx)
+
+	// In check mode, the available upgrades have already been printed, and
+	// nothing is rewritten
+	if *check {
+		if len(upgrades) > 0 {
+			upgradesAvailable = true
 		}
-		if fullVersion == "" {
-			log.Fatalf("No versions available for upgrade")
+		printReport(plan{upgrades: upgrades, summary: summary}, modulePath, start)
+		if summary.Errors > 0 {
+			fatalf("Error getting upgrade versions for %d module(s) (see above)", summary.Errors)
 		}
+		return
+	}
+
+	// Extra module path mappings (e.g. of packages split out into their own
+	// modules) are rewritten in the same pass as the upgrades
+	if len(remaps) > 0 && path != "pin" {
+		upgrades = append(upgrades, remapModules(ctx, dir, file)...)
+	}
+
+	// Replacements (e.g. of an upgraded dependency by a local copy of it)
+	// are added in addition to the upgraded require lines
+	if len(replaces) > 0 && path != "pin" {
+		addReplacements(file)
+	}
+	upgradesPlanned.Add(int64(len(upgrades)))
+
+	// Upgraded dependencies may require a higher go version than the one
+	// the module declares (unless it's set explicitly)
+	if *goVersion != "" {
+		setGoVersion(ctx, dir, file)
+	} else {
+		updateGoDirective(ctx, dir, file, upgrades)
+	}
+
+	// The module's own packages are grouped last (as with 'goimports
+	// -local'), using its path after any upgrade of the module itself
+	if *goimports {
+		imports.LocalPrefix = file.Module.Mod.Path
+	}
+
+	// Rewrite import paths in files (in memory)
+	files, rewriteErr := rewriteModuleImports(ctx, dir, upgrades)
 
-		// Figure out what the post-upgrade module path should be
-		newPath, err = upgradePath(path, fullVersion)
+	// Files whose imports couldn't be rewritten (with -continue-on-error)
+	// are skipped, but still cause the tool to fail, once everything else
+	// is done
+	if rewriteErr != nil {
+		defer fatalf("Error rewriting imports: %s (fix them manually)", rewriteErr)
+	}
+
+	// Dependencies whose versions couldn't be looked up are skipped, but
+	// still cause the tool to fail, once everything else is done
+	if summary != nil && summary.Errors > 0 {
+		defer fatalf("Error getting upgrade versions for %d module(s) (see above)", summary.Errors)
+	}
+
+	p := plan{upgrades: upgrades, files: files, summary: summary}
+	if *printPlan {
+		p.print(true)
+		printReport(p, modulePath, start)
+		return
+	}
+
+	// The diff is printed before anything is written, since it compares
+	// the rewritten files against their contents on disk
+	if *showDiff {
+		changed, err := printDiffs(stdout, dir, file, files)
 		if err != nil {
-			log.Fatalf("Error upgrading module path %s to %s: %s", path, fullVersion, err)
+			fatalf("Error printing diff: %s", err)
 		}
-	default:
-		// If a target version was given, make sure it's valid, then call
-		// 'go list -m' to get the full version and path (which depends on
-		// whether the version is incompatible or not)
-		if !semver.IsValid(version) {
-			log.Fatalf("Invalid upgrade version: %s", version)
+		if *dryRun {
+			if changed {
+				dryRunChanges = true
+			}
+			printReport(p, modulePath, start)
+			return
 		}
+	}
 
-		var err error
-		newPath, fullVersion, err = upgradePathToVersion(path, version)
+	// In dry-run mode, the import rewrites have already been printed, so only
+	// the go.mod changes are left to show
+	if *dryRun {
+		changed, err := printModFileDiff(dir, file)
 		if err != nil {
-			log.Fatalf("Error getting upgrade path and version: %s", err)
+			fatalf("Error comparing module file: %s", err)
 		}
+		if changed || len(files) > 0 {
+			dryRunChanges = true
+		}
+		printReport(p, modulePath, start)
+		return
 	}
 
-	// Make sure the given module is actually a dependency in the go.mod file
-	var (
-		found             = false
-		oldVersion        = ""
-		alreadyExists     = false
-		removePreexisting = false
-	)
-	for _, require := range file.Require {
-		switch require.Mod.Path {
-		case path:
-			found = true
-			oldVersion = require.Mod.Version
-		case newPath:
-			if strings.HasPrefix(require.Mod.Version, version) {
-				// Only keep existing version if it matches
-				// the provided version (and/or is more specific)
-				alreadyExists = true
-				fullVersion = require.Mod.Version
-			} else {
-				// Otherwise, remove and replace the pre-existing dependency
-				removePreexisting = true
-			}
+	// In interactive mode, show the full plan and confirm it before
+	// modifying anything on disk (unless each upgrade was already confirmed
+	// individually, when upgrading all dependencies)
+	if *interactive && path != "all" {
+		p.print(*verbose)
+		ok, err := confirm("Apply these changes?")
+		if err != nil {
+			fatalf("Error reading confirmation: %s", err)
+		}
+		if !ok {
+			fmt.Fprintln(stdout, "No changes applied")
+			return
 		}
 	}
 
-	if !found {
-		log.Fatalf("Module not a known dependency: %s", path)
+	// Keep a copy of every file the upgrade modifies (on disk, so that it
+	// survives the tool failing part way through), in case it needs to be
+	// rolled back. A leftover snapshot means a previous upgrade didn't
+	// complete, and taking another would make it impossible to roll back.
+	if snapDir, err := latestSnapshot(dir); err != nil {
+		fatalf("Error checking for snapshot: %s", err)
+	} else if snapDir != "" {
+		fatalf("A previous upgrade did not complete: run 'upgrade rollback' to restore the files it modified, or remove %s to keep them", filepath.Join(dir, snapshotDir))
+	}
+	snap, err := takeSnapshot(snapshotFiles(dir, files)...)
+	if err != nil {
+		fatalf("Error taking snapshot before upgrade: %s", err)
+	}
+	snapDir, err := snap.save(dir)
+	if err != nil {
+		fatalf("Error saving snapshot before upgrade: %s", err)
+	}
+	if *verbose {
+		fmt.Fprintf(stdout, "Saved snapshot of %d file(s) to %s\n", len(snap), snapDir)
 	}
 
-	fmt.Printf("%s %s -> %s %s\n", path, oldVersion, newPath, fullVersion)
+	writeModFile(dir, file)
 
-	// Drop the old module dependency and add the new, upgraded one (unless the
-	// new major version of the dependency already existed as a dependency, in
-	// which case, we drop it if didn't match the provided version, or maintain
-	// it if it did)
-	if err := file.DropRequire(path); err != nil {
-		log.Fatalf("Error dropping module requirement %s: %s", path, err)
-	}
-	if removePreexisting {
-		if err := file.DropRequire(newPath); err != nil {
-			log.Fatalf("Error dropping module requirement %s: %s", newPath, err)
+	// Pinned dependencies are added to the config file along with go.mod
+	if *upgradeYAML && len(pinned) > 0 {
+		if err := addIgnoredModules(dir, pinned); err != nil {
+			fatalf("Error pinning dependencies: %s (run 'upgrade rollback' to restore the original files)", err)
 		}
 	}
-	if !alreadyExists {
-		if err := file.AddRequire(newPath, fullVersion); err != nil {
-			log.Fatalf("Error adding module requirement %s: %s", newPath, err)
+
+	// Write modified files after the go.mod file has been processed, to
+	// avoid issues with "go list" during the process (in case the upgrade
+	// breaks the build)
+	written, err := writeFiles(files)
+	if err != nil {
+		fatalf("Error rewriting imports: %s (run 'upgrade rollback' to restore the original files)", err)
+	}
+	if *vendor && hasVendorDir(dir) {
+		if err := writeVendorModules(dir, upgrades); err != nil {
+			fatalf("Error updating vendored modules: %s (run 'upgrade rollback' to restore the original files)", err)
 		}
 	}
+	upgradesWritten.Add(int64(len(upgrades)))
 
-	// If new path differs from old, rewrite import paths (paths can be the
-	// same in case of minor version update)
-	if newPath != path {
-		// Rewrite import paths in files
-		if err := rewriteImports(*dir, []upgrade{{oldPath: path, newPath: newPath}}); err != nil {
-			log.Fatalf("Error rewriting imports: %s", err)
+	// Run 'go list' after writing the updated go.mod file, in case there are
+	// transitive dependencies that need to be updated in the go.mod file
+	// (otherwise, the user's go.mod file would change again the next time they
+	// ran go install, go get, go list, etc.). Not when imports haven't been
+	// rewritten, though, since it would add the old module paths back.
+	if !*noRewrite {
+		pkgs, err := list(ctx, dir)
+		if err != nil {
+			exitIfInterrupted(ctx)
+			fatalf("Error finalizing transitive dependency versions: %s", err)
+		}
+		// A module without any packages has no requirements to update, so
+		// the go.mod file may not be as the go command would leave it
+		if len(pkgs) == 0 {
+			fmt.Fprintln(stdout, "Warning: module contains no packages ('go list ./...' matched none), so transitive dependency versions weren't finalized")
+		} else if *verbose {
+			fmt.Fprintf(stdout, "Finalized transitive dependency versions of %d package(s)\n", len(pkgs))
 		}
 	}
-}
 
-func upgradeAllDependencies(file *modfile.File) {
-	required := map[string]string{}
-	for _, require := range file.Require {
-		required[require.Mod.Path] = require.Mod.Version
+	// Download before any other post-upgrade steps, so that they find the
+	// upgraded modules in the module cache
+	if *runDownload {
+		if err := download(ctx, dir); err != nil {
+			exitIfInterrupted(ctx)
+			fatalf("Error downloading modules: %s", err)
+		}
 	}
 
-	// For each requirement, check if there is a higher major version available
-	var (
-		upgrades []upgrade
-		wg       = sync.WaitGroup{}
-		lock     = sync.Mutex{}
-	)
-	for _, require := range file.Require {
-
-		// Don't upgrade indirect dependencies (don't have access
-		// to the source code, so can't modify import paths)
-		if require.Indirect {
-			continue
+	if *runVerify {
+		if err := verify(ctx, dir); err != nil {
+			exitIfInterrupted(ctx)
+			fatalf("Error verifying modules: %s", err)
 		}
+	}
 
-		// The getUpgradeVersion function calls 'go list', which can be slow if
-		// the module info isn't already in the module cache. Making those
-		// calls concurrently improves performance.
-		wg.Add(1)
-		go func(require *modfile.Require) {
-			defer wg.Done()
+	if *runTidy {
+		if err := tidy(ctx, dir); err != nil {
+			exitIfInterrupted(ctx)
+			fatalf("Error tidying module: %s", err)
+		}
+	}
 
-			if *verbose {
-				fmt.Printf("Fetching %s\n", require.Mod.Path)
-			}
-			version, err := getUpgradeVersion(require.Mod.Path)
-			if err != nil {
-				log.Fatalf("Error getting upgrade version for module %s: %s",
-					require.Mod.Path, err,
-				)
+	// Check the module still compiles (which the "migrate" target does
+	// anyway). Building can legitimately take a long time, so it isn't
+	// subject to the timeout.
+	if (*runBuild || *buildFix) && !migrating {
+		buildCtx, cancel := withoutTimeout(ctx)
+		defer cancel()
+		if err := build(buildCtx, dir); err != nil {
+			exitIfInterrupted(buildCtx)
+			if !*buildFix {
+				fatalf("Error building module: %s\n(run 'upgrade rollback' to restore the original files)", err)
 			}
 
-			if version == "" {
-				if *verbose {
-					fmt.Printf("%s - no versions available for upgrade\n", require.Mod.Path)
-				}
-				return
+			if err := snap.restore(); err != nil {
+				fatalf("Error rolling back upgrade: %s (run 'upgrade rollback' to restore the original files)", err)
 			}
-
-			newPath, err := upgradePath(require.Mod.Path, version)
-			if err != nil {
-				log.Fatalf("Error upgrading module path %s to %s: %s",
-					require.Mod.Path, version, err,
-				)
+			if err := removeSnapshot(dir); err != nil {
+				fatalf("Error removing snapshot: %s", err)
 			}
+			fatalf("Error building module: %s\n(upgrade rolled back)", err)
+		}
+		if *verbose {
+			fmt.Fprintln(stdout, "Module builds successfully")
+		}
+	}
 
-			// Beyond here, several things need to be synchronized:
-			// - Reads/writes to required map
-			// - Writes to upgrades slice
-			// - Modification of the *modfile.File
-			// TODO: Move this logic back into main goroutine, and send
-			// upgrades to it via an upgrade channel
-			lock.Lock()
-			defer lock.Unlock()
-
-			existingVersion, exists := required[newPath]
-			if exists {
-				// If the upgraded version already exists as a dependency, maintain
-				// the current minor/patch version
-				version = existingVersion
-			}
+	if len(upgrades) > 0 {
+		upgradesApplied = true
+	}
+	if *reportFile != "" {
+		if err := recordReport(*reportFile, file.Module.Mod.Path, p); err != nil {
+			fatalf("Error writing report: %s", err)
+		}
+	}
 
-			upgrades = append(upgrades, upgrade{
-				oldPath: require.Mod.Path,
-				newPath: newPath,
-			})
+	if *verbose || *summaryOnly {
+		fmt.Fprintf(stdout, "Upgraded %d module(s), rewrote imports in %d file(s)\n", len(upgrades), len(written))
+	}
+	printReport(p, modulePath, start)
+
+	// The build and tests can legitimately take a long time, so don't
+	// subject them to the timeout
+	if migrating {
+		migrateCtx, cancel := withoutTimeout(ctx)
+		defer cancel()
+		if err := migrate(migrateCtx, dir, snap); err != nil {
+			exitIfInterrupted(migrateCtx)
+			fatalf("Error migrating: %s", err)
+		}
+	}
 
-			fmt.Printf("%s %s -> %s %s\n", require.Mod.Path, require.Mod.Version, newPath, version)
+	// The upgrade succeeded, so there's nothing left to roll back
+	if err := removeSnapshot(dir); err != nil {
+		fatalf("Error removing snapshot: %s", err)
+	}
 
-			// Drop the old module dependency and add the new, upgraded one
-			// NOTE: require.Mod becomes invalid after this operation
-			if err := file.DropRequire(require.Mod.Path); err != nil {
-				log.Fatalf("Error dropping module requirement %s: %s",
-					require.Mod.Path, err,
-				)
-			}
+	if *commit && len(upgrades) > 0 {
+		// Only the files the upgrade modified are committed (not any other
+		// changes in the module directory)
+		if err := commitUpgrades(ctx, dir, upgrades, append(snapshotFiles(dir, nil), written...)); err != nil {
+			fatalf("Error committing upgrade: %s", err)
+		}
+	}
+}
 
-			// Add the upgraded version if it doesn't already exist as a dependency
-			if !exists {
-				if err := file.AddRequire(newPath, version); err != nil {
-					log.Fatalf("Error adding module requirement %s: %s", newPath, err)
-				}
-				required[newPath] = version
-			}
-		}(require)
+// printReport prints the summary of the upgrade of all dependencies (with
+// -summary), and, in JSON mode, the JSON report of the plan.
+func printReport(p plan, modulePath string, start time.Time) {
+	if p.summary != nil {
+		p.summary.Elapsed = time.Since(start).Round(time.Millisecond)
+		if *showSummary {
+			p.summary.print()
+		}
 	}
-	wg.Wait()
 
-	if err := rewriteImports(*dir, upgrades); err != nil {
-		log.Fatalf("Error rewriting imports: %s", err)
+	if !*jsonOutput {
+		return
 	}
-}
 
-func upgradePath(path, version string) (string, error) {
-	prefix, pathMajor, ok := module.SplitPathVersion(path)
-	if !ok {
-		return "", fmt.Errorf("invalid module path: %s", path)
+	// In check mode, only the upgrades available are reported
+	var v any = p.report(modulePath)
+	if *check {
+		v = p.checkReport()
+	}
+	if err := writeJSON(v); err != nil {
+		fatalf("Error writing JSON report: %s", err)
 	}
+}
 
-	if version == "" {
-		// If no version was specified, upgrade to next sequential version
-		if pathMajor == "" {
-			version = "v2"
-		} else {
-			num, err := strconv.Atoi(strings.TrimPrefix(pathMajor, "/v"))
-			if err != nil {
-				return "", fmt.Errorf("invalid major version in module path: %s", pathMajor)
-			}
-			num++
-			version = fmt.Sprintf("v%d", num)
-		}
+func readModFile(dir string) *modfile.File {
+	// Read and parse the go.mod file
+	filePath := path.Join(dir, "go.mod")
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		fatalf("Error reading module file %s: %s", filePath, err)
 	}
 
-	major := semver.Major(version)
-	switch major {
-	case "v0", "v1":
-		return prefix, nil
+	file, err := modfile.Parse(filePath, b, nil)
+	if err != nil {
+		fatalf("Error parsing module file %s: %s", filePath, err)
 	}
-	newPath := fmt.Sprintf("%s/%s", prefix, major)
-	if err := module.CheckPath(newPath); err != nil {
-		return "", fmt.Errorf("invalid module path after upgrade - %s: %s", newPath, err)
 
+	return file
+}
+
+func formatModFile(f *modfile.File) []byte {
+	out, err := modupgrade.FormatModFile(f)
+	if err != nil {
+		fatalf("Error formatting module file: %s", err)
 	}
-	return newPath, nil
+	return out
 }
 
-// Smaller batch size seems to actually be better sometimes. I think maybe
-// because it prevents the go module proxy from trying to fetch/load too many
-// non-existent major versions? Sticking with 1 for now for simplicity.
-const batchSize = 1
+func writeModFile(dir string, f *modfile.File) {
+	// Format and re-write the module file
+	out := formatModFile(f)
 
-func getUpgradeVersion(path string) (string, error) {
-	// Split module path
-	prefix, pathMajor, ok := module.SplitPathVersion(path)
-	if !ok {
-		return "", fmt.Errorf("invalid module path: %s", path)
+	filePath := path.Join(dir, "go.mod")
+	if err := modupgrade.WriteFile(filePath, out); err != nil {
+		fatalf("Error writing module file %s: %s", filePath, err)
 	}
+}
 
-	var version int
-	if pathMajor != "" {
-		// If the dependency already has a major version in its import path,
-		// start our search for a higher major version there
-		var err error
-		version, err = strconv.Atoi(strings.TrimPrefix(pathMajor, "/v"))
-		if err != nil {
-			return "", fmt.Errorf("invalid major version '%s': %s", pathMajor, err)
+// printModFileDiff prints the lines of the go.mod file that the upgrade would
+// remove or add, and reports whether there were any.
+func printModFileDiff(dir string, f *modfile.File) (bool, error) {
+	filePath := path.Join(dir, "go.mod")
+	before, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return false, fmt.Errorf("error reading module file %s: %s", filePath, err)
+	}
+	after := formatModFile(f)
+
+	var (
+		oldLines = strings.Split(string(before), "\n")
+		newLines = strings.Split(string(after), "\n")
+		changed  = false
+	)
+	for _, line := range oldLines {
+		if !containsLine(newLines, line) && strings.TrimSpace(line) != "" {
+			if !changed {
+				changed = true
+				fmt.Fprintf(stdout, "%s:\n", filePath)
+			}
+			fmt.Fprintf(stdout, "\t- %s\n", strings.TrimSpace(line))
 		}
-		version++
-	} else {
-		// If the dependency does not have a major version in its import path,
-		// get the highest available minor update version (including
-		// incompatible major versions, which allows us to skip over them and
-		// start at the first module-aware major version)
-		minorUpdateVersion, err := getMinorUpdateVersion(path)
-		if err != nil {
-			return "", fmt.Errorf("error getting minor update version for %s: %s", path, err)
+	}
+	for _, line := range newLines {
+		if !containsLine(oldLines, line) && strings.TrimSpace(line) != "" {
+			if !changed {
+				changed = true
+				fmt.Fprintf(stdout, "%s:\n", filePath)
+			}
+			fmt.Fprintf(stdout, "\t+ %s\n", strings.TrimSpace(line))
 		}
+	}
+	return changed, nil
+}
 
-		major := semver.Major(minorUpdateVersion)
-		version, err = strconv.Atoi(strings.TrimPrefix(major, "v"))
-		if err != nil {
-			return "", fmt.Errorf("invalid minor update version: %s", minorUpdateVersion)
+// containsLine reports whether the given line is one of the lines, ignoring
+// differences in leading and trailing whitespace (which formatting the go.mod
+// file can introduce).
+func containsLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == strings.TrimSpace(line) {
+			return true
 		}
+	}
+	return false
+}
 
-		// Make sure not to try upgrading path to /v1
-		// (i.e. if the highest minor update version is v0.x.x)
-		if version < 1 {
-			version = 1
-		}
-		version++
+// newUpgrader returns the library Upgrader for the module in the given
+// directory, configured from the command line flags. If file is nil, the
+// go.mod file is read from the directory if needed. Any extra options given
+// override the flags.
+func newUpgrader(dir string, file *modfile.File, extraOpts ...modupgrade.Option) *modupgrade.Upgrader {
+	opts := []modupgrade.Option{
+		modupgrade.WithLister(lister),
+		modupgrade.WithOutput(stdout),
+		modupgrade.WithVerbose(*verbose),
+		modupgrade.WithPrerelease(*pre),
+		modupgrade.WithDowngrade(*downgrade),
+		modupgrade.WithMaxGap(*maxGap),
+		modupgrade.WithBatchSize(*batchSize),
+		// Picking a version, or upgrading in stages, requires all of them
+		modupgrade.WithBinarySearch(*binarySearch && !*pickVersion && !*staged),
+		modupgrade.WithStrict(*strict && !*check),
+		modupgrade.WithIndirect(*indirect),
+		modupgrade.WithExclude(ignoreModules.match),
+		modupgrade.WithListFlags(listFlags()...),
+		modupgrade.WithMainModules(func(path string) bool { return workspaceModules[path] }),
+		modupgrade.WithSince(sinceDate),
+		modupgrade.WithConcurrency(*concurrency),
+		modupgrade.WithMaxFileSize(*maxFileSize),
+		modupgrade.WithContinueOnError(*continueOnError),
+		modupgrade.WithSkipGoGenerate(*skipGoGenerate),
+	}
+	if len(filterModules) > 0 {
+		opts = append(opts, modupgrade.WithFilter(filterModules.match))
+	}
+	if *cacheDir != "" {
+		opts = append(opts, modupgrade.WithVersionCache(fileVersionCache{}))
 	}
+	if *pickVersion {
+		opts = append(opts, modupgrade.WithPickVersion(promptVersion))
+	}
+	if *interactive {
+		opts = append(opts, modupgrade.WithConfirm(confirmUpgrade))
+	}
+	if file != nil {
+		opts = append(opts, modupgrade.WithModFile(file))
+	}
+	return modupgrade.New(dir, append(opts, extraOpts...)...)
+}
 
-	// TODO: Consider actually upgrading to higher incompatible versions? Not
-	// sure, because that could also be done with go get -u. It just seems
-	// strange if I'm on, say, v1.0.0+incompatible and it wouldn't upgrade me
-	// to, for example, v2.0.0+incompatible. Would need to ensure it's actually
-	// a higher major than the current version.
-	var upgradeVersion string
-	for {
-		// Make batched calls to 'go list -m' for
-		// better performance (ideally, a single call).
-		var batch []string
-		for i := 0; i < batchSize; i++ {
-			modulePath := fmt.Sprintf("%s/v%d@v%d", prefix, version, version)
-			batch = append(batch, modulePath)
-			version++
+func upgradeModule(dir string, file *modfile.File, version string) []upgrade {
+	u, err := newUpgrader(dir, file).UpgradeModule(version)
+	if err != nil {
+		if errors.Is(err, modupgrade.ErrDowngrade) {
+			fatalf("Error upgrading module: %s (use -downgrade to downgrade)", err)
 		}
+		fatalf("Error upgrading module: %s", err)
+	}
 
-		results, err := listModules(context.Background(), batch...)
-		if err != nil {
-			return "", fmt.Errorf("error getting module info: %s", err)
-		}
+	fmt.Fprintf(stdout, "%s -> %s\n", u.OldPath, u.NewPath)
+	printPathNote(u.OldPath, u.NewPath)
 
-		for _, result := range results {
-			if result.Error != nil {
-				if *verbose {
-					fmt.Println(result.Error.Err)
-				}
-				return upgradeVersion, nil
-			}
-			upgradeVersion = result.Version
+	return []upgrade{u}
+}
+
+func upgradeDependency(ctx context.Context, dir string, file *modfile.File, path, version string) []upgrade {
+	upgrader := newUpgrader(dir, file)
+
+	// Transitive (or new) dependencies can be adopted at a higher major
+	// version, if asked for
+	if *requireMissing && requiredVersion(file, path) == "" {
+		addRequirement(ctx, dir, file, path)
+	}
+
+	u, err := upgrader.UpgradeDependency(ctx, path, version)
+	switch {
+	case errors.Is(err, modupgrade.ErrSkipped):
+		return nil
+	case errors.Is(err, modupgrade.ErrNoUpgrade) && version != "":
+		fmt.Fprintf(stdout, "%s is already at version %s\n", path, version)
+		return nil
+	case errors.Is(err, modupgrade.ErrNoUpgrade):
+		fmt.Fprintf(stdout, "%s is already at its highest major version\n", path)
+		if !*pickVersion {
+			printIncompatibleNote(path, incompatibleUpgradeVersion(ctx, upgrader, path, requiredVersion(file, path)))
 		}
+		return nil
+	case errors.Is(err, modupgrade.ErrDowngrade):
+		fatalf("Error upgrading module: %s (use -downgrade to downgrade)", err)
+	case errors.Is(err, modupgrade.ErrRetracted):
+		fatalf("Refusing to upgrade to retracted version (-strict): %s", err)
+	case err != nil:
+		fatalf("Error upgrading dependency: %s", err)
+	}
+
+	fmt.Fprintf(stdout, "%s\n", u)
+	printPathNote(u.OldPath, u.NewPath)
+
+	// A requirement on the module itself upgrades the module, which has no
+	// versions
+	if u.OldVersion == "" && u.NewVersion == "" {
+		return []upgrade{u}
 	}
+	checkRetraction(u)
+	if version == "" && u.NewPath != u.OldPath {
+		printIncompatibleNote(path, incompatibleUpgradeVersion(ctx, upgrader, path, u.OldVersion))
+	}
+
+	// NOTE: The new path can be the same as the old one in the case of a
+	// minor version update, in which case no imports will be rewritten
+	return []upgrade{u}
 }
 
-func getMinorUpdateVersion(path string) (string, error) {
-	results, err := listModules(context.Background(), path)
-	if err != nil {
-		return "", fmt.Errorf("error getting module info: %s", err)
+func upgradeAllDependencies(ctx context.Context, dir string, file *modfile.File) ([]upgrade, *upgradeSummary) {
+	required := map[string]string{}
+	for _, require := range file.Require {
+		required[require.Mod.Path] = require.Mod.Version
 	}
-	result := results[0]
 
-	if result.Error != nil {
-		return "", fmt.Errorf("error getting module info: %s", result.Error.Err)
+	upgrader := newUpgrader(dir, file, modupgrade.WithOnUpgrade(func(u upgrade) {
+		printPathNote(u.OldPath, u.NewPath)
+		checkRetraction(u)
+	}))
+	upgrades, s, err := upgrader.UpgradeAllDependencies(ctx)
+
+	if *cacheDir != "" {
+		if err := saveVersionCache(); err != nil {
+			fmt.Fprintf(stdout, "Warning: error saving version cache: %s\n", err)
+		}
 	}
 
-	if result.Update != nil {
-		if !semver.IsValid(result.Update.Version) {
-			return "", fmt.Errorf("invalid minor update version returned in module info: %s", result.Update.Version)
+	var (
+		noUpgrade *modupgrade.NoUpgradeError
+		lookupErr modupgrade.LookupErrors
+	)
+	switch {
+	case errors.As(err, &noUpgrade):
+		fatalf("No higher major version available for %d module(s) (-strict):\n\t%s",
+			len(noUpgrade.Paths), strings.Join(noUpgrade.Paths, "\n\t"),
+		)
+	case errors.As(err, &lookupErr):
+		// A failure to look up one dependency's versions doesn't prevent
+		// the others from being upgraded, but is reported
+		for _, err := range lookupErr {
+			log.Printf("Error getting upgrade version for module %s", err)
 		}
-		return result.Update.Version, nil
+	case errors.Is(err, modupgrade.ErrRetracted):
+		fatalf("Refusing to upgrade to retracted version (-strict): %s", err)
+	case err != nil:
+		exitIfInterrupted(ctx)
+		fatalf("Error upgrading dependencies: %s", err)
 	}
 
-	// Use current version if no update version is given
-	// (i.e. we're already at the highest available minor version)
-	if !semver.IsValid(result.Version) {
-		return "", fmt.Errorf("invalid version returned in module info: %s", result.Version)
+	// Modules that are only required by dependencies can't be upgraded,
+	// but can hold the module back, so are reported if asked for
+	if *transitive {
+		for _, u := range upgrades {
+			required[u.NewPath] = u.NewVersion
+		}
+		reportTransitiveUpgrades(ctx, dir, file, required)
 	}
-	return result.Version, nil
-}
 
-func upgradePathToVersion(path, version string) (string, string, error) {
-	prefix, _, ok := module.SplitPathVersion(path)
-	if !ok {
-		return "", "", fmt.Errorf("invalid module path: %s", path)
+	return upgrades, &upgradeSummary{
+		Checked:  s.Checked,
+		Upgraded: s.Upgraded,
+		Latest:   s.Latest,
+		Skipped:  s.Skipped,
+		Excluded: s.Excluded,
+		Errors:   s.Errors,
 	}
+}
 
-	newPath, err := upgradePath(path, version)
+// incompatibleUpgradeVersion returns the higher +incompatible version of the
+// dependency, if any, to tell the user about with printIncompatibleNote.
+func incompatibleUpgradeVersion(ctx context.Context, upgrader *modupgrade.Upgrader, path, current string) string {
+	incompatible, err := upgrader.IncompatibleUpgradeVersion(ctx, path, current)
 	if err != nil {
-		return "", "", fmt.Errorf("error upgrading module path %s to %s: %s", path, version, err)
+		fatalf("Error finding incompatible upgrade version: %s", err)
 	}
+	return incompatible
+}
 
-	results, err := listModules(context.Background(),
-		fmt.Sprintf("%s@%s", newPath, version), // Module-aware
-		fmt.Sprintf("%s@%s", prefix, version),  // Incompatible
-	)
-	if err != nil {
-		return "", "", fmt.Errorf("error getting module info: %s", err)
+// checkRetraction warns if the version being upgraded to has been retracted.
+// In strict mode, the upgrader refuses to upgrade to it instead.
+func checkRetraction(u upgrade) {
+	if u.Retracted == "" {
+		return
 	}
 
-	for _, result := range results {
-		if result.Error == nil {
-			return result.Path, result.Version, nil
+	// A retracted pre-release is even less likely to be usable
+	if semver.Prerelease(u.NewVersion) != "" {
+		fmt.Fprintf(stdout, "WARNING: %s %s is a RETRACTED PRE-RELEASE version, and is very likely broken: %s\n", u.NewPath, u.NewVersion, u.Retracted)
+		return
+	}
+	fmt.Fprintf(stdout, "WARNING: %s %s is retracted: %s\n", u.NewPath, u.NewVersion, u.Retracted)
+}
+
+var (
+	pathNoteOnce        sync.Once
+	gopkginPathNoteOnce sync.Once
+)
+
+// printIncompatibleNote tells the user about a higher +incompatible version of
+// the dependency, which isn't upgraded to unless asked for explicitly.
+func printIncompatibleNote(path, incompatible string) {
+	if incompatible == "" {
+		return
+	}
+	fmt.Fprintf(stdout, "Note: %s also has a higher +incompatible version, %s (upgrade to it with 'upgrade %s %s')\n",
+		path, incompatible, path, semver.Major(incompatible),
+	)
+}
+
+// requiredVersion returns the version of the given module required by the
+// go.mod file, or an empty string if it isn't required.
+func requiredVersion(file *modfile.File, path string) string {
+	for _, require := range file.Require {
+		if require.Mod.Path == path {
+			return require.Mod.Version
 		}
 	}
+	return ""
+}
+
+// printPathNote explains why a module's path changed as part of an upgrade,
+// for the benefit of users unfamiliar with Go's module versioning
+// conventions. The note is only printed once per run.
+func printPathNote(oldPath, newPath string) {
+	if oldPath == newPath {
+		return
+	}
+
+	if strings.HasPrefix(newPath, "gopkg.in/") {
+		gopkginPathNoteOnce.Do(func() {
+			fmt.Fprintln(stdout, "Note: import path changed because gopkg.in modules encode the major version as a .vN suffix (https://labix.org/gopkg.in)")
+		})
+		return
+	}
 
-	return "", "", fmt.Errorf("error getting version information: %s", results[0].Error.Err)
+	pathNoteOnce.Do(func() {
+		fmt.Fprintln(stdout, "Note: import path changed because v2+ modules use a major version suffix per Go module conventions (https://go.dev/blog/v2-go-modules)")
+	})
 }
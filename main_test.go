@@ -0,0 +1,717 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/modfile"
+)
+
+// fakeLister is a modupgrade.Lister that returns canned results, rather than
+// shelling out to 'go list'.
+type fakeLister struct {
+	results []modupgrade.Module
+	err     error
+}
+
+func (l fakeLister) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]modupgrade.Module, error) {
+	return l.results, l.err
+}
+
+func withLister(t *testing.T, l modupgrade.Lister) {
+	t.Helper()
+
+	orig := lister
+	lister = l
+	t.Cleanup(func() { lister = orig })
+}
+
+func TestUpgradeDependencyPreservesExcludes(t *testing.T) {
+	withLister(t, fakeLister{results: []modupgrade.Module{{
+		Path:    "github.com/foo/bar/v2",
+		Version: "v2.0.0",
+	}}})
+
+	const goMod = `module example.com/sample
+
+go 1.22
+
+require github.com/foo/bar v1.2.3
+
+exclude github.com/foo/bar v1.2.3
+`
+	file, err := modfile.Parse("go.mod", []byte(goMod), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+
+	upgradeDependency(context.Background(), ".", file, "github.com/foo/bar", "v2")
+	out := string(formatModFile(file))
+
+	// Excludes of the old major version survive the upgrade (they still
+	// apply if it remains in the build, e.g. as a transitive dependency), and
+	// are re-added under the new module path
+	if !strings.Contains(out, "exclude github.com/foo/bar v1.2.3") {
+		t.Errorf("Expected exclude directive to be preserved, got:\n%s", out)
+	}
+	if !strings.Contains(out, "exclude github.com/foo/bar/v2 v2.0.0") {
+		t.Errorf("Expected exclude directive for the new module path, got:\n%s", out)
+	}
+	if !strings.Contains(out, "require github.com/foo/bar/v2 v2.0.0") {
+		t.Errorf("Expected upgraded require directive, got:\n%s", out)
+	}
+}
+
+func TestUpdateGoDirective(t *testing.T) {
+	withLister(t, fakeLister{results: []modupgrade.Module{
+		{Path: "github.com/foo/bar/v2", Version: "v2.0.0", GoVersion: "1.21"},
+		{Path: "github.com/foo/baz/v3", Version: "v3.1.0", GoVersion: "1.22.1"},
+	}})
+
+	orig := *goUpdate
+	*goUpdate = true
+	t.Cleanup(func() { *goUpdate = orig })
+
+	file, err := modfile.Parse("go.mod", []byte("module example.com/sample\n\ngo 1.18\n"), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+
+	updateGoDirective(context.Background(), ".", file, []upgrade{
+		{OldPath: "github.com/foo/bar", OldVersion: "v1.0.0", NewPath: "github.com/foo/bar/v2", NewVersion: "v2.0.0"},
+		{OldPath: "github.com/foo/baz/v2", OldVersion: "v2.0.0", NewPath: "github.com/foo/baz/v3", NewVersion: "v3.1.0"},
+	})
+	if file.Go.Version != "1.22.1" {
+		t.Errorf("Expected go directive 1.22.1, got %s", file.Go.Version)
+	}
+}
+
+func TestRecordReport(t *testing.T) {
+	t.Cleanup(func() {
+		report.loaded, report.entries = false, nil
+	})
+
+	filePath := filepath.Join(t.TempDir(), "report.json")
+	if err := ioutil.WriteFile(filePath, []byte(`[{"module": "example.com/previous"}]`), 0644); err != nil {
+		t.Fatalf("Error writing report: %s", err)
+	}
+
+	p := plan{upgrades: []upgrade{{
+		OldPath:    "github.com/foo/bar",
+		OldVersion: "v1.0.0",
+		NewPath:    "github.com/foo/bar/v2",
+		NewVersion: "v2.0.0",
+	}}}
+	if err := recordReport(filePath, "example.com/sample", p); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatalf("Error reading report: %s", err)
+	}
+	var entries []reportEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		t.Fatalf("Report is not valid JSON: %s\n%s", err, b)
+	}
+
+	// The new entry is appended to the existing one
+	if len(entries) != 2 || entries[0].Module != "example.com/previous" {
+		t.Fatalf("Expected the existing entry and a new one, got:\n%s", b)
+	}
+	if entries[1].NewPath != "github.com/foo/bar/v2" || entries[1].Files == nil {
+		t.Errorf("Unexpected report entry:\n%s", b)
+	}
+}
+
+func TestUpdateToolchainDirective(t *testing.T) {
+	tests := []struct {
+		toolchain string
+		expected  string
+	}{
+		{toolchain: "go1.21.3", expected: ""},
+		{toolchain: "go1.22.1", expected: "go1.22.1"},
+		{toolchain: "go1.23.0-custom", expected: "go1.23.0-custom"},
+		{toolchain: "default", expected: "default"},
+	}
+	for _, test := range tests {
+		goMod := "module example.com/sample\n\ngo 1.22\n\ntoolchain " + test.toolchain + "\n"
+		file, err := modfile.Parse("go.mod", []byte(goMod), nil)
+		if err != nil {
+			t.Fatalf("Error parsing go.mod file: %s", err)
+		}
+
+		updateToolchainDirective(file)
+
+		var toolchain string
+		if file.Toolchain != nil {
+			toolchain = file.Toolchain.Name
+		}
+		if toolchain != test.expected {
+			t.Errorf("Toolchain %s: expected %q, got %q", test.toolchain, test.expected, toolchain)
+		}
+		if test.expected == "" && strings.Contains(string(formatModFile(file)), "toolchain") {
+			t.Errorf("Toolchain %s: expected toolchain directive to be removed, got:\n%s", test.toolchain, formatModFile(file))
+		}
+	}
+}
+
+// queryLister is a modupgrade.Lister that returns results for each query
+// individually, looked up by the query string. Unknown queries report an
+// error, as for a missing version.
+type queryLister map[string]modupgrade.Module
+
+func (l queryLister) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]modupgrade.Module, error) {
+	return l.results(modulePaths), nil
+}
+
+func (l queryLister) results(modulePaths []string) []modupgrade.Module {
+	var results []modupgrade.Module
+	for _, modulePath := range modulePaths {
+		result, ok := l[modulePath]
+		if !ok {
+			result = modupgrade.Module{Path: modulePath, Error: &modupgrade.ModuleError{Err: "no matching versions for query"}}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// listerFunc is a modupgrade.Lister that returns the results of a function,
+// e.g. to count the queries made.
+type listerFunc func(modulePaths []string) []modupgrade.Module
+
+func (f listerFunc) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]modupgrade.Module, error) {
+	return f(modulePaths), nil
+}
+
+func TestUpgradeAllDependenciesExistingMajorVersion(t *testing.T) {
+	withLister(t, queryLister{
+		"github.com/foo/bar":       {Path: "github.com/foo/bar", Version: "v1.0.0"},
+		"github.com/foo/bar/v2@v2": {Path: "github.com/foo/bar/v2", Version: "v2.1.0"},
+	})
+
+	orig := *concurrency
+	*concurrency = 1
+	t.Cleanup(func() { *concurrency = orig })
+
+	// The new major version of the dependency is already required
+	const goMod = `module example.com/sample
+
+go 1.22
+
+require (
+	github.com/foo/bar v1.0.0
+	github.com/foo/bar/v2 v2.0.0
+)
+`
+	file, err := modfile.Parse("go.mod", []byte(goMod), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+
+	upgrades, _ := upgradeAllDependencies(context.Background(), ".", file)
+	if len(upgrades) != 1 || upgrades[0].NewPath != "github.com/foo/bar/v2" {
+		t.Fatalf("Expected a single upgrade to github.com/foo/bar/v2, got %v", upgrades)
+	}
+
+	// The existing requirement (and its version) is kept, rather than
+	// duplicated, and the old major version is dropped
+	out := string(formatModFile(file))
+	if strings.Count(out, "github.com/foo/bar/v2") != 1 || !strings.Contains(out, "github.com/foo/bar/v2 v2.0.0") {
+		t.Errorf("Expected a single requirement on github.com/foo/bar/v2 v2.0.0, got:\n%s", out)
+	}
+	if strings.Contains(out, "github.com/foo/bar v1.0.0") {
+		t.Errorf("Expected requirement on github.com/foo/bar to be dropped, got:\n%s", out)
+	}
+	if _, err := modfile.Parse("go.mod", []byte(out), nil); err != nil {
+		t.Errorf("Upgraded go.mod file is invalid: %s\n%s", err, out)
+	}
+}
+
+func TestUpgradeAllDependenciesIgnoreModules(t *testing.T) {
+	withLister(t, queryLister{
+		"github.com/foo/bar/v2@v2": {Path: "github.com/foo/bar/v2", Version: "v2.0.0"},
+		"golang.org/x/mod/v2@v2":   {Path: "golang.org/x/mod/v2", Version: "v2.0.0"},
+		"github.com/baz/qux":       {Path: "github.com/baz/qux", Version: "v1.0.0"},
+		"github.com/baz/qux/v2@v2": {Path: "github.com/baz/qux/v2", Version: "v2.0.0"},
+	})
+
+	orig := *concurrency
+	*concurrency = 1
+	t.Cleanup(func() { *concurrency = orig })
+
+	// Module paths and patterns are matched the same way, whichever flag
+	// they're given in
+	ignoreModules = stringList{"github.com/foo/bar", "golang.org/x/*"}
+	t.Cleanup(func() { ignoreModules = nil })
+
+	const goMod = `module example.com/sample
+
+go 1.22
+
+require (
+	github.com/baz/qux v1.0.0
+	github.com/foo/bar v1.0.0
+	golang.org/x/mod v1.0.0
+)
+`
+	file, err := modfile.Parse("go.mod", []byte(goMod), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+
+	upgrades, summary := upgradeAllDependencies(context.Background(), ".", file)
+	if len(upgrades) != 1 || upgrades[0].NewPath != "github.com/baz/qux/v2" {
+		t.Fatalf("Expected a single upgrade to github.com/baz/qux/v2, got %v", upgrades)
+	}
+	if summary.Excluded != 2 || summary.Skipped != 0 {
+		t.Errorf("Expected 2 excluded and no skipped dependencies, got %d and %d", summary.Excluded, summary.Skipped)
+	}
+}
+
+func TestWithoutTimeout(t *testing.T) {
+	// The deadline doesn't apply to the returned context...
+	timed, cancelTimed := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelTimed()
+	<-timed.Done()
+
+	untimed, cancel := withoutTimeout(timed)
+	defer cancel()
+	if err := untimed.Err(); err != nil {
+		t.Errorf("Expected context without timeout to be live, got: %s", err)
+	}
+
+	// ...but cancellation does
+	parent, cancelParent := context.WithCancel(context.Background())
+	untimed, cancel = withoutTimeout(parent)
+	defer cancel()
+	cancelParent()
+	select {
+	case <-untimed.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Expected context without timeout to be cancelled with its parent")
+	}
+}
+
+func TestReadModuleMap(t *testing.T) {
+	const moduleMap = `# Modules renamed in the reorganization
+example.com/old/foo  example.com/new/foo
+
+example.com/old/bar	example.com/new/bar@v1.2.3 # Pinned
+`
+	filename := filepath.Join(t.TempDir(), "map.txt")
+	if err := ioutil.WriteFile(filename, []byte(moduleMap), 0644); err != nil {
+		t.Fatalf("Error writing module map file: %s", err)
+	}
+
+	entries, err := readModuleMap(filename)
+	if err != nil {
+		t.Fatalf("Unexpected error reading module map: %s", err)
+	}
+	expected := []string{
+		"example.com/old/foo=example.com/new/foo",
+		"example.com/old/bar=example.com/new/bar@v1.2.3",
+	}
+	if strings.Join(entries, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected entries %q, got %q", expected, entries)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("example.com/old/foo\n"), 0644); err != nil {
+		t.Fatalf("Error writing module map file: %s", err)
+	}
+	if _, err := readModuleMap(filename); err == nil {
+		t.Errorf("Expected error reading module map with a missing new path")
+	}
+}
+
+func TestParseReplace(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected replacement
+		err      bool
+	}{
+		{
+			value:    "github.com/foo/bar/v2=../bar",
+			expected: replacement{oldPath: "github.com/foo/bar/v2", newPath: "../bar"},
+		},
+		{
+			value:    "github.com/foo/bar/v2@v2.0.0=github.com/me/bar/v2@v2.0.1",
+			expected: replacement{oldPath: "github.com/foo/bar/v2", oldVersion: "v2.0.0", newPath: "github.com/me/bar/v2", newVersion: "v2.0.1"},
+		},
+		{value: "github.com/foo/bar/v2", err: true},
+		{value: "github.com/foo/bar/v2=github.com/me/bar/v2", err: true}, // Unversioned module path
+		{value: "github.com/foo/bar/v2@v2.0.0=../bar@v2.0.0", err: true}, // Versioned directory
+		{value: "github.com/foo/bar/v2@v3.0.0=../bar", err: true},        // Mismatched major version
+	}
+	for _, test := range tests {
+		r, err := parseReplace(test.value)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected error parsing %q", test.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.value, err)
+			continue
+		}
+		if r != test.expected {
+			t.Errorf("Expected %q to parse as %+v, got %+v", test.value, test.expected, r)
+		}
+	}
+}
+
+func TestSetGoVersion(t *testing.T) {
+	withLister(t, fakeLister{results: []modupgrade.Module{
+		{Path: "github.com/foo/bar/v2", Version: "v2.0.0", GoVersion: "1.21"},
+	}})
+
+	orig := *goVersion
+	*goVersion = "1.20"
+	t.Cleanup(func() { *goVersion = orig })
+
+	var out bytes.Buffer
+	origStdout := stdout
+	stdout = &out
+	t.Cleanup(func() { stdout = origStdout })
+
+	file, err := modfile.Parse("go.mod", []byte("module example.com/sample\n\ngo 1.18\n\nrequire github.com/foo/bar/v2 v2.0.0\n"), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+
+	setGoVersion(context.Background(), ".", file)
+	if file.Go.Version != "1.20" {
+		t.Errorf("Expected go directive 1.20, got %s", file.Go.Version)
+	}
+	if !strings.Contains(out.String(), "Warning: github.com/foo/bar/v2 requires go 1.21") {
+		t.Errorf("Expected warning about dependency requiring a higher go version, got:\n%s", out.String())
+	}
+}
+
+func TestAddRequirement(t *testing.T) {
+	withLister(t, queryLister{
+		"github.com/foo/bar":        {Path: "github.com/foo/bar", Version: "v1.2.3"},
+		"github.com/foo/baz":        {Path: "github.com/foo/baz", Error: &modupgrade.ModuleError{Err: "module github.com/foo/baz: not a known dependency"}},
+		"github.com/foo/baz@latest": {Path: "github.com/foo/baz", Version: "v1.5.0"},
+	})
+
+	file, err := modfile.Parse("go.mod", []byte("module example.com/sample\n\ngo 1.22\n"), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+
+	// A transitive dependency is required at the version in the module
+	// graph, and any other module at its latest version
+	addRequirement(context.Background(), ".", file, "github.com/foo/bar")
+	addRequirement(context.Background(), ".", file, "github.com/foo/baz")
+
+	for path, expected := range map[string]string{
+		"github.com/foo/bar": "v1.2.3",
+		"github.com/foo/baz": "v1.5.0",
+	} {
+		if version := requiredVersion(file, path); version != expected {
+			t.Errorf("Expected %s to be required at %s, got %q", path, expected, version)
+		}
+	}
+}
+
+func TestFindWorkFile(t *testing.T) {
+	t.Setenv("GOWORK", "")
+
+	root := t.TempDir()
+	moduleDir := filepath.Join(root, "sub", "module")
+	if err := os.MkdirAll(moduleDir, 0755); err != nil {
+		t.Fatalf("Error creating module directory: %s", err)
+	}
+	if workFile := findWorkFile(moduleDir); workFile != "" {
+		t.Errorf("Expected no go.work file, got %s", workFile)
+	}
+
+	workFile := filepath.Join(root, "go.work")
+	if err := ioutil.WriteFile(workFile, []byte("go 1.22\n\nuse ./sub/module\n"), 0644); err != nil {
+		t.Fatalf("Error writing go.work file: %s", err)
+	}
+	if found := findWorkFile(moduleDir); found != workFile {
+		t.Errorf("Expected go.work file %s to be found in parent directory, got %q", workFile, found)
+	}
+
+	t.Setenv("GOWORK", "off")
+	if found := findWorkFile(moduleDir); found != "" {
+		t.Errorf("Expected no go.work file with GOWORK=off, got %s", found)
+	}
+}
+
+func TestVersionCache(t *testing.T) {
+	var queries int
+	withLister(t, listerFunc(func(modulePaths []string) []modupgrade.Module {
+		queries++
+		return queryLister{
+			"github.com/foo/bar":       {Path: "github.com/foo/bar", Version: "v1.0.0"},
+			"github.com/foo/bar/v2@v2": {Path: "github.com/foo/bar/v2", Version: "v2.1.0"},
+		}.results(modulePaths)
+	}))
+
+	origDir := *cacheDir
+	*cacheDir = t.TempDir()
+	t.Cleanup(func() {
+		*cacheDir = origDir
+		versionCache.loaded, versionCache.changed, versionCache.entries = false, false, nil
+	})
+
+	// Each lookup starts with an unloaded cache, as in a new run
+	lookup := func() []string {
+		t.Helper()
+
+		versionCache.loaded, versionCache.entries = false, nil
+		versions, err := newUpgrader(".", nil).LookupVersions(context.Background(), "github.com/foo/bar", "v1.0.0")
+		if err != nil {
+			t.Fatalf("Unexpected error looking up versions: %s", err)
+		}
+		if err := saveVersionCache(); err != nil {
+			t.Fatalf("Unexpected error saving version cache: %s", err)
+		}
+		return versions.Upgrades
+	}
+
+	if versions := lookup(); strings.Join(versions, " ") != "v2.1.0" || queries == 0 {
+		t.Fatalf("Expected versions [v2.1.0] to be looked up, got %v (%d queries)", versions, queries)
+	}
+
+	queries = 0
+	if versions := lookup(); strings.Join(versions, " ") != "v2.1.0" || queries != 0 {
+		t.Errorf("Expected versions [v2.1.0] to be cached, got %v (%d queries)", versions, queries)
+	}
+
+	// Expired versions are looked up again
+	origTTL := *cacheTTL
+	*cacheTTL = 0
+	t.Cleanup(func() { *cacheTTL = origTTL })
+	if lookup(); queries == 0 {
+		t.Errorf("Expected expired versions to be looked up again")
+	}
+}
+
+func TestCompareDependency(t *testing.T) {
+	withLister(t, fakeLister{results: []modupgrade.Module{{
+		Path:    "github.com/foo/bar/v2",
+		Version: "v2.0.0",
+	}}})
+
+	var out bytes.Buffer
+	origStdout := stdout
+	stdout = &out
+	t.Cleanup(func() { stdout = origStdout })
+
+	const goMod = "module example.com/sample\n\ngo 1.22\n\nrequire github.com/foo/bar v1.2.3\n"
+	dir := t.TempDir()
+	modFilePath := filepath.Join(dir, "go.mod")
+	if err := ioutil.WriteFile(modFilePath, []byte(goMod), 0644); err != nil {
+		t.Fatalf("Error writing go.mod file: %s", err)
+	}
+
+	compareDependency(context.Background(), dir, "github.com/foo/bar", "v2")
+
+	if !strings.Contains(out.String(), "--- a/go.mod\n+++ b/go.mod\n") ||
+		!strings.Contains(out.String(), "-require github.com/foo/bar v1.2.3\n+require github.com/foo/bar/v2 v2.0.0\n") {
+		t.Errorf("Expected go.mod diff of the upgrade, got:\n%s", out.String())
+	}
+
+	// The go.mod file itself is left unchanged
+	b, err := ioutil.ReadFile(modFilePath)
+	if err != nil {
+		t.Fatalf("Error reading go.mod file: %s", err)
+	}
+	if string(b) != goMod {
+		t.Errorf("Expected go.mod file to be unchanged, got:\n%s", b)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	moduleDir := filepath.Join(dir, "services", "api")
+	if err := os.MkdirAll(moduleDir, 0755); err != nil {
+		t.Fatalf("Error creating module directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(moduleDir, "go.mod"), []byte("module example.com/api\n"), 0644); err != nil {
+		t.Fatalf("Error writing go.mod file: %s", err)
+	}
+
+	write := func(name, contents string) string {
+		t.Helper()
+
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing manifest file: %s", err)
+		}
+		return filename
+	}
+
+	// The same manifest can be given in either format
+	for _, filename := range []string{
+		write("upgrades.yaml", "dryRun: true\nupgrades:\n  - module: github.com/foo/bar\n    version: v3.2.0\n    dir: services/api\n  - module: github.com/foo/baz\n"),
+		write("upgrades.json", `{"dryRun": true, "upgrades": [{"module": "github.com/foo/bar", "version": "v3.2.0", "dir": "services/api"}, {"module": "github.com/foo/baz"}]}`),
+	} {
+		m, err := readManifest(filename, moduleDir)
+		if err != nil {
+			t.Errorf("Unexpected error reading manifest %s: %s", filename, err)
+			continue
+		}
+		expected := []manifestEntry{
+			{Module: "github.com/foo/bar", Version: "v3.2.0", Dir: moduleDir},
+			{Module: "github.com/foo/baz", Dir: moduleDir},
+		}
+		if !m.DryRun || !reflect.DeepEqual(m.Upgrades, expected) {
+			t.Errorf("Expected dry run manifest with upgrades %+v from %s, got %+v", expected, filename, m)
+		}
+	}
+
+	for name, contents := range map[string]string{
+		"missing-module.yaml":  "upgrades:\n  - version: v2\n",
+		"invalid-version.yaml": "upgrades:\n  - module: github.com/foo/bar\n    version: 2.0\n",
+		"missing-dir.yaml":     "upgrades:\n  - module: github.com/foo/bar\n    dir: services/missing\n",
+		"unknown-field.json":   `{"upgrades": [{"module": "github.com/foo/bar", "path": "github.com/foo/baz"}]}`,
+		"empty.yaml":           "dryRun: true\n",
+		"upgrades.toml":        "",
+	} {
+		if _, err := readManifest(write(name, contents), moduleDir); err == nil {
+			t.Errorf("Expected error reading manifest %s", name)
+		}
+	}
+}
+
+func TestPromptVersion(t *testing.T) {
+	origStdin := stdin
+	stdin = bufio.NewReader(strings.NewReader("3\n1\n"))
+	t.Cleanup(func() { stdin = origStdin })
+
+	var out, prompts bytes.Buffer
+	origStdout, origPromptOutput := stdout, promptOutput
+	stdout, promptOutput = &out, &prompts
+	t.Cleanup(func() { stdout, promptOutput = origStdout, origPromptOutput })
+
+	version, err := promptVersion("github.com/foo/bar", []string{"v2.0.0", "v3.0.0"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if version != "v2.0.0" {
+		t.Errorf("Expected version v2.0.0, got %s", version)
+	}
+
+	// Prompts aren't mixed into the tool's output
+	if !strings.Contains(prompts.String(), "2) v3.0.0 (latest)") || !strings.Contains(prompts.String(), "Invalid choice: 3") {
+		t.Errorf("Expected version menu and invalid choice to be prompted, got:\n%s", prompts.String())
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no output, got:\n%s", out.String())
+	}
+}
+
+func TestPinDependencies(t *testing.T) {
+	const goMod = `module example.com/sample
+
+go 1.22
+
+require (
+	github.com/foo/bar v1.2.3 // keep
+	github.com/foo/baz v2.0.0+incompatible
+	golang.org/x/mod v0.17.0 // indirect
+)
+`
+	tests := []struct {
+		upgradeYAML    bool
+		inlineComments bool
+		expected       string
+	}{
+		// By default, each require line is marked with a comment
+		{expected: "github.com/foo/bar v1.2.3 // keep; pinned: do not upgrade\n\tgithub.com/foo/baz v2.0.0+incompatible // pinned: do not upgrade\n"},
+		{upgradeYAML: true, expected: "github.com/foo/bar v1.2.3 // keep\n\tgithub.com/foo/baz v2.0.0+incompatible\n"},
+		{upgradeYAML: true, inlineComments: true, expected: "github.com/foo/bar v1.2.3 // keep; pinned: do not upgrade\n\tgithub.com/foo/baz v2.0.0+incompatible // pinned: do not upgrade\n"},
+	}
+	t.Cleanup(func() { *upgradeYAML, *inlineComments = false, false })
+	for _, test := range tests {
+		*upgradeYAML, *inlineComments = test.upgradeYAML, test.inlineComments
+
+		file, err := modfile.Parse("go.mod", []byte(goMod), nil)
+		if err != nil {
+			t.Fatalf("Error parsing go.mod file: %s", err)
+		}
+
+		// Only direct dependencies are pinned
+		paths := pinDependencies(file)
+		if !reflect.DeepEqual(paths, []string{"github.com/foo/bar", "github.com/foo/baz"}) {
+			t.Errorf("-upgrade-yaml=%t -inline-comments=%t: expected direct dependencies to be pinned, got %v", test.upgradeYAML, test.inlineComments, paths)
+		}
+		if out := string(formatModFile(file)); !strings.Contains(out, test.expected) {
+			t.Errorf("-upgrade-yaml=%t -inline-comments=%t: expected go.mod to contain:\n%s\ngot:\n%s", test.upgradeYAML, test.inlineComments, test.expected, out)
+		}
+	}
+}
+
+func TestAddIgnoredModules(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, configFileName)
+
+	// The config file is created if necessary
+	if err := addIgnoredModules(dir, []string{"github.com/foo/bar"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if got := readTestFile(t, filePath); got != "ignore-module:\n  - github.com/foo/bar\n" {
+		t.Errorf("Expected new config file with ignore-module list, got:\n%s", got)
+	}
+
+	// Otherwise, the rest of it is kept, and paths already listed (under
+	// either of the flag's names) aren't added again
+	const config = "# Shared settings\ntidy: true\nexclude: golang.org/x/*, github.com/foo/bar\n"
+	if err := ioutil.WriteFile(filePath, []byte(config), 0644); err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	if err := addIgnoredModules(dir, []string{"github.com/foo/bar", "github.com/foo/baz"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	expected := "# Shared settings\ntidy: true\nexclude:\n  - golang.org/x/*\n  - github.com/foo/bar\n  - github.com/foo/baz\n"
+	if got := readTestFile(t, filePath); got != expected {
+		t.Errorf("Expected config file:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestLoadConfigTOML(t *testing.T) {
+	origVerbose, origMaxGap, origFilter := *verbose, *maxGap, filterModules
+	t.Cleanup(func() { *verbose, *maxGap, filterModules = origVerbose, origMaxGap, origFilter })
+	filterModules = nil
+	t.Setenv("HOME", t.TempDir()) // No user config file
+
+	// Single-letter flags can be given their long names
+	dir := t.TempDir()
+	const config = "verbose = true\nmax-gap = 3\nfilter = [\"golang.org/x/*\", \"github.com/foo/bar\"]\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, tomlConfigFileName), []byte(config), 0644); err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	if err := loadConfig(dir); err != nil {
+		t.Fatalf("Unexpected error loading config: %s", err)
+	}
+	if !*verbose || *maxGap != 3 {
+		t.Errorf("Expected -v and -max-gap 3 to be set, got -v=%t -max-gap %d", *verbose, *maxGap)
+	}
+	if got := filterModules.String(); got != "golang.org/x/*,github.com/foo/bar" {
+		t.Errorf("Expected -filter golang.org/x/*,github.com/foo/bar, got %s", got)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, tomlConfigFileName), []byte("verbosity = 2\n"), 0644); err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	if err := loadConfig(dir); err == nil || !strings.Contains(err.Error(), "unknown option") {
+		t.Errorf("Expected unknown option error, got: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
+)
+
+// manifest is a list of upgrades to make in turn, read from the file given
+// with -manifest.
+type manifest struct {
+	// DryRun makes every upgrade in the manifest a dry run (as with -n),
+	// regardless of the command line
+	DryRun   bool            `json:"dryRun" yaml:"dryRun"`
+	Upgrades []manifestEntry `json:"upgrades" yaml:"upgrades"`
+}
+
+// manifestEntry is a single upgrade in a manifest: the dependency to upgrade,
+// the version to upgrade it to (its latest major version, if not given), and
+// the directory of the module to upgrade it in (the -d directory, if not
+// given).
+type manifestEntry struct {
+	Module  string `json:"module" yaml:"module"`
+	Version string `json:"version" yaml:"version"`
+	Dir     string `json:"dir" yaml:"dir"`
+}
+
+// readManifest reads and validates the manifest file, which is parsed as JSON
+// or YAML depending on its extension. Relative module directories are resolved
+// against the directory of the manifest file, so that it can be checked in
+// alongside the modules it upgrades.
+func readManifest(filename, defaultDir string) (*manifest, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading manifest file: %s", err)
+	}
+
+	var m manifest
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(b))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&m)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(b))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&m)
+	default:
+		return nil, fmt.Errorf("unknown manifest file extension %q (must be .json, .yaml or .yml)", filepath.Ext(filename))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing manifest file %s: %s", filename, err)
+	}
+
+	if len(m.Upgrades) == 0 {
+		return nil, fmt.Errorf("no upgrades listed in manifest file %s", filename)
+	}
+	for i := range m.Upgrades {
+		entry := &m.Upgrades[i]
+		if err := entry.validate(filepath.Dir(filename), defaultDir); err != nil {
+			return nil, fmt.Errorf("invalid upgrade %d in manifest file %s: %s", i+1, filename, err)
+		}
+	}
+	return &m, nil
+}
+
+// validate checks the manifest entry, and resolves its module directory.
+func (e *manifestEntry) validate(manifestDir, defaultDir string) error {
+	if e.Module == "" {
+		return fmt.Errorf("no module given")
+	}
+	if err := module.CheckPath(e.Module); err != nil {
+		return err
+	}
+	if e.Version != "" && !semver.IsValid(e.Version) {
+		return fmt.Errorf("invalid version %q for %s (must be a semantic version, e.g. v2 or v2.3.0)", e.Version, e.Module)
+	}
+
+	switch {
+	case e.Dir == "":
+		e.Dir = defaultDir
+	case !filepath.IsAbs(e.Dir):
+		e.Dir = filepath.Join(manifestDir, e.Dir)
+	}
+	if _, err := os.Stat(filepath.Join(e.Dir, "go.mod")); err != nil {
+		return fmt.Errorf("no go.mod file in module directory %s", e.Dir)
+	}
+	return nil
+}
+
+// upgradeManifest makes each upgrade listed in the manifest, in order. As with
+// a single upgrade, the first that fails stops the rest.
+func upgradeManifest(ctx context.Context, m *manifest) {
+	for i, entry := range m.Upgrades {
+		target := entry.Module
+		if entry.Version != "" {
+			target += " " + entry.Version
+		}
+		fmt.Fprintf(stdout, "Upgrade %d/%d: %s in %s\n", i+1, len(m.Upgrades), target, entry.Dir)
+		run(ctx, entry.Dir, entry.Module, entry.Version, false)
+	}
+}
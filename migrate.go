@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// migrateSteps are the commands run, in order, after upgrading a dependency
+// with the "migrate" target.
+var migrateSteps = [][]string{
+	{"go", "mod", "tidy"},
+	{"go", "build", "./..."},
+	{"go", "test", "./..."},
+}
+
+// migrate runs the post-upgrade migration steps in the module directory. If a
+// step fails, the remaining steps are skipped. If stop-on-error mode is on,
+// the upgrade itself is rolled back as well, using the given snapshot.
+func migrate(ctx context.Context, dir string, snap snapshot) error {
+	total := len(migrateSteps) + 1
+	fmt.Fprintf(stdout, "[1/%d] upgrade: ok\n", total)
+
+	for i, step := range migrateSteps {
+		name := strings.Join(step, " ")
+
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, step[0], step[1:]...)
+		cmd.Dir = dir
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(stdout, "[%d/%d] %s: FAILED\n", i+2, total, name)
+			fmt.Fprint(stdout, out.String())
+			for j, skipped := range migrateSteps[i+1:] {
+				fmt.Fprintf(stdout, "[%d/%d] %s: skipped\n", i+j+3, total, strings.Join(skipped, " "))
+			}
+
+			if *stopOnError {
+				if err := snap.restore(); err != nil {
+					return fmt.Errorf("error rolling back upgrade: %s", err)
+				}
+				if err := removeSnapshot(dir); err != nil {
+					return fmt.Errorf("error removing snapshot: %s", err)
+				}
+				fmt.Fprintln(stdout, "Upgrade rolled back")
+			}
+			return fmt.Errorf("error running '%s': %s", name, err)
+		}
+
+		fmt.Fprintf(stdout, "[%d/%d] %s: ok\n", i+2, total, name)
+		if *verbose {
+			fmt.Fprint(stdout, out.String())
+		}
+	}
+	return nil
+}
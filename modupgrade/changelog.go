@@ -0,0 +1,165 @@
+package modupgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+)
+
+// maxReleaseSize caps the size of the release information read from a Git
+// hosting provider's API.
+const maxReleaseSize = 1 << 20
+
+// changelogCache holds the release notes already fetched by an Upgrader, by
+// module path and version.
+type changelogCache struct {
+	sync.Mutex
+	notes map[string]string
+}
+
+// Changelog returns the release notes of the new version of the given module,
+// for showing what changed when proposing an upgrade from the old version. If
+// the module is hosted by a known Git hosting provider (GitHub or GitLab), the
+// body of the new version's release is fetched from the provider's API.
+// Otherwise (or if the new version has no release), a URL where the changes
+// can be looked up is returned instead: the provider's comparison of the two
+// versions if possible, or else the module's page on pkg.go.dev. Release notes
+// are cached, so each module version's release is only fetched once.
+func (u *Upgrader) Changelog(ctx context.Context, modulePath, oldVersion, newVersion string) (string, error) {
+	fallback := fmt.Sprintf("https://pkg.go.dev/%s@%s", modulePath, newVersion)
+
+	host, repo, tagPrefix, ok := splitRepoPath(modulePath)
+	if !ok {
+		return fallback, nil
+	}
+	newTag, oldTag := tagPrefix+newVersion, tagPrefix+oldVersion
+
+	var (
+		apiURL  string
+		compare string
+		field   string // The field of the release holding its notes
+	)
+	switch host {
+	case "github.com":
+		apiURL = fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", repo, url.PathEscape(newTag))
+		compare = fmt.Sprintf("https://github.com/%s/compare/%s...%s", repo, oldTag, newTag)
+		field = "body"
+	case "gitlab.com":
+		apiURL = fmt.Sprintf("https://gitlab.com/api/v4/projects/%s/releases/%s", url.PathEscape(repo), url.PathEscape(newTag))
+		compare = fmt.Sprintf("https://gitlab.com/%s/-/compare/%s...%s", repo, oldTag, newTag)
+		field = "description"
+	}
+
+	notes, err := u.releaseNotes(ctx, modulePath+"@"+newVersion, apiURL, field)
+	if err != nil {
+		return "", fmt.Errorf("error fetching release notes for %s %s: %s", modulePath, newVersion, err)
+	}
+	if notes != "" {
+		return notes, nil
+	}
+	u.verbosef("%s %s has no release notes\n", modulePath, newVersion)
+
+	// The versions can only be compared if the old one is known, and is
+	// tagged in the same repository
+	if oldVersion != "" && !strings.HasSuffix(oldVersion, "+incompatible") && !strings.HasSuffix(newVersion, "+incompatible") {
+		return compare, nil
+	}
+	return fallback, nil
+}
+
+// releaseNotes returns the release notes of the module version with the given
+// key (path@version), fetched with fetchRelease the first time. They're empty
+// if the version has no release, or its release has no notes.
+func (u *Upgrader) releaseNotes(ctx context.Context, key, apiURL, field string) (string, error) {
+	u.changelogs.Lock()
+	notes, ok := u.changelogs.notes[key]
+	u.changelogs.Unlock()
+	if ok {
+		return notes, nil
+	}
+
+	notes, found, err := u.fetchRelease(ctx, apiURL, field)
+	if err != nil {
+		return "", err
+	}
+	if !found || strings.TrimSpace(notes) == "" {
+		notes = ""
+	}
+
+	u.changelogs.Lock()
+	defer u.changelogs.Unlock()
+	if u.changelogs.notes == nil {
+		u.changelogs.notes = map[string]string{}
+	}
+	u.changelogs.notes[key] = notes
+	return notes, nil
+}
+
+// fetchRelease fetches a release from a Git hosting provider's API, and returns
+// the given field of it. It reports whether the release was found.
+func (u *Upgrader) fetchRelease(ctx context.Context, apiURL, field string) (string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := u.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("unexpected response from %s: %s", apiURL, resp.Status)
+	}
+
+	var release map[string]any
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxReleaseSize)).Decode(&release); err != nil {
+		return "", false, fmt.Errorf("error decoding response from %s: %s", apiURL, err)
+	}
+	notes, _ := release[field].(string)
+	return notes, true, nil
+}
+
+// splitRepoPath splits the path of a module hosted by a known Git hosting
+// provider into the provider's host, the repository's path (e.g.
+// "owner/repo"), and the prefix of the module's version tags (e.g. "sub/" for
+// a module in the repository's "sub" directory). It reports whether the host
+// is known. Repositories are assumed to be at the top level of their owner,
+// as they are on GitHub (and usually are on GitLab).
+func splitRepoPath(modulePath string) (host, repo, tagPrefix string, ok bool) {
+	prefix, _, ok := module.SplitPathVersion(modulePath)
+	if !ok {
+		return "", "", "", false
+	}
+
+	elems := strings.Split(prefix, "/")
+	if len(elems) < 3 {
+		return "", "", "", false
+	}
+	switch elems[0] {
+	case "github.com", "gitlab.com":
+	default:
+		return "", "", "", false
+	}
+
+	if len(elems) > 3 {
+		tagPrefix = strings.Join(elems[3:], "/") + "/"
+	}
+	return elems[0], elems[1] + "/" + elems[2], tagPrefix, true
+}
@@ -0,0 +1,108 @@
+package modupgrade
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// redirectTransport sends every request to the given server, whatever its
+// URL, so that Git hosting providers' APIs can be faked.
+type redirectTransport struct {
+	server *httptest.Server
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(t.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestChangelog(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.EscapedPath())
+		switch r.URL.EscapedPath() {
+		case "/repos/foo/bar/releases/tags/v2.1.0":
+			w.Write([]byte(`{"tag_name": "v2.1.0", "body": "Added Baz"}`))
+		case "/repos/foo/bar/releases/tags/sub%2Fv1.2.0":
+			w.Write([]byte(`{"tag_name": "sub/v1.2.0", "body": "Fixed Qux"}`))
+		case "/api/v4/projects/foo%2Fbar/releases/v3.0.0":
+			w.Write([]byte(`{"tag_name": "v3.0.0", "description": "Removed Baz"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	u := New(".", WithHTTPClient(&http.Client{Transport: redirectTransport{server}}))
+
+	tests := []struct {
+		path       string
+		oldVersion string
+		newVersion string
+		expected   string
+	}{
+		{path: "github.com/foo/bar/v2", oldVersion: "v1.5.0", newVersion: "v2.1.0", expected: "Added Baz"},
+		{path: "github.com/foo/bar/sub", oldVersion: "v1.1.0", newVersion: "v1.2.0", expected: "Fixed Qux"},
+		{path: "gitlab.com/foo/bar/v3", oldVersion: "v2.0.0", newVersion: "v3.0.0", expected: "Removed Baz"},
+
+		// Without a release, the versions are compared instead
+		{path: "github.com/foo/bar/v3", oldVersion: "v2.1.0", newVersion: "v3.0.0", expected: "https://github.com/foo/bar/compare/v2.1.0...v3.0.0"},
+		{path: "github.com/foo/bar/v3", newVersion: "v3.0.1", expected: "https://pkg.go.dev/github.com/foo/bar/v3@v3.0.1"},
+
+		// Other hosts aren't queried at all
+		{path: "example.com/foo/bar/v2", oldVersion: "v1.0.0", newVersion: "v2.0.0", expected: "https://pkg.go.dev/example.com/foo/bar/v2@v2.0.0"},
+		{path: "gopkg.in/yaml.v3", oldVersion: "v2.4.0", newVersion: "v3.0.1", expected: "https://pkg.go.dev/gopkg.in/yaml.v3@v3.0.1"},
+	}
+	for _, test := range tests {
+		notes, err := u.Changelog(context.Background(), test.path, test.oldVersion, test.newVersion)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %s", test.path, test.newVersion, err)
+			continue
+		}
+		if notes != test.expected {
+			t.Errorf("%s %s: expected %q, got %q", test.path, test.newVersion, test.expected, notes)
+		}
+	}
+	if len(requests) != 5 {
+		t.Errorf("Expected 5 requests, got %d: %v", len(requests), requests)
+	}
+
+	// Release notes are only fetched once
+	requests = nil
+	if notes, err := u.Changelog(context.Background(), "github.com/foo/bar/v2", "v1.5.0", "v2.1.0"); err != nil || notes != "Added Baz" {
+		t.Errorf("Expected cached release notes, got %q (error: %v)", notes, err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected cached release notes not to be fetched again, got requests: %v", requests)
+	}
+
+	// Only the release notes are cached, so comparisons are made with the
+	// old version given
+	expected := "https://github.com/foo/bar/compare/v2.5.0...v3.0.0"
+	if notes, err := u.Changelog(context.Background(), "github.com/foo/bar/v3", "v2.5.0", "v3.0.0"); err != nil || notes != expected {
+		t.Errorf("Expected %q, got %q (error: %v)", expected, notes, err)
+	}
+	if len(requests) != 0 {
+		t.Errorf("Expected missing release notes not to be fetched again, got requests: %v", requests)
+	}
+}
+
+func TestChangelogError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limit exceeded", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	u := New(".", WithHTTPClient(&http.Client{Transport: redirectTransport{server}}))
+	if _, err := u.Changelog(context.Background(), "github.com/foo/bar/v2", "v1.5.0", "v2.1.0"); err == nil {
+		t.Errorf("Expected error fetching release notes")
+	}
+}
@@ -0,0 +1,90 @@
+package modupgrade
+
+import (
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// TakeRequireComments removes the comments from the require line for the given
+// module in the go.mod file (e.g. explaining why the dependency is needed), and
+// returns them, so that they can be moved to the line that replaces it with
+// SetRequireComments. Otherwise, dropping the requirement would drop its suffix
+// comment, but leave the comments above it orphaned.
+func TakeRequireComments(file *modfile.File, path string) modfile.Comments {
+	for _, require := range file.Require {
+		if require.Mod.Path == path && require.Syntax != nil {
+			comments := require.Syntax.Comments
+			require.Syntax.Comments = modfile.Comments{}
+			return comments
+		}
+	}
+	return modfile.Comments{}
+}
+
+// SetRequireComments attaches the comments returned by TakeRequireComments to
+// the require line for the given module. The "// indirect" marker is not
+// copied: whether the new requirement is indirect is up to the line itself.
+func SetRequireComments(file *modfile.File, path string, comments modfile.Comments) {
+	var line *modfile.Line
+	for _, require := range file.Require {
+		if require.Mod.Path == path {
+			line = require.Syntax
+		}
+	}
+	if line == nil {
+		return
+	}
+
+	line.Before = append(line.Before, comments.Before...)
+	line.After = append(line.After, comments.After...)
+
+	for _, com := range comments.Suffix {
+		text := withoutIndirect(com.Token)
+		if text == "" {
+			continue
+		}
+		if len(line.Suffix) == 0 {
+			line.Suffix = []modfile.Comment{{Token: "// " + text, Suffix: true}}
+			continue
+		}
+		// The "indirect" marker must begin the first suffix comment, so
+		// the comments are merged into it, after the marker (if any)
+		suffix := &line.Suffix[0]
+		if withoutIndirect(suffix.Token) == "" {
+			suffix.Token = "// indirect; " + text
+		} else {
+			suffix.Token += "; " + text
+		}
+	}
+}
+
+// withoutIndirect returns the text of a require line's suffix comment, without
+// the "// " prefix, or the "indirect" marker (if it has one).
+func withoutIndirect(token string) string {
+	text := strings.TrimSpace(strings.TrimPrefix(token, "//"))
+	if text == "indirect" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(text, "indirect;"); ok {
+		return strings.TrimSpace(rest)
+	}
+	return text
+}
+
+// PinnedComment marks a require line as pinned, when found in its comments:
+// UpgradeAllDependencies leaves pinned dependencies as they are.
+const PinnedComment = "pinned: do not upgrade"
+
+// IsPinned reports whether the require line has been marked as pinned.
+func IsPinned(require *modfile.Require) bool {
+	if require.Syntax == nil {
+		return false
+	}
+	for _, com := range require.Syntax.Suffix {
+		if strings.Contains(com.Token, PinnedComment) {
+			return true
+		}
+	}
+	return false
+}
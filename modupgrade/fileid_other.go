@@ -0,0 +1,15 @@
+//go:build !unix
+
+package modupgrade
+
+import "os"
+
+// fileID uniquely identifies a file on disk. On platforms where device and
+// inode numbers aren't available, files are identified by name.
+type fileID struct {
+	name string
+}
+
+func getFileID(name string, info os.FileInfo) fileID {
+	return fileID{name: name}
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package modupgrade
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID uniquely identifies a file on disk, regardless of the path used to
+// access it.
+type fileID struct {
+	dev, ino uint64
+	name     string // Only used if the device and inode are unavailable
+}
+
+func getFileID(name string, info os.FileInfo) fileID {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{name: name}
+	}
+	return fileID{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+}
@@ -0,0 +1,80 @@
+package modupgrade
+
+import (
+	"go/ast"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+const generatePrefix = "//go:generate "
+
+// RewriteGenerateDirectives rewrites the module paths affected by the given
+// upgrades in the file's //go:generate directives (e.g. "//go:generate go run
+// github.com/foo/bar/cmd/gen"), and returns the rewrites made. Any version
+// given for an upgraded path (e.g. "github.com/foo/bar/cmd/gen@v1.2.3") is
+// updated to the new version, unless it is a query like "latest".
+func RewriteGenerateDirectives(fileAST *ast.File, upgrades []Upgrade) []Rewrite {
+	var rewrites []Rewrite
+	for _, group := range fileAST.Comments {
+		for _, comment := range group.List {
+			if !strings.HasPrefix(comment.Text, generatePrefix) {
+				continue
+			}
+
+			for _, word := range strings.Fields(strings.TrimPrefix(comment.Text, generatePrefix)) {
+				oldWord := strings.Trim(word, "\"'`")
+				path, version, _ := strings.Cut(oldWord, "@")
+
+				// The most specific upgraded path applies (e.g. that of a
+				// remapped package, rather than the module containing it)
+				var (
+					u     Upgrade
+					found bool
+				)
+				for _, upgrade := range upgrades {
+					if upgrade.OldPath != upgrade.NewPath && BelongsToModule(path, upgrade.OldPath) &&
+						(!found || len(upgrade.OldPath) > len(u.OldPath)) {
+						u, found = upgrade, true
+					}
+				}
+				if !found {
+					continue
+				}
+
+				newWord := u.NewPath + strings.TrimPrefix(path, u.OldPath)
+				if version != "" {
+					if strings.HasPrefix(version, "v") && u.NewVersion != "" {
+						version = u.NewVersion
+					}
+					newWord += "@" + version
+				}
+
+				comment.Text = strings.Replace(comment.Text, oldWord, newWord, 1)
+				rewrites = append(rewrites, Rewrite{
+					ModulePath:    u.OldPath,
+					OldImportPath: oldWord,
+					NewImportPath: newWord,
+				})
+			}
+		}
+	}
+	return rewrites
+}
+
+// BelongsToModule reports whether the import path is the path of a package in
+// the given module, based on the paths alone. Import paths that continue with
+// a major version suffix (e.g. dep/v3/pkg, for module dep) belong to a
+// different major version of the module, so don't count.
+func BelongsToModule(importPath, modulePath string) bool {
+	if importPath == modulePath {
+		return true
+	}
+	if !strings.HasPrefix(importPath, modulePath+"/") {
+		return false
+	}
+
+	elem, _, _ := strings.Cut(strings.TrimPrefix(importPath, modulePath+"/"), "/")
+	_, pathMajor, ok := module.SplitPathVersion(modulePath + "/" + elem)
+	return !ok || pathMajor == ""
+}
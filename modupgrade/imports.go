@@ -0,0 +1,480 @@
+package modupgrade
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/tools/go/packages"
+)
+
+// Rewrite describes a single import path (or module path in a //go:generate
+// directive) rewritten in a file.
+type Rewrite struct {
+	ModulePath    string // Path of the upgraded module the import belongs to
+	OldImportPath string
+	NewImportPath string
+}
+
+// File is a .go file whose imports were rewritten. It is only modified in
+// memory, until written with Write.
+type File struct {
+	Name     string
+	AST      *ast.File
+	Fset     *token.FileSet
+	Rewrites []Rewrite
+}
+
+// Write formats the file and writes it to disk.
+func (f File) Write() error {
+	// Format the file in memory first, so that the original file isn't
+	// truncated (or partially written) if formatting fails
+	out, err := f.Format()
+	if err != nil {
+		return err
+	}
+
+	if err := WriteFile(f.Name, out); err != nil {
+		return fmt.Errorf("error writing file %s: %s", f.Name, err)
+	}
+	return nil
+}
+
+// Format returns the formatted contents of the rewritten file.
+func (f File) Format() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := format.Node(&buf, f.Fset, f.AST); err != nil {
+		return nil, fmt.Errorf("error formatting file %s: %s", f.Name, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteFile replaces the contents of the named file by writing them to a
+// temporary file in the same directory, then renaming it over the original.
+// The rename is atomic, so the original is never left truncated or partially
+// written (e.g. if the process is killed part way through). The file's
+// permissions are preserved.
+func WriteFile(filename string, data []byte) error {
+	perm := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+".upgrade-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// FileErrors are the errors rewriting the imports of individual files, which
+// are skipped with WithContinueOnError.
+type FileErrors []error
+
+func (e FileErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d file(s) skipped:\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
+
+// RewriteImports rewrites the import paths (and the module paths in
+// //go:generate directives) affected by the given upgrades in the module's .go
+// files, including test files. The files are only modified in memory: the
+// returned files must be written to disk with File.Write. With
+// WithContinueOnError, files whose imports can't be rewritten are skipped, and
+// the returned FileErrors (alongside the files that were rewritten) lists them.
+func (u *Upgrader) RewriteImports(ctx context.Context, upgrades []Upgrade) ([]File, error) {
+	// Paths can be the same in case of minor version update, in which case
+	// there's nothing to rewrite
+	pathChanged := false
+	for _, upgrade := range upgrades {
+		if upgrade.NewPath != upgrade.OldPath {
+			pathChanged = true
+		}
+	}
+	if !pathChanged {
+		return nil, nil
+	}
+
+	absDir, err := filepath.Abs(u.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error getting absolute path of module directory: %s", err)
+	}
+
+	pkgs := u.pkgs
+	if pkgs == nil {
+		pkgs, err = packages.Load(&packages.Config{
+			Context: ctx,
+			Dir:     absDir,
+			Mode: packages.NeedName |
+				packages.NeedCompiledGoFiles |
+				packages.NeedImports |
+				packages.NeedDeps |
+				packages.NeedTypes |
+				packages.NeedSyntax |
+				packages.NeedModule,
+			Tests:     true, // Necessary to rewrite imports in _test.go files
+			ParseFile: ParseFile(u.maxFileSize),
+		}, "./...")
+		if err != nil {
+			return nil, fmt.Errorf("error loading package info: %s", err)
+		}
+	}
+
+	var (
+		modified     []File
+		filesVisited = map[fileID]bool{}
+		skipped      FileErrors
+	)
+	for _, pkg := range pkgs {
+		u.verbosef("Package: %s\n", pkg.PkgPath)
+
+		// Skip the package if its go.mod file isn't located within the module
+		// directory (e.g. if it was loaded from a module cache in a temp dir)
+		if pkg.Module != nil && pkg.Module.GoMod != "" &&
+			!strings.HasPrefix(pkg.Module.GoMod, absDir+string(filepath.Separator)) {
+			u.verbosef("Skipping package %s: go.mod file %s is outside of module directory\n",
+				pkg.PkgPath, pkg.Module.GoMod,
+			)
+			continue
+		}
+
+		// Packages with errors (e.g. a missing dependency, or a syntax error)
+		// can be missing information needed to rewrite their imports, so
+		// skip them, rather than failing to upgrade the rest of the module
+		if pkgErrs := u.packageErrors(pkg); len(pkgErrs) > 0 {
+			u.printf("Warning: skipping package %s, which has errors (its imports won't be rewritten)\n", pkg.PkgPath)
+			for _, pkgErr := range pkgErrs {
+				u.verbosef("\t%s\n", pkgErr)
+			}
+			continue
+		}
+
+		if len(pkg.Syntax) != len(pkg.CompiledGoFiles) {
+			u.verbosef("Package %s: %d compiled files, but only %d parsed\n",
+				pkg.PkgPath, len(pkg.CompiledGoFiles), len(pkg.Syntax),
+			)
+		}
+		for _, fileAST := range pkg.Syntax {
+			// Syntax and CompiledGoFiles are not guaranteed to line up (not
+			// all compiled files are necessarily parsed), so get the filename
+			// from the file set, rather than indexing into CompiledGoFiles
+			if fileAST == nil {
+				continue
+			}
+			tokFile := pkg.Fset.File(fileAST.Pos())
+			if tokFile == nil {
+				continue
+			}
+			filename := tokFile.Name()
+			fset := pkg.Fset
+
+			// Files that use cgo are preprocessed before being parsed, so
+			// the parsed file is a generated file in the build cache. The
+			// generated file refers back to the original via a //line
+			// directive, so parse the original file instead.
+			if !strings.HasPrefix(filename, absDir) {
+				original := pkg.Fset.Position(fileAST.Package).Filename
+				if original != filename && strings.HasPrefix(original, absDir) {
+					fset = token.NewFileSet()
+					fileAST, err = parser.ParseFile(fset, original, nil, parser.ParseComments)
+					if err != nil {
+						err = fmt.Errorf("error parsing file %s: %s", original, err)
+						if !u.continueOnError {
+							return nil, err
+						}
+						skipped = append(skipped, err)
+						continue
+					}
+					filename = original
+				}
+			}
+
+			// Skip the file if it isn't located within the module directory.
+			// This is particularly important for preventing changes to "test
+			// binary" files, which are typically located in the user's
+			// $HOME/.cache/go-build/ directory, and should not be modified
+			// (but are returned when loading test packages).
+			// NOTE: This feels a little hacky, but I could not find a more
+			// reliable way to identify the test binary package or ignore its
+			// files. See: https://github.com/nathanjcochran/upgrade/issues/2.
+			if !strings.HasPrefix(filename, absDir) {
+				continue
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				return nil, fmt.Errorf("error getting file info for %s: %s", filename, err)
+			}
+
+			// Skip the file if we've already visited it (including test
+			// packages means some files can appear more than once). Files
+			// are identified by device and inode, rather than by name, since
+			// the same file can be reachable via different paths (e.g. bind
+			// mounts in containerized builds).
+			id := getFileID(filename, info)
+			if filesVisited[id] {
+				continue
+			}
+			filesVisited[id] = true
+
+			// Skip the file if it exceeds the maximum file size (typically
+			// huge generated files, which are unlikely to need rewriting)
+			if u.maxFileSize > 0 && info.Size() > u.maxFileSize {
+				u.printf("Skipping %s: size %s exceeds the maximum file size (-max-file-size %s)\n",
+					filename, formatSize(info.Size()), formatSize(u.maxFileSize),
+				)
+				continue
+			}
+
+			// Rewrite the imports of the upgraded modules
+			rewrites, err := RewriteFile(pkg, fileAST, upgrades)
+			if err != nil {
+				err = fmt.Errorf("%s: %s", filename, err)
+				if !u.continueOnError {
+					return nil, err
+				}
+				skipped = append(skipped, err)
+				continue
+			}
+
+			// Module paths in //go:generate directives (e.g. of tools run with
+			// 'go run') need to be rewritten just like imports
+			if !u.skipGoGenerate {
+				rewrites = append(rewrites, RewriteGenerateDirectives(fileAST, upgrades)...)
+			}
+			if len(rewrites) == 0 {
+				continue
+			}
+
+			if u.verbose {
+				u.printf("%s:\n", filename)
+				for _, rewrite := range rewrites {
+					u.printf("\t%s -> %s\n", rewrite.OldImportPath, rewrite.NewImportPath)
+				}
+			}
+			modified = append(modified, File{
+				Name:     filename,
+				AST:      fileAST,
+				Fset:     fset,
+				Rewrites: rewrites,
+			})
+		}
+	}
+
+	if len(skipped) > 0 {
+		return modified, skipped
+	}
+	return modified, nil
+}
+
+// ParseFile returns a function that parses .go files for packages.Config, much
+// as the default one does, except that files larger than maxFileSize bytes (if
+// positive) are only parsed as far as their imports. RewriteImports skips such
+// files (see WithMaxFileSize), so there's no point paying to parse them (or to
+// type-check them). Their sizes are checked before they're parsed.
+func ParseFile(maxFileSize int64) func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+	return func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+		mode := parser.AllErrors | parser.ParseComments
+		if exceedsMaxFileSize(filename, maxFileSize) {
+			mode = parser.ImportsOnly
+		}
+		return parser.ParseFile(fset, filename, src, mode)
+	}
+}
+
+// exceedsMaxFileSize reports whether the named file is larger than the given
+// maximum file size (if positive).
+func exceedsMaxFileSize(filename string, maxFileSize int64) bool {
+	if maxFileSize <= 0 {
+		return false
+	}
+	info, err := os.Stat(filename)
+	return err == nil && info.Size() > maxFileSize
+}
+
+// packageErrors returns the errors of the given package that prevent its
+// imports from being rewritten. Files exceeding the maximum file size are only
+// parsed as far as their imports (see ParseFile), so the rest of their package
+// is type-checked without their declarations, and type errors are expected.
+func (u *Upgrader) packageErrors(pkg *packages.Package) []packages.Error {
+	oversized := false
+	for _, filename := range pkg.CompiledGoFiles {
+		if exceedsMaxFileSize(filename, u.maxFileSize) {
+			oversized = true
+		}
+	}
+	if !oversized {
+		return pkg.Errors
+	}
+
+	var errs []packages.Error
+	for _, pkgErr := range pkg.Errors {
+		if pkgErr.Kind != packages.TypeError {
+			errs = append(errs, pkgErr)
+		}
+	}
+	return errs
+}
+
+// formatSize formats a file size in bytes for display (e.g. "3MB").
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%d%cB", size/div, "KMGTPE"[exp])
+}
+
+// RewriteFile rewrites the import paths affected by the given upgrades in the
+// given file of the package (in memory), and returns the rewrites made.
+func RewriteFile(pkg *packages.Package, fileAST *ast.File, upgrades []Upgrade) ([]Rewrite, error) {
+	upgradeMap := map[string]string{}
+	for _, upgrade := range upgrades {
+		// Paths can be the same in case of minor version update
+		if upgrade.NewPath != upgrade.OldPath {
+			upgradeMap[upgrade.OldPath] = upgrade.NewPath
+		}
+	}
+
+	var rewrites []Rewrite
+	for _, fileImp := range fileAST.Imports {
+		importPath := strings.Trim(fileImp.Path.Value, "\"")
+
+		// The "C" pseudo-package used by cgo doesn't correspond
+		// to a real package, and never needs to be rewritten
+		if importPath == "C" {
+			continue
+		}
+
+		// We have to actually compare module paths, not just import
+		// path prefixes. Imagine upgrading dep to dep/v5, but dep/v3
+		// is also installed. If we only looked at import paths, we'd
+		// be liable to get dep/v5/v3, which is invalid.
+		impPkg, exists := pkg.Imports[importPath]
+		if !exists {
+			return nil, fmt.Errorf("error getting package information for import %s", importPath)
+		}
+
+		// NOTE: Some imports, such as standard library packages, do
+		// not have a corresponding module. In these case, we default
+		// to the package name as it was specified in the import
+		// statement (it won't be updated).
+		modulePath := importPath
+		if impPkg.Module != nil {
+			modulePath = impPkg.Module.Path
+		}
+
+		// An upgrade of a path within the module (i.e. a remapped package)
+		// takes precedence over an upgrade of the module as a whole
+		for oldPath := range upgradeMap {
+			if len(oldPath) > len(modulePath) && BelongsToModule(importPath, oldPath) {
+				modulePath = oldPath
+			}
+		}
+
+		newPath, ok := upgradeMap[modulePath]
+		if !ok {
+			continue
+		}
+
+		newImportPath := strings.Replace(importPath, modulePath, newPath, 1)
+		if err := module.CheckImportPath(newImportPath); err != nil {
+			return nil, fmt.Errorf("invalid import path after upgrade: %s", newImportPath)
+		}
+		fileImp.Path.Value = fmt.Sprintf("\"%s\"", newImportPath)
+		if fileImp.Name != nil {
+			renameImport(pkg, fileAST, fileImp, importPath, newImportPath)
+		}
+		rewrites = append(rewrites, Rewrite{
+			ModulePath:    modulePath,
+			OldImportPath: importPath,
+			NewImportPath: newImportPath,
+		})
+	}
+	return rewrites, nil
+}
+
+// renameImport updates the name of a named import whose path was rewritten,
+// if the name is just the one the old path would conventionally be imported
+// as (e.g. bar "github.com/foo/bar"), but the new path would be imported as a
+// different one (e.g. if a package was remapped to github.com/foo/baz). The
+// import is renamed to match the new path (e.g. baz "github.com/foo/baz"), along
+// with its uses in the file, so that the code reads as it did. Names that were
+// chosen for another reason are kept, as is the name if the new one would
+// clash with another name in the file (or in the package).
+func renameImport(pkg *packages.Package, fileAST *ast.File, imp *ast.ImportSpec, oldImportPath, newImportPath string) {
+	oldName := imp.Name.Name
+	if oldName != importName(oldImportPath) {
+		return
+	}
+	newName := importName(newImportPath)
+	if newName == oldName || !token.IsIdentifier(newName) {
+		return
+	}
+
+	if pkg.Types != nil && pkg.Types.Scope().Lookup(newName) != nil {
+		return
+	}
+	inUse := false
+	ast.Inspect(fileAST, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && id.Name == newName {
+			inUse = true
+		}
+		return !inUse
+	})
+	if inUse {
+		return
+	}
+
+	// References to the import are unresolved by the parser (unlike those
+	// to local declarations that shadow it)
+	ast.Inspect(fileAST, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == oldName && id.Obj == nil {
+				id.Name = newName
+			}
+		}
+		return true
+	})
+	imp.Name.Name = newName
+}
+
+// importName returns the name a package is conventionally imported as, given
+// its import path: its last element, ignoring any major version suffix (e.g.
+// "bar" for both github.com/foo/bar and github.com/foo/bar/v2).
+func importName(importPath string) string {
+	if prefix, _, ok := module.SplitPathVersion(importPath); ok {
+		importPath = prefix
+	}
+	return path.Base(importPath)
+}
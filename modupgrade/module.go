@@ -0,0 +1,99 @@
+package modupgrade
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Module describes a module, as output by the 'go list -m -json' command.
+// From "go help list" output.
+type Module struct {
+	Path       string       // module path
+	Query      string       // version query corresponding to this version
+	Version    string       // module version
+	Versions   []string     // available module versions
+	Replace    *Module      // replaced by this module
+	Time       *time.Time   // time version was created
+	Update     *Module      // available update (with -u)
+	Main       bool         // is this the main module?
+	Indirect   bool         // module is only indirectly needed by main module
+	Dir        string       // directory holding local copy of files, if any
+	GoMod      string       // path to go.mod file describing module, if any
+	GoVersion  string       // go version used in module
+	Retracted  []string     // retraction information, if any (with -retracted or -u)
+	Deprecated string       // deprecation message, if any (with -u)
+	Error      *ModuleError // error loading module
+	Origin     any          // provenance of module
+	Reuse      bool         // reuse of old module info is safe
+}
+
+type ModuleError struct {
+	Err string // the error itself
+}
+
+// Lister queries information about modules. Module versions are queried
+// relative to the module in the given directory, and any extra flags are
+// passed to the underlying 'go list -m' command. Errors looking up individual
+// modules are reported in the results, rather than as an error.
+type Lister interface {
+	ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]Module, error)
+}
+
+// GoLister is a Lister that queries module information using the
+// 'go list -m' command.
+type GoLister struct {
+	ModFlag string   // -mod flag to pass to the go command (default -mod=readonly)
+	Env     []string // Environment of the go command (nil to inherit it)
+}
+
+// ListError is returned by GoLister when the 'go list' command fails.
+type ListError struct {
+	Cmd    string // The command that failed
+	Stderr string // The command's standard error output
+	Err    error
+}
+
+func (e *ListError) Error() string {
+	return fmt.Sprintf("error executing '%s' command: %s", e.Cmd, e.Err)
+}
+
+func (e *ListError) Unwrap() error {
+	return e.Err
+}
+
+func (l GoLister) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]Module, error) {
+	modFlag := l.ModFlag
+	if modFlag == "" {
+		modFlag = "-mod=readonly"
+	}
+	args := append([]string{"list", "-m", "-u", "-e", "-json", modFlag}, extraFlags...)
+	cmdStr := "go " + strings.Join(args, " ")
+
+	cmd := exec.CommandContext(ctx, "go", append(args, modulePaths...)...)
+	cmd.Dir = dir // Versions are queried relative to the module being upgraded
+	cmd.Env = l.Env
+	out, err := cmd.Output()
+	if err != nil {
+		var stderr string
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			stderr = string(exitErr.Stderr)
+		}
+		return nil, &ListError{Cmd: cmdStr, Stderr: stderr, Err: err}
+	}
+
+	var results []Module
+	decoder := json.NewDecoder(bytes.NewReader(out))
+	for decoder.More() {
+		var result Module
+		if err := decoder.Decode(&result); err != nil {
+			return nil, fmt.Errorf("error parsing results of '%s' command: %s", cmdStr, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,839 @@
+package modupgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// ErrDowngrade is returned (wrapped) when asked to move the module itself to a
+// lower major version without WithDowngrade.
+var ErrDowngrade = errors.New("downgrade not allowed")
+
+// ErrNoUpgrade is returned (wrapped) when asked to upgrade a dependency to the
+// highest available major version (or to a given version within its current
+// major version), but it is already at that version.
+var ErrNoUpgrade = errors.New("no versions available for upgrade")
+
+// ErrSkipped is returned (wrapped) by UpgradeDependency when no version is
+// picked with WithPickVersion.
+var ErrSkipped = errors.New("upgrade skipped")
+
+// ErrRetracted is returned (wrapped), with WithStrict, when a dependency
+// would be upgraded to a retracted version.
+var ErrRetracted = errors.New("version is retracted")
+
+// Upgrade describes the upgrade of a single module, from its old path and
+// version to its new ones. The paths are the same in the case of a minor
+// version update. The versions are empty when upgrading the module itself.
+type Upgrade struct {
+	OldPath    string
+	OldVersion string
+	NewPath    string
+	NewVersion string
+
+	// Deprecated and Retracted are the deprecation message of the new
+	// module path, and the retraction rationale of the new version, if
+	// they're known to be deprecated or retracted
+	Deprecated string
+	Retracted  string
+}
+
+func (u Upgrade) String() string {
+	if u.OldVersion == "" && u.NewVersion == "" {
+		return fmt.Sprintf("%s -> %s", u.OldPath, u.NewPath)
+	}
+	return fmt.Sprintf("%s %s -> %s %s", u.OldPath, u.OldVersion, u.NewPath, u.NewVersion)
+}
+
+// UpgradeModule upgrades the path of the module itself to the major version of
+// the given version (or to the next major version, if none is given), in the
+// go.mod file. Moving to a lower major version must be allowed with
+// WithDowngrade.
+func (u *Upgrader) UpgradeModule(version string) (Upgrade, error) {
+	file, err := u.ModFile()
+	if err != nil {
+		return Upgrade{}, err
+	}
+	path := file.Module.Mod.Path
+
+	if version != "" {
+		if !semver.IsValid(version) {
+			return Upgrade{}, fmt.Errorf("invalid upgrade version: %s", version)
+		}
+
+		// Truncate the minor/patch versions
+		version = semver.Major(version)
+
+		// Moving to a lower major version must be asked for explicitly
+		current, target := pathMajorNumber(path), versionMajorNumber(version)
+		if target == current {
+			return Upgrade{}, fmt.Errorf("module %s is already at major version %s", path, version)
+		}
+		if target < current && !u.downgrade {
+			return Upgrade{}, fmt.Errorf("version %s is lower than the current major version of module %s: %w", version, path, ErrDowngrade)
+		}
+	}
+
+	// Figure out what the post-upgrade module path should be
+	// (if version is empty, simply increment the version number)
+	newPath, err := UpgradePath(path, version)
+	if err != nil {
+		return Upgrade{}, fmt.Errorf("error upgrading module path %s to %s: %s", path, version, err)
+	}
+
+	if err := file.AddModuleStmt(newPath); err != nil {
+		return Upgrade{}, fmt.Errorf("error upgrading module to %s: %s", newPath, err)
+	}
+
+	return Upgrade{OldPath: path, NewPath: newPath}, nil
+}
+
+// UpgradeDependency upgrades the given dependency to the given (possibly
+// partial) version in the go.mod file, or to the latest version of its highest
+// available major version (or to the one picked with WithPickVersion), if none
+// is given. If the new major version of the dependency is already required,
+// its version is kept if it matches the given version. The paths of the
+// returned upgrade are the same if no higher major version is available. A
+// requirement on the module itself is upgraded with UpgradeModule instead.
+func (u *Upgrader) UpgradeDependency(ctx context.Context, path, version string) (Upgrade, error) {
+	file, err := u.ModFile()
+	if err != nil {
+		return Upgrade{}, err
+	}
+
+	// Some go.mod files (e.g. in workspaces) require the module itself. That
+	// requirement must not be upgraded as though it were a dependency, since
+	// that would search the proxy for other major versions of this module.
+	if path == file.Module.Mod.Path {
+		return u.UpgradeModule(version)
+	}
+
+	// Validate and parse the module path
+	if err := module.CheckPath(path); err != nil {
+		return Upgrade{}, fmt.Errorf("invalid module path %s: %s", path, err)
+	}
+
+	// Make sure the given module is actually a dependency in the go.mod
+	// file, before querying its versions
+	if err := CheckDependency(file, path); err != nil {
+		return Upgrade{}, err
+	}
+
+	var (
+		newPath     string
+		fullVersion string
+		deprecated  string
+		retracted   string
+	)
+	switch version {
+	case "":
+		// If no target major version was given, call 'go list -m'
+		// to find the highest available major version
+		lookup, err := u.LookupVersions(ctx, path, requiredVersion(file, path))
+		if err != nil {
+			return Upgrade{}, fmt.Errorf("error finding upgrade version: %s", err)
+		}
+		versions := lookup.Upgrades
+
+		// Higher +incompatible versions can be picked along with the
+		// module-aware ones
+		if u.pick != nil && lookup.Incompatible != "" {
+			versions = append([]string{lookup.Incompatible}, versions...)
+		}
+		if len(versions) == 0 {
+			return Upgrade{}, fmt.Errorf("%s: %w", path, ErrNoUpgrade)
+		}
+
+		fullVersion = LatestVersion(versions)
+		if u.pick != nil {
+			fullVersion, err = u.pick(path, versions)
+			if err != nil {
+				return Upgrade{}, fmt.Errorf("error picking upgrade version: %s", err)
+			}
+			if fullVersion == "" {
+				return Upgrade{}, fmt.Errorf("%s: %w", path, ErrSkipped)
+			}
+		}
+		deprecated, retracted = lookup.Deprecated[fullVersion], lookup.Retracted[fullVersion]
+
+		// Figure out what the post-upgrade module path should be
+		newPath, err = UpgradePath(path, fullVersion)
+		if err != nil {
+			return Upgrade{}, fmt.Errorf("error upgrading module path %s to %s: %s", path, fullVersion, err)
+		}
+	default:
+		// If a target version was given, make sure it's valid, then call
+		// 'go list -m' to get the full version and path (which depends on
+		// whether the version is incompatible or not)
+		if !semver.IsValid(version) {
+			return Upgrade{}, fmt.Errorf("invalid upgrade version: %s", version)
+		}
+
+		result, err := u.resolveModule(ctx, path, version)
+		if err != nil {
+			return Upgrade{}, fmt.Errorf("error getting upgrade path and version: %s", err)
+		}
+		newPath, fullVersion = result.Path, result.Version
+		deprecated, retracted = result.Deprecated, strings.Join(result.Retracted, "; ")
+	}
+
+	var (
+		oldVersion        = ""
+		alreadyExists     = false
+		removePreexisting = false
+	)
+	for _, require := range file.Require {
+		switch require.Mod.Path {
+		case path:
+			oldVersion = require.Mod.Version
+		case newPath:
+			if versionMatches(require.Mod.Version, version) {
+				// Only keep existing version if it matches
+				// the provided version (and/or is more specific)
+				alreadyExists = true
+				fullVersion = require.Mod.Version
+				deprecated, retracted = "", ""
+			} else {
+				// Otherwise, remove and replace the pre-existing dependency
+				removePreexisting = true
+			}
+		}
+	}
+
+	// A target version within the same major version (e.g. v2.5.1, when
+	// v2.3.0 is required) only changes the version of the requirement,
+	// unless it's already the required version
+	if newPath == path && versionMatches(oldVersion, version) {
+		return Upgrade{}, fmt.Errorf("%s %s: %w", path, oldVersion, ErrNoUpgrade)
+	}
+
+	if u.strict && retracted != "" {
+		return Upgrade{}, fmt.Errorf("%s %s: %w: %s", newPath, fullVersion, ErrRetracted, retracted)
+	}
+
+	// Drop the old module dependency and add the new, upgraded one (unless the
+	// new major version of the dependency already existed as a dependency, in
+	// which case, we drop it if didn't match the provided version, or maintain
+	// it if it did)
+	comments := TakeRequireComments(file, path)
+	if err := file.DropRequire(path); err != nil {
+		return Upgrade{}, fmt.Errorf("error dropping module requirement %s: %s", path, err)
+	}
+	if removePreexisting {
+		if err := file.DropRequire(newPath); err != nil {
+			return Upgrade{}, fmt.Errorf("error dropping module requirement %s: %s", newPath, err)
+		}
+	}
+	if !alreadyExists {
+		if err := file.AddRequire(newPath, fullVersion); err != nil {
+			return Upgrade{}, fmt.Errorf("error adding module requirement %s: %s", newPath, err)
+		}
+		SetRequireComments(file, newPath, comments)
+	}
+
+	upgrade := Upgrade{
+		OldPath:    path,
+		OldVersion: oldVersion,
+		NewPath:    newPath,
+		NewVersion: fullVersion,
+		Deprecated: deprecated,
+		Retracted:  retracted,
+	}
+	if err := u.UpgradeReplaces(upgrade); err != nil {
+		return Upgrade{}, err
+	}
+	return upgrade, nil
+}
+
+// versionMatches reports whether the required version of a module matches the
+// version given to upgrade it to: exactly, for a full version (e.g. v2.5.1),
+// or, for a shortened one (e.g. v2, or v2.5), in the components given (so
+// v2.5.1 matches v2.5, but v2.50.0 doesn't). Any version matches if none was
+// given.
+func versionMatches(required, version string) bool {
+	if version == "" {
+		return true
+	}
+	if !semver.IsValid(required) {
+		return false
+	}
+	switch strings.Count(strings.SplitN(version, "-", 2)[0], ".") {
+	case 0:
+		return semver.Major(required) == version
+	case 1:
+		return semver.MajorMinor(required) == version
+	default:
+		return semver.Compare(required, version) == 0 && semver.Build(required) == semver.Build(version)
+	}
+}
+
+// CheckDependency returns an error if the module isn't required by the go.mod
+// file. If a different major version of the module is required instead (e.g.
+// github.com/foo/bar/v2, rather than github.com/foo/bar/v3), the error suggests
+// it, since the wrong major version is an easy mistake to make. If several are
+// required, the one closest to the given major version is suggested.
+func CheckDependency(file *modfile.File, path string) error {
+	prefix, _, ok := module.SplitPathVersion(path)
+	if !ok {
+		return fmt.Errorf("module not a known dependency: %s", path)
+	}
+	major := pathMajorNumber(path)
+
+	var (
+		suggestion string
+		distance   int
+	)
+	for _, require := range file.Require {
+		if require.Mod.Path == path {
+			return nil
+		}
+
+		reqPrefix, _, ok := module.SplitPathVersion(require.Mod.Path)
+		if !ok || reqPrefix != prefix {
+			continue
+		}
+		d := pathMajorNumber(require.Mod.Path) - major
+		if d < 0 {
+			d = -d
+		}
+		if suggestion == "" || d < distance {
+			suggestion, distance = require.Mod.Path, d
+		}
+	}
+
+	if suggestion != "" {
+		return fmt.Errorf("module not a known dependency: %s; did you mean %s?", path, suggestion)
+	}
+	return fmt.Errorf("module not a known dependency: %s", path)
+}
+
+// Remap replaces the requirement on the module or package path oldPath with a
+// requirement on the module newPath, and returns the resulting upgrade, whose
+// imports can be rewritten along with those of other upgrades. It is intended
+// for a major version that reorganizes a module: e.g. one that splits the
+// package github.com/foo/bar/extra out into the module github.com/foo/extra.
+// If no version is given, the latest version of newPath is required (with
+// WithStrict, retracted versions are refused). The requirement on oldPath is
+// dropped if there is one (there isn't when oldPath is a package within a
+// required module).
+func (u *Upgrader) Remap(ctx context.Context, oldPath, newPath, version string) (Upgrade, error) {
+	file, err := u.ModFile()
+	if err != nil {
+		return Upgrade{}, err
+	}
+
+	if err := module.CheckImportPath(oldPath); err != nil {
+		return Upgrade{}, fmt.Errorf("invalid path %s: %s", oldPath, err)
+	}
+	if err := module.CheckPath(newPath); err != nil {
+		return Upgrade{}, fmt.Errorf("invalid module path %s: %s", newPath, err)
+	}
+
+	// Call 'go list -m' to get the full version (or the latest one)
+	query := version
+	if query == "" {
+		query = "latest"
+	}
+	results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, fmt.Sprintf("%s@%s", newPath, query))
+	if err != nil {
+		return Upgrade{}, fmt.Errorf("error getting module info: %s", err)
+	}
+	if len(results) == 0 {
+		return Upgrade{}, fmt.Errorf("no version information for %s@%s", newPath, query)
+	}
+	if results[0].Error != nil {
+		return Upgrade{}, fmt.Errorf("error getting version information for %s@%s: %s", newPath, query, results[0].Error.Err)
+	}
+	newVersion := results[0].Version
+	retracted := strings.Join(results[0].Retracted, "; ")
+	if u.strict && retracted != "" {
+		return Upgrade{}, fmt.Errorf("%s %s: %w: %s", newPath, newVersion, ErrRetracted, retracted)
+	}
+
+	var oldVersion string
+	for _, require := range file.Require {
+		if require.Mod.Path == oldPath {
+			oldVersion = require.Mod.Version
+		}
+	}
+	comments := TakeRequireComments(file, oldPath)
+	if oldVersion != "" {
+		if err := file.DropRequire(oldPath); err != nil {
+			return Upgrade{}, fmt.Errorf("error dropping module requirement %s: %s", oldPath, err)
+		}
+	}
+	if err := file.AddRequire(newPath, newVersion); err != nil {
+		return Upgrade{}, fmt.Errorf("error adding module requirement %s: %s", newPath, err)
+	}
+	SetRequireComments(file, newPath, comments)
+
+	return Upgrade{
+		OldPath:    oldPath,
+		OldVersion: oldVersion,
+		NewPath:    newPath,
+		NewVersion: newVersion,
+		Deprecated: results[0].Deprecated,
+		Retracted:  retracted,
+	}, nil
+}
+
+// Summary counts the outcomes of UpgradeAllDependencies.
+type Summary struct {
+	Checked  int // Dependencies considered for upgrade (see WithFilter)
+	Upgraded int // Dependencies upgraded
+	Latest   int // Dependencies with no higher major version available
+	Skipped  int // Main modules, pinned or recently updated dependencies, and upgrades not picked or confirmed
+	Excluded int // Dependencies excluded with WithExclude
+	Errors   int // Dependencies whose versions couldn't be looked up
+}
+
+// Answer is an answer to the confirmation of an upgrade (see WithConfirm).
+type Answer int
+
+const (
+	Apply Answer = iota // Make the upgrade
+	Skip                // Leave the dependency as it is
+	Quit                // Leave the dependency, and all remaining ones, as they are
+)
+
+// NoUpgradeError is returned by UpgradeAllDependencies, with WithStrict, when
+// some dependencies have no higher major version available. It wraps
+// ErrNoUpgrade.
+type NoUpgradeError struct {
+	Paths []string // The module paths of the dependencies
+}
+
+func (e *NoUpgradeError) Error() string {
+	return fmt.Sprintf("%s: %s", strings.Join(e.Paths, ", "), ErrNoUpgrade)
+}
+
+func (e *NoUpgradeError) Unwrap() error {
+	return ErrNoUpgrade
+}
+
+// LookupErrors are the errors looking up the versions of individual
+// dependencies, which UpgradeAllDependencies leaves as they are (while
+// upgrading the others). Each error begins with the dependency's module path.
+type LookupErrors []error
+
+func (e LookupErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("error getting upgrade versions of %d module(s):\n\t%s", len(e), strings.Join(msgs, "\n\t"))
+}
+
+// UpgradeAllDependencies upgrades each direct dependency in the go.mod file
+// (and each indirect one, with WithIndirect) to the latest version of its
+// highest available major version (or to the one picked with
+// WithPickVersion), and returns the upgrades made, along with a summary of
+// the outcomes. The versions of the dependencies are looked up (with
+// LookupVersions) before anything is upgraded. Dependencies whose versions
+// can't be looked up are left as they are, and returned as LookupErrors
+// (alongside the upgrades made). So are those with no higher major version
+// available, unless WithStrict is given, in which case a NoUpgradeError is
+// returned, without anything being upgraded. Requirements on the module itself
+// (or on those given with WithMainModules), and pinned dependencies (see
+// IsPinned), are never upgraded. If the new major version of a dependency is
+// already required, its version is kept.
+func (u *Upgrader) UpgradeAllDependencies(ctx context.Context) ([]Upgrade, Summary, error) {
+	var summary Summary
+	file, err := u.ModFile()
+	if err != nil {
+		return nil, summary, err
+	}
+
+	// Figure out which requirements are candidates for upgrade
+	var candidates []*modfile.Require
+	for _, require := range file.Require {
+		path := require.Mod.Path
+
+		// Don't upgrade indirect dependencies (don't have access to the
+		// source code, so can't modify import paths), unless asked to
+		if require.Indirect && !u.indirect {
+			continue
+		}
+
+		// Dependencies that don't match the filter are outside the scope of
+		// the upgrade altogether, so aren't counted as checked
+		if u.filter != nil && !u.filter(path) {
+			u.verbosef("%s - doesn't match filter, skipping\n", path)
+			continue
+		}
+		summary.Checked++
+
+		// Some go.mod files (e.g. in workspaces) require the module itself,
+		// or other main modules, which aren't dependencies
+		if path == file.Module.Mod.Path || u.mainModules != nil && u.mainModules(path) {
+			u.verbosef("%s - requirement on main module, skipping\n", path)
+			summary.Skipped++
+			continue
+		}
+
+		if u.exclude != nil && u.exclude(path) {
+			u.verbosef("%s - excluded, skipping\n", path)
+			summary.Excluded++
+			continue
+		}
+
+		if IsPinned(require) {
+			u.verbosef("%s - pinned, skipping\n", path)
+			summary.Skipped++
+			continue
+		}
+
+		candidates = append(candidates, require)
+	}
+
+	// Dependencies upgraded recently (e.g. by someone else, since the last
+	// batch of upgrades) are left as they are
+	if !u.since.IsZero() {
+		candidates, err = u.skipRecentlyUpdated(ctx, candidates, &summary)
+		if err != nil {
+			return nil, summary, err
+		}
+	}
+
+	// Look up the versions of every candidate before modifying the go.mod
+	// file, so that it's left as it is if any can't be upgraded. The lookups
+	// call 'go list', which can be slow if the module info isn't already in
+	// the module cache, so they're made concurrently, by a fixed number of
+	// workers (which also bounds the number of 'go list' subprocesses running
+	// at once).
+	var (
+		versions = make([]Versions, len(candidates))
+		errs     = make([]error, len(candidates))
+		indexes  = make(chan int)
+		wg       = sync.WaitGroup{}
+	)
+	for w := 0; w < u.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				u.verbosef("Fetching %s\n", candidates[i].Mod.Path)
+				versions[i], errs[i] = u.LookupVersions(ctx, candidates[i].Mod.Path, candidates[i].Mod.Version)
+			}
+		}()
+	}
+	for i := range candidates {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if u.strict {
+		var notUpgradable []string
+		for i, require := range candidates {
+			if errs[i] == nil && len(versions[i].Upgrades) == 0 {
+				notUpgradable = append(notUpgradable, require.Mod.Path)
+			}
+		}
+		if len(notUpgradable) > 0 {
+			return nil, summary, &NoUpgradeError{Paths: notUpgradable}
+		}
+	}
+
+	// Make the upgrades sequentially, in go.mod order, so that picking and
+	// confirming them, and modifying the go.mod file, don't need to be
+	// synchronized
+	var (
+		upgrades  []Upgrade
+		lookupErr LookupErrors
+	)
+	for i, require := range candidates {
+		var (
+			path     = require.Mod.Path
+			version  = require.Mod.Version
+			indirect = require.Indirect
+		)
+
+		if errs[i] != nil {
+			lookupErr = append(lookupErr, fmt.Errorf("%s: %s", path, errs[i]))
+			summary.Errors++
+			continue
+		}
+
+		// Higher +incompatible versions can be picked along with the
+		// module-aware ones, but aren't upgraded to by default
+		choices := versions[i].Upgrades
+		if incompatible := versions[i].Incompatible; incompatible != "" {
+			if u.pick != nil {
+				choices = append([]string{incompatible}, choices...)
+			} else {
+				u.printf("Note: %s also has a higher +incompatible version, %s (which is only upgraded to when asked for explicitly)\n",
+					path, incompatible,
+				)
+			}
+		}
+
+		if len(choices) == 0 {
+			summary.Latest++
+			if u.verbose && u.prereleaseOnly(ctx, path, version) {
+				u.printf("%s - no stable versions available for upgrade\n", path)
+			} else {
+				u.verbosef("%s - no versions available for upgrade\n", path)
+			}
+			continue
+		}
+
+		newVersion := LatestVersion(choices)
+		if u.pick != nil {
+			newVersion, err = u.pick(path, choices)
+			if err != nil {
+				return nil, summary, fmt.Errorf("error picking upgrade version for module %s: %s", path, err)
+			}
+			if newVersion == "" {
+				summary.Skipped++
+				continue
+			}
+		}
+
+		upgrade, err := u.DependencyUpgrade(path, newVersion)
+		if err != nil {
+			return nil, summary, err
+		}
+		if upgrade.NewVersion == newVersion {
+			upgrade.Deprecated = versions[i].Deprecated[newVersion]
+			upgrade.Retracted = versions[i].Retracted[newVersion]
+		}
+		if u.strict && upgrade.Retracted != "" {
+			return nil, summary, fmt.Errorf("%s %s: %w: %s", upgrade.NewPath, upgrade.NewVersion, ErrRetracted, upgrade.Retracted)
+		}
+
+		if u.confirm != nil {
+			answer, err := u.confirm(upgrade)
+			if err != nil {
+				return nil, summary, fmt.Errorf("error confirming upgrade of module %s: %s", path, err)
+			}
+			if answer == Quit {
+				summary.Skipped += len(candidates) - i
+				break
+			}
+			if answer == Skip {
+				summary.Skipped++
+				continue
+			}
+		}
+
+		// NOTE: require becomes invalid after this operation
+		if err := u.ApplyUpgrade(upgrade); err != nil {
+			return nil, summary, err
+		}
+		upgrades = append(upgrades, upgrade)
+		summary.Upgraded++
+
+		if indirect {
+			u.printf("%s (indirect)\n", upgrade)
+		} else {
+			u.printf("%s\n", upgrade)
+		}
+		if u.onUpgrade != nil {
+			u.onUpgrade(upgrade)
+		}
+	}
+
+	if len(lookupErr) > 0 {
+		return upgrades, summary, lookupErr
+	}
+	return upgrades, summary, nil
+}
+
+// skipRecentlyUpdated returns the given requirements, without those whose
+// current version was published after the WithSince time, which are counted
+// as skipped. Requirements whose version has no known publication time are
+// kept.
+func (u *Upgrader) skipRecentlyUpdated(ctx context.Context, requires []*modfile.Require, summary *Summary) ([]*modfile.Require, error) {
+	if len(requires) == 0 {
+		return requires, nil
+	}
+
+	queries := make([]string, len(requires))
+	for i, require := range requires {
+		queries[i] = require.Mod.Path + "@" + require.Mod.Version
+	}
+	results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, queries...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting publication times of current versions: %s", err)
+	}
+	published := map[string]time.Time{}
+	for _, result := range results {
+		if result.Error == nil && result.Time != nil {
+			published[result.Path+"@"+result.Version] = *result.Time
+		}
+	}
+
+	var kept []*modfile.Require
+	for i, require := range requires {
+		t, ok := published[queries[i]]
+		if ok && t.After(u.since) {
+			u.verbosef("%s - current version %s published %s, after %s, skipping\n",
+				require.Mod.Path, require.Mod.Version, t.Format(time.DateOnly), u.since.Format(time.DateOnly),
+			)
+			summary.Skipped++
+			continue
+		}
+		if !ok {
+			u.verbosef("%s - publication time of current version %s unknown, not skipping\n", require.Mod.Path, require.Mod.Version)
+		}
+		kept = append(kept, require)
+	}
+	return kept, nil
+}
+
+// DependencyUpgrade returns the upgrade of the given dependency to the given
+// version, without making it (which is done with ApplyUpgrade). If the
+// version's major version of the dependency is already required, the version
+// already required is kept.
+func (u *Upgrader) DependencyUpgrade(path, version string) (Upgrade, error) {
+	file, err := u.ModFile()
+	if err != nil {
+		return Upgrade{}, err
+	}
+
+	newPath, err := UpgradePath(path, version)
+	if err != nil {
+		return Upgrade{}, fmt.Errorf("error upgrading module path %s to %s: %s", path, version, err)
+	}
+
+	upgrade := Upgrade{OldPath: path, NewPath: newPath, NewVersion: version}
+	for _, require := range file.Require {
+		switch require.Mod.Path {
+		case path:
+			upgrade.OldVersion = require.Mod.Version
+		case newPath:
+			// If the upgraded version already exists as a dependency,
+			// maintain the current minor/patch version
+			upgrade.NewVersion = require.Mod.Version
+		}
+	}
+	return upgrade, nil
+}
+
+// ApplyUpgrade makes the given upgrade of a dependency in the go.mod file. The
+// requirement on the old path is replaced with one on the new path (unless it
+// is already required), which keeps the old one's comments, and is marked as
+// indirect if the old one was. Replace and exclude directives are upgraded as
+// by UpgradeReplaces.
+func (u *Upgrader) ApplyUpgrade(upgrade Upgrade) error {
+	file, err := u.ModFile()
+	if err != nil {
+		return err
+	}
+
+	var indirect, exists bool
+	for _, require := range file.Require {
+		switch require.Mod.Path {
+		case upgrade.OldPath:
+			indirect = require.Indirect
+		case upgrade.NewPath:
+			exists = true
+		}
+	}
+
+	comments := TakeRequireComments(file, upgrade.OldPath)
+	if err := file.DropRequire(upgrade.OldPath); err != nil {
+		return fmt.Errorf("error dropping module requirement %s: %s", upgrade.OldPath, err)
+	}
+	if !exists {
+		file.AddNewRequire(upgrade.NewPath, upgrade.NewVersion, indirect)
+		SetRequireComments(file, upgrade.NewPath, comments)
+	}
+	return u.UpgradeReplaces(upgrade)
+}
+
+// UpgradeReplaces updates any replace directives in the go.mod file that refer
+// to the upgraded module (on either side), so that they refer to the new
+// module path and version instead. Replacements with local directories are
+// left untouched (with a warning), since the path there is a filesystem path,
+// not a module path. Exclude directives that refer to the upgraded module are
+// re-added under the new module path and version, and the original ones kept
+// (they still apply if the old version remains in the build, e.g. as a
+// transitive dependency).
+func (u *Upgrader) UpgradeReplaces(upgrade Upgrade) error {
+	file, err := u.ModFile()
+	if err != nil {
+		return err
+	}
+	oldPath, newPath, newVersion := upgrade.OldPath, upgrade.NewPath, upgrade.NewVersion
+
+	// Copy the replace directives, since they're modified while iterating
+	replaces := append([]*modfile.Replace{}, file.Replace...)
+	for _, replace := range replaces {
+		if replace.Old.Path != oldPath && replace.New.Path != oldPath {
+			continue
+		}
+
+		if modfile.IsDirectoryPath(replace.New.Path) {
+			u.printf("Warning: replace directive %s => %s refers to a local directory, and was not updated\n",
+				replace.Old.Path, replace.New.Path,
+			)
+			continue
+		}
+
+		// A replacement of the module with another version of itself no
+		// longer applies after the upgrade, so drop it
+		if replace.Old.Path == oldPath && replace.New.Path == oldPath {
+			if err := file.DropReplace(replace.Old.Path, replace.Old.Version); err != nil {
+				return fmt.Errorf("error dropping replace directive for %s: %s", replace.Old.Path, err)
+			}
+			u.printf("Dropped replace directive %s => %s %s\n", oldPath, oldPath, replace.New.Version)
+			continue
+		}
+
+		old, new := replace.Old, replace.New
+		if old.Path == oldPath {
+			old.Path = newPath
+			if old.Version != "" {
+				old.Version = newVersion
+			}
+		}
+		if new.Path == oldPath {
+			new.Path, new.Version = newPath, newVersion
+		}
+
+		if err := file.DropReplace(replace.Old.Path, replace.Old.Version); err != nil {
+			return fmt.Errorf("error dropping replace directive for %s: %s", replace.Old.Path, err)
+		}
+		if err := file.AddReplace(old.Path, old.Version, new.Path, new.Version); err != nil {
+			return fmt.Errorf("error adding replace directive for %s: %s", old.Path, err)
+		}
+
+		u.verbosef("replace %s => %s %s\n", old.Path, new.Path, new.Version)
+	}
+
+	if oldPath != newPath {
+		// Copy the exclude directives, since they're added to while iterating
+		excludes := append([]*modfile.Exclude{}, file.Exclude...)
+		for _, exclude := range excludes {
+			if exclude.Mod.Path != oldPath {
+				continue
+			}
+			if err := file.AddExclude(newPath, newVersion); err != nil {
+				return fmt.Errorf("error adding exclude directive for %s: %s", newPath, err)
+			}
+			u.verbosef("exclude %s %s\n", newPath, newVersion)
+		}
+	}
+	return nil
+}
+
+// requiredVersion returns the version of the given module required by the
+// go.mod file, or an empty string if it isn't required.
+func requiredVersion(file *modfile.File, path string) string {
+	for _, require := range file.Require {
+		if require.Mod.Path == path {
+			return require.Mod.Version
+		}
+	}
+	return ""
+}
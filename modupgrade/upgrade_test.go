@@ -0,0 +1,733 @@
+package modupgrade
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+func parseModFile(t *testing.T, goMod string) *modfile.File {
+	t.Helper()
+
+	file, err := modfile.Parse("go.mod", []byte(goMod), nil)
+	if err != nil {
+		t.Fatalf("Error parsing go.mod file: %s", err)
+	}
+	return file
+}
+
+func TestUpgradeModule(t *testing.T) {
+	tests := []struct {
+		path    string
+		version string
+		want    string
+	}{
+		{"example.com/sample", "", "example.com/sample/v2"},
+		{"example.com/sample/v2", "", "example.com/sample/v3"},
+		{"example.com/sample", "v4.1.0", "example.com/sample/v4"},
+	}
+	for _, test := range tests {
+		file := parseModFile(t, "module "+test.path+"\n")
+
+		u, err := New(".", WithModFile(file)).UpgradeModule(test.version)
+		if err != nil {
+			t.Fatalf("UpgradeModule(%q) of %s: unexpected error: %s", test.version, test.path, err)
+		}
+		if u.OldPath != test.path || u.NewPath != test.want {
+			t.Errorf("UpgradeModule(%q) of %s: expected %s -> %s, got %s", test.version, test.path, test.path, test.want, u)
+		}
+		if file.Module.Mod.Path != test.want {
+			t.Errorf("UpgradeModule(%q) of %s: expected module statement %s, got %s", test.version, test.path, test.want, file.Module.Mod.Path)
+		}
+	}
+}
+
+func TestUpgradeModuleDowngrade(t *testing.T) {
+	file := parseModFile(t, "module example.com/sample/v3\n")
+
+	_, err := New(".", WithModFile(file)).UpgradeModule("v2")
+	if !errors.Is(err, ErrDowngrade) {
+		t.Fatalf("Expected ErrDowngrade, got: %v", err)
+	}
+
+	u, err := New(".", WithModFile(file), WithDowngrade(true)).UpgradeModule("v2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if u.NewPath != "example.com/sample/v2" {
+		t.Errorf("Expected example.com/sample/v2, got %s", u.NewPath)
+	}
+}
+
+func TestCheckDependency(t *testing.T) {
+	file := parseModFile(t, `module example.com/sample
+
+require (
+	github.com/foo/bar/v2 v2.1.0
+	github.com/foo/bar/v5 v5.0.0
+	gopkg.in/yaml.v2 v2.4.0
+)
+`)
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "github.com/foo/bar/v2", expected: ""},
+		{path: "github.com/foo/bar/v3", expected: "module not a known dependency: github.com/foo/bar/v3; did you mean github.com/foo/bar/v2?"},
+		{path: "github.com/foo/bar/v4", expected: "module not a known dependency: github.com/foo/bar/v4; did you mean github.com/foo/bar/v5?"},
+		{path: "github.com/foo/bar", expected: "module not a known dependency: github.com/foo/bar; did you mean github.com/foo/bar/v2?"},
+		{path: "gopkg.in/yaml.v3", expected: "module not a known dependency: gopkg.in/yaml.v3; did you mean gopkg.in/yaml.v2?"},
+		{path: "github.com/foo/baz", expected: "module not a known dependency: github.com/foo/baz"},
+	}
+	for _, test := range tests {
+		err := CheckDependency(file, test.path)
+		if test.expected == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", test.path, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("%s: expected error %q, got: %v", test.path, test.expected, err)
+		}
+	}
+}
+
+// listerFunc is a Lister that returns results for each query individually.
+type listerFunc func(query string) Module
+
+func (f listerFunc) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]Module, error) {
+	var results []Module
+	for _, modulePath := range modulePaths {
+		results = append(results, f(modulePath))
+	}
+	return results, nil
+}
+
+// TestUpgradeDependencyGopkgIn upgrades a gopkg.in dependency of a synthetic
+// module to its highest major version, and checks the go.mod file and imports
+// written to disk.
+func TestUpgradeDependencyGopkgIn(t *testing.T) {
+	dir := t.TempDir()
+	const (
+		goMod  = "module example.com/sample\n\ngo 1.22\n\nrequire gopkg.in/yaml.v2 v2.4.0\n"
+		source = "package sample\n\nimport _ \"gopkg.in/yaml.v2\"\n"
+	)
+	filename := filepath.Join(dir, "sample.go")
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0644); err != nil {
+		t.Fatalf("Error writing go.mod file: %s", err)
+	}
+	if err := ioutil.WriteFile(filename, []byte(source), 0644); err != nil {
+		t.Fatalf("Error writing source file: %s", err)
+	}
+
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing source file: %s", err)
+	}
+	pkg := &packages.Package{
+		PkgPath: "example.com/sample",
+		Fset:    fset,
+		Syntax:  []*ast.File{fileAST},
+		Imports: map[string]*packages.Package{
+			"gopkg.in/yaml.v2": {
+				PkgPath: "gopkg.in/yaml.v2",
+				Module:  &packages.Module{Path: "gopkg.in/yaml.v2"},
+			},
+		},
+	}
+
+	// Only gopkg.in/yaml.v3 exists beyond the current major version
+	lister := listerFunc(func(query string) Module {
+		path, _, _ := strings.Cut(query, "@")
+		if path == "gopkg.in/yaml.v3" {
+			return Module{Path: path, Version: "v3.0.1"}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "not found: " + query}}
+	})
+
+	u := New(dir, WithLister(lister), WithPackages([]*packages.Package{pkg}))
+	upgrade, err := u.UpgradeDependency(context.Background(), "gopkg.in/yaml.v2", "")
+	if err != nil {
+		t.Fatalf("Unexpected error upgrading dependency: %s", err)
+	}
+	if upgrade.NewPath != "gopkg.in/yaml.v3" || upgrade.NewVersion != "v3.0.1" {
+		t.Fatalf("Expected upgrade to gopkg.in/yaml.v3 v3.0.1, got %s", upgrade)
+	}
+
+	files, err := u.RewriteImports(context.Background(), []Upgrade{upgrade})
+	if err != nil {
+		t.Fatalf("Unexpected error rewriting imports: %s", err)
+	}
+	for _, file := range files {
+		if err := file.Write(); err != nil {
+			t.Fatalf("Unexpected error writing file: %s", err)
+		}
+	}
+	if err := u.WriteModFile(); err != nil {
+		t.Fatalf("Unexpected error writing go.mod file: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatalf("Error reading go.mod file: %s", err)
+	}
+	if !strings.Contains(string(b), "require gopkg.in/yaml.v3 v3.0.1") || strings.Contains(string(b), "yaml.v2") {
+		t.Errorf("Unexpected go.mod file contents:\n%s", b)
+	}
+
+	b, err = ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error reading source file: %s", err)
+	}
+	if expected := strings.Replace(source, "yaml.v2", "yaml.v3", 1); string(b) != expected {
+		t.Errorf("Unexpected source file contents:\n%s\nExpected:\n%s", b, expected)
+	}
+}
+
+// TestUpgradeDependencyMinorVersion checks that a dependency can be upgraded
+// to a specific minor or patch version of a major version that's already
+// required, rather than keeping the existing one.
+func TestUpgradeDependencyMinorVersion(t *testing.T) {
+	lister := listerFunc(func(query string) Module {
+		path, version, _ := strings.Cut(query, "@")
+		resolved := map[string]string{"v2.3": "v2.3.0", "v2.3.0": "v2.3.0", "v2.5.1": "v2.5.1"}
+		if path == "github.com/foo/bar/v2" && resolved[version] != "" {
+			return Module{Path: path, Version: resolved[version]}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query \"" + version + "\""}}
+	})
+
+	tests := []struct {
+		path, version string
+		expected      string // Expected requirement on github.com/foo/bar/v2
+		err           error
+	}{
+		// The target major version is required at another minor version
+		{path: "github.com/foo/bar", version: "v2.5.1", expected: "v2.5.1"},
+		// The target major version is required at a matching version
+		{path: "github.com/foo/bar", version: "v2.3", expected: "v2.3.0"},
+		// The dependency itself is at another minor version
+		{path: "github.com/foo/bar/v2", version: "v2.5.1", expected: "v2.5.1"},
+		// The dependency itself is already at the version
+		{path: "github.com/foo/bar/v2", version: "v2.3.0", err: ErrNoUpgrade},
+	}
+	for _, test := range tests {
+		file := parseModFile(t, "module example.com/sample\n\ngo 1.22\n\nrequire (\n\tgithub.com/foo/bar v1.2.3\n\tgithub.com/foo/bar/v2 v2.3.0\n)\n")
+		u := New(".", WithLister(lister), WithModFile(file))
+
+		_, err := u.UpgradeDependency(context.Background(), test.path, test.version)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("Expected error %q upgrading %s to %s, got: %v", test.err, test.path, test.version, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error upgrading %s to %s: %s", test.path, test.version, err)
+			continue
+		}
+
+		var required []string
+		for _, require := range file.Require {
+			if require.Mod.Path == "github.com/foo/bar/v2" {
+				required = append(required, require.Mod.Version)
+			}
+		}
+		if len(required) != 1 || required[0] != test.expected {
+			t.Errorf("Expected github.com/foo/bar/v2 to be required at %s after upgrading %s to %s, got %v",
+				test.expected, test.path, test.version, required,
+			)
+		}
+	}
+}
+
+// TestRemap upgrades a dependency whose next major version splits one of its
+// packages out into a separate module, and checks that the remapped package's
+// import is rewritten to the new module, rather than the upgraded one.
+func TestRemap(t *testing.T) {
+	const source = `package sample
+
+import (
+	"github.com/foo/bar"
+	"github.com/foo/bar/extra"
+)
+`
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, "sample.go", source, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing source file: %s", err)
+	}
+	barModule := &packages.Module{Path: "github.com/foo/bar"}
+	pkg := &packages.Package{
+		PkgPath: "example.com/sample",
+		Imports: map[string]*packages.Package{
+			"github.com/foo/bar":       {PkgPath: "github.com/foo/bar", Module: barModule},
+			"github.com/foo/bar/extra": {PkgPath: "github.com/foo/bar/extra", Module: barModule},
+		},
+	}
+
+	lister := listerFunc(func(query string) Module {
+		path, _, _ := strings.Cut(query, "@")
+		return Module{Path: path, Version: "v1.1.0"}
+	})
+	file := parseModFile(t, "module example.com/sample\n\ngo 1.22\n\nrequire github.com/foo/bar v1.2.3\n")
+	u := New(".", WithLister(lister), WithModFile(file))
+
+	remap, err := u.Remap(context.Background(), "github.com/foo/bar/extra", "github.com/foo/extra", "")
+	if err != nil {
+		t.Fatalf("Unexpected error remapping: %s", err)
+	}
+	if remap.NewVersion != "v1.1.0" {
+		t.Errorf("Expected latest version v1.1.0, got %s", remap.NewVersion)
+	}
+
+	upgrades := []Upgrade{
+		{OldPath: "github.com/foo/bar", NewPath: "github.com/foo/bar/v2", NewVersion: "v2.0.0"},
+		remap,
+	}
+	if _, err := RewriteFile(pkg, fileAST, upgrades); err != nil {
+		t.Fatalf("Unexpected error rewriting imports: %s", err)
+	}
+
+	var imports []string
+	for _, imp := range fileAST.Imports {
+		imports = append(imports, imp.Path.Value)
+	}
+	if got, expected := strings.Join(imports, " "), `"github.com/foo/bar/v2" "github.com/foo/extra"`; got != expected {
+		t.Errorf("Expected imports %s, got %s", expected, got)
+	}
+
+	out, err := FormatModFile(file)
+	if err != nil {
+		t.Fatalf("Error formatting go.mod file: %s", err)
+	}
+	if !strings.Contains(string(out), "github.com/foo/extra v1.1.0") {
+		t.Errorf("Expected requirement on remapped module, got:\n%s", out)
+	}
+}
+
+// TestRewriteFileNamedImports checks that named imports keep their names,
+// unless the name is just the conventional one for the old import path, and
+// the new path's differs, in which case the import and its uses are renamed.
+func TestRewriteFileNamedImports(t *testing.T) {
+	const source = `package sample
+
+import (
+	bar "github.com/foo/bar"
+	qux "github.com/foo/qux"
+	mypkg "github.com/foo/other"
+)
+
+var _ = bar.Hello() + qux.Hello() + mypkg.Hello()
+`
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, "sample.go", source, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing source file: %s", err)
+	}
+	pkg := &packages.Package{
+		PkgPath: "example.com/sample",
+		Imports: map[string]*packages.Package{
+			"github.com/foo/bar":   {PkgPath: "github.com/foo/bar", Module: &packages.Module{Path: "github.com/foo/bar"}},
+			"github.com/foo/qux":   {PkgPath: "github.com/foo/qux", Module: &packages.Module{Path: "github.com/foo/qux"}},
+			"github.com/foo/other": {PkgPath: "github.com/foo/other", Module: &packages.Module{Path: "github.com/foo/other"}},
+		},
+	}
+
+	upgrades := []Upgrade{
+		{OldPath: "github.com/foo/bar", NewPath: "github.com/foo/baz", NewVersion: "v1.0.0"},
+		{OldPath: "github.com/foo/qux", NewPath: "github.com/foo/qux/v2", NewVersion: "v2.0.0"},
+		{OldPath: "github.com/foo/other", NewPath: "github.com/foo/other/v3", NewVersion: "v3.0.0"},
+	}
+	if _, err := RewriteFile(pkg, fileAST, upgrades); err != nil {
+		t.Fatalf("Unexpected error rewriting imports: %s", err)
+	}
+
+	var buf strings.Builder
+	if err := format.Node(&buf, fset, fileAST); err != nil {
+		t.Fatalf("Error formatting file: %s", err)
+	}
+	for _, expected := range []string{
+		`baz "github.com/foo/baz"`,
+		`qux "github.com/foo/qux/v2"`,
+		`mypkg "github.com/foo/other/v3"`,
+		`var _ = baz.Hello() + qux.Hello() + mypkg.Hello()`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected rewritten file to contain %s, got:\n%s", expected, buf.String())
+		}
+	}
+}
+
+// TestRewriteImportsPackageErrors checks that a package with errors is skipped
+// (with a warning), rather than failing the whole rewrite.
+func TestRewriteImportsPackageErrors(t *testing.T) {
+	dir := t.TempDir()
+	const source = "package sample\n\nimport _ \"github.com/foo/bar\"\n"
+	filename := filepath.Join(dir, "sample.go")
+	if err := ioutil.WriteFile(filename, []byte(source), 0644); err != nil {
+		t.Fatalf("Error writing source file: %s", err)
+	}
+
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing source file: %s", err)
+	}
+
+	// The package's imports are missing, as they would be if a dependency
+	// couldn't be loaded
+	pkg := &packages.Package{
+		PkgPath: "example.com/sample",
+		Fset:    fset,
+		Syntax:  []*ast.File{fileAST},
+		Errors:  []packages.Error{{Msg: "could not import github.com/foo/bar"}},
+	}
+
+	var out strings.Builder
+	u := New(dir, WithPackages([]*packages.Package{pkg}), WithOutput(&out))
+	files, err := u.RewriteImports(context.Background(), []Upgrade{
+		{OldPath: "github.com/foo/bar", NewPath: "github.com/foo/bar/v2", NewVersion: "v2.0.0"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error rewriting imports: %s", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no files to be rewritten, got %d", len(files))
+	}
+	if !strings.Contains(out.String(), "skipping package example.com/sample") {
+		t.Errorf("Expected warning about skipped package, got: %q", out.String())
+	}
+}
+
+// TestUpgradeDependencyKeepsComments checks that the comments of a require line
+// survive the line being replaced by that of the upgraded dependency.
+func TestUpgradeDependencyKeepsComments(t *testing.T) {
+	file := parseModFile(t, `module example.com/sample
+
+go 1.22
+
+require (
+	// Needed for the legacy API
+	github.com/foo/bar v1.2.3 // pinned for compatibility
+	github.com/foo/baz v1.0.0 // indirect; see issue 42
+)
+`)
+
+	lister := listerFunc(func(query string) Module {
+		path, version, _ := strings.Cut(query, "@")
+		switch path {
+		case "github.com/foo/bar/v2":
+			return Module{Path: path, Version: "v2.0.0"}
+		case "github.com/foo/baz/v2":
+			return Module{Path: path, Version: "v2.1.0"}
+		}
+		if version == "" {
+			return Module{Path: path, Version: "v1.0.0"} // The required module itself
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query \"" + version + "\""}}
+	})
+
+	u := New(".", WithLister(lister), WithModFile(file))
+	if _, err := u.UpgradeDependency(context.Background(), "github.com/foo/bar", ""); err != nil {
+		t.Fatalf("Unexpected error upgrading dependency: %s", err)
+	}
+	if _, err := u.UpgradeDependency(context.Background(), "github.com/foo/baz", ""); err != nil {
+		t.Fatalf("Unexpected error upgrading dependency: %s", err)
+	}
+
+	out, err := file.Format()
+	if err != nil {
+		t.Fatalf("Error formatting go.mod file: %s", err)
+	}
+	for _, expected := range []string{
+		"\t// Needed for the legacy API\n\tgithub.com/foo/bar/v2 v2.0.0 // pinned for compatibility\n",
+		// The upgraded dependency is required directly, but keeps the rest
+		// of the comment
+		"github.com/foo/baz/v2 v2.1.0 // see issue 42\n",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("Expected go.mod file to contain %q, got:\n%s", expected, out)
+		}
+	}
+}
+
+func TestUpgradeAllDependenciesStrict(t *testing.T) {
+	const goMod = `module example.com/sample
+
+go 1.22
+
+require (
+	github.com/foo/bar v1.2.3
+	github.com/foo/baz v1.0.0
+)
+`
+	// Only github.com/foo/bar has a higher major version
+	lister := listerFunc(func(query string) Module {
+		path, version, _ := strings.Cut(query, "@")
+		if path == "github.com/foo/bar/v2" {
+			return Module{Path: path, Version: "v2.0.0"}
+		}
+		if version == "" {
+			return Module{Path: path, Version: "v1.0.0"}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query \"" + version + "\""}}
+	})
+
+	file := parseModFile(t, goMod)
+	_, _, err := New(".", WithLister(lister), WithModFile(file), WithStrict(true)).UpgradeAllDependencies(context.Background())
+	var noUpgrade *NoUpgradeError
+	if !errors.Is(err, ErrNoUpgrade) || !errors.As(err, &noUpgrade) || !reflect.DeepEqual(noUpgrade.Paths, []string{"github.com/foo/baz"}) {
+		t.Fatalf("Expected ErrNoUpgrade for github.com/foo/baz, got: %v", err)
+	}
+	// Nothing is upgraded
+	if out, _ := file.Format(); string(out) != goMod {
+		t.Errorf("Expected go.mod file to be unmodified, got:\n%s", out)
+	}
+
+	upgrades, _, err := New(".", WithLister(lister), WithModFile(parseModFile(t, goMod))).UpgradeAllDependencies(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error upgrading all dependencies: %s", err)
+	}
+	if len(upgrades) != 1 || upgrades[0].NewPath != "github.com/foo/bar/v2" {
+		t.Errorf("Expected only github.com/foo/bar to be upgraded, got: %v", upgrades)
+	}
+}
+
+// TestUpgradeAllDependenciesExclude checks that excluded dependencies are left
+// as they are (and exempt from WithStrict), and that indirect dependencies are
+// only upgraded with WithIndirect, staying indirect.
+func TestUpgradeAllDependenciesExclude(t *testing.T) {
+	file := parseModFile(t, `module example.com/sample
+
+go 1.22
+
+require (
+	github.com/foo/bar v1.2.3
+	github.com/foo/baz v1.0.0
+	github.com/foo/qux v1.1.0 // indirect
+)
+`)
+
+	// github.com/foo/baz has no higher major version
+	lister := listerFunc(func(query string) Module {
+		path, version, _ := strings.Cut(query, "@")
+		switch path {
+		case "github.com/foo/bar/v2", "github.com/foo/qux/v2":
+			return Module{Path: path, Version: "v2.0.0"}
+		}
+		if version == "" {
+			return Module{Path: path, Version: "v1.0.0"}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query \"" + version + "\""}}
+	})
+
+	u := New(".", WithLister(lister), WithModFile(file), WithStrict(true), WithIndirect(true),
+		WithExclude(func(path string) bool { return path == "github.com/foo/baz" }),
+	)
+	upgrades, summary, err := u.UpgradeAllDependencies(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error upgrading all dependencies: %s", err)
+	}
+	if len(upgrades) != 2 {
+		t.Errorf("Expected 2 upgrades, got: %v", upgrades)
+	}
+	if expected := (Summary{Checked: 3, Upgraded: 2, Excluded: 1}); summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+
+	out, err := file.Format()
+	if err != nil {
+		t.Fatalf("Error formatting go.mod file: %s", err)
+	}
+	for _, expected := range []string{
+		"github.com/foo/bar/v2 v2.0.0\n",
+		"github.com/foo/baz v1.0.0\n",
+		"github.com/foo/qux/v2 v2.0.0 // indirect\n",
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("Expected go.mod file to contain %q, got:\n%s", expected, out)
+		}
+	}
+}
+
+// TestUpgradeAllDependenciesSkipped checks that requirements on main modules,
+// pinned dependencies and those updated since the WithSince time are left as
+// they are, that dependencies whose versions can't be looked up are returned as
+// LookupErrors, and that the remaining upgrades are picked and confirmed.
+func TestUpgradeAllDependenciesSkipped(t *testing.T) {
+	file := parseModFile(t, `module example.com/sample
+
+go 1.22
+
+require (
+	example.com/other v1.0.0
+	github.com/foo/bar v1.2.3
+	github.com/foo/broken v1.0.0
+	github.com/foo/old v1.0.0
+	github.com/foo/pinned v1.0.0 // pinned: do not upgrade
+	github.com/foo/recent v1.3.0
+	github.com/foo/unknown v1.0.0
+)
+`)
+
+	published := func(date string) *time.Time {
+		t, _ := time.Parse(time.DateOnly, date)
+		return &t
+	}
+	lister := listerFunc(func(query string) Module {
+		path, version, _ := strings.Cut(query, "@")
+		switch {
+		case path == "github.com/foo/broken":
+			return Module{Path: path, Error: &ModuleError{Err: "proxy unreachable"}}
+		case query == "github.com/foo/old@v1.0.0":
+			return Module{Path: path, Version: version, Time: published("2023-06-01")}
+		case query == "github.com/foo/recent@v1.3.0":
+			return Module{Path: path, Version: version, Time: published("2024-02-01")}
+		case strings.HasSuffix(path, "/v2"), strings.HasSuffix(path, "/v3"):
+			return Module{Path: path, Version: version + ".0.0"}
+		case version == "":
+			return Module{Path: path, Version: "v1.0.0"}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query \"" + version + "\""}}
+	})
+
+	var picked, confirmed []string
+	since, _ := time.Parse(time.DateOnly, "2024-01-01")
+	u := New(".", WithLister(lister), WithModFile(file), WithConcurrency(2), WithSince(since),
+		WithMainModules(func(path string) bool { return path == "example.com/other" }),
+		WithPickVersion(func(path string, versions []string) (string, error) {
+			picked = append(picked, path+" "+strings.Join(versions, " "))
+			return versions[0], nil
+		}),
+		WithConfirm(func(upgrade Upgrade) (Answer, error) {
+			confirmed = append(confirmed, upgrade.String())
+			if upgrade.OldPath == "github.com/foo/unknown" {
+				return Skip, nil
+			}
+			return Apply, nil
+		}),
+	)
+	upgrades, summary, err := u.UpgradeAllDependencies(context.Background())
+	var lookupErr LookupErrors
+	if !errors.As(err, &lookupErr) || len(lookupErr) != 1 || !strings.HasPrefix(lookupErr[0].Error(), "github.com/foo/broken: ") {
+		t.Fatalf("Expected a lookup error for github.com/foo/broken, got: %v", err)
+	}
+	if len(upgrades) != 2 || upgrades[0].NewPath != "github.com/foo/bar/v2" || upgrades[1].NewPath != "github.com/foo/old/v2" {
+		t.Errorf("Expected github.com/foo/bar and github.com/foo/old to be upgraded to v2, got: %v", upgrades)
+	}
+
+	// Dependencies without a known publication time are upgraded
+	expectedPicked := []string{
+		"github.com/foo/bar v2.0.0 v3.0.0",
+		"github.com/foo/old v2.0.0 v3.0.0",
+		"github.com/foo/unknown v2.0.0 v3.0.0",
+	}
+	if !reflect.DeepEqual(picked, expectedPicked) {
+		t.Errorf("Expected versions to be picked from %q, got %q", expectedPicked, picked)
+	}
+	if len(confirmed) != 3 {
+		t.Errorf("Expected 3 upgrades to be confirmed, got %q", confirmed)
+	}
+	if expected := (Summary{Checked: 7, Upgraded: 2, Skipped: 4, Errors: 1}); summary != expected {
+		t.Errorf("Expected summary %+v, got %+v", expected, summary)
+	}
+}
+
+// mapVersionCache is a VersionCache holding versions in memory.
+type mapVersionCache map[string]Versions
+
+func (c mapVersionCache) Get(path, version string) (Versions, bool) {
+	versions, ok := c[path+"@"+version]
+	return versions, ok
+}
+
+func (c mapVersionCache) Put(path, version string, versions Versions) {
+	c[path+"@"+version] = versions
+}
+
+// TestUpgradeAllDependenciesCached checks that cached versions are upgraded
+// to without being looked up again, and are reported as deprecated or
+// retracted as when they were looked up.
+func TestUpgradeAllDependenciesCached(t *testing.T) {
+	const goMod = "module example.com/sample\n\ngo 1.22\n\nrequire github.com/foo/bar v1.2.3\n"
+
+	var queries int
+	lister := listerFunc(func(query string) Module {
+		queries++
+		path, version, _ := strings.Cut(query, "@")
+		switch {
+		case path == "github.com/foo/bar/v2":
+			return Module{Path: path, Version: "v2.0.0", Deprecated: "use github.com/foo/baz", Retracted: []string{"broken"}}
+		case version == "":
+			return Module{Path: path, Version: "v1.2.3"}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query \"" + version + "\""}}
+	})
+
+	cache := mapVersionCache{}
+	for _, run := range []string{"looked up", "cached"} {
+		queries = 0
+		u := New(".", WithLister(lister), WithModFile(parseModFile(t, goMod)), WithVersionCache(cache))
+		upgrades, _, err := u.UpgradeAllDependencies(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error upgrading all dependencies (%s): %s", run, err)
+		}
+		expected := []Upgrade{{
+			OldPath:    "github.com/foo/bar",
+			OldVersion: "v1.2.3",
+			NewPath:    "github.com/foo/bar/v2",
+			NewVersion: "v2.0.0",
+			Deprecated: "use github.com/foo/baz",
+			Retracted:  "broken",
+		}}
+		if !reflect.DeepEqual(upgrades, expected) {
+			t.Errorf("Expected upgrades %+v (%s), got %+v", expected, run, upgrades)
+		}
+		if run == "cached" && queries != 0 {
+			t.Errorf("Expected cached versions not to be looked up, got %d queries", queries)
+		}
+	}
+}
+
+// TestUpgradeDependencyStrictRetracted checks that upgrading to a retracted
+// version only warns about it, unless WithStrict is given.
+func TestUpgradeDependencyStrictRetracted(t *testing.T) {
+	const goMod = "module example.com/sample\n\ngo 1.22\n\nrequire github.com/foo/bar v1.2.3\n"
+	lister := listerFunc(func(query string) Module {
+		path, _, _ := strings.Cut(query, "@")
+		return Module{Path: path, Version: "v2.0.0", Retracted: []string{"broken"}}
+	})
+
+	file := parseModFile(t, goMod)
+	_, err := New(".", WithLister(lister), WithModFile(file), WithStrict(true)).UpgradeDependency(context.Background(), "github.com/foo/bar", "v2.0.0")
+	if !errors.Is(err, ErrRetracted) || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("Expected ErrRetracted, got: %v", err)
+	}
+	if out, _ := file.Format(); string(out) != goMod {
+		t.Errorf("Expected go.mod file to be unmodified, got:\n%s", out)
+	}
+
+	u, err := New(".", WithLister(lister), WithModFile(file)).UpgradeDependency(context.Background(), "github.com/foo/bar", "v2.0.0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if u.NewPath != "github.com/foo/bar/v2" || u.Retracted != "broken" {
+		t.Errorf("Expected upgrade to retracted github.com/foo/bar/v2, got %+v", u)
+	}
+}
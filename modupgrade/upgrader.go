@@ -0,0 +1,315 @@
+// Package modupgrade upgrades Go modules, and their dependencies, to new major
+// versions, rewriting import paths as necessary. It is the library behind the
+// upgrade command, for use by other programs (e.g. release bots or editor
+// plugins).
+//
+// Unlike the command, it never exits or prompts: failures are returned as
+// errors, and nothing is written to disk until asked for.
+package modupgrade
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+)
+
+// Upgrader upgrades the module in a single directory, and its dependencies.
+// It is configured with options passed to New.
+type Upgrader struct {
+	dir       string
+	file      *modfile.File
+	pkgs      []*packages.Package
+	lister    Lister
+	output    io.Writer
+	verbose   bool
+	pre       bool
+	downgrade bool
+	maxGap    int
+	batchSize int
+	binary    bool
+	strict    bool
+	indirect  bool
+	exclude   func(modulePath string) bool
+	listFlags []string
+
+	filter      func(modulePath string) bool
+	mainModules func(modulePath string) bool
+	since       time.Time
+	concurrency int
+	cache       VersionCache
+	pick        func(path string, versions []string) (string, error)
+	confirm     func(upgrade Upgrade) (Answer, error)
+	onUpgrade   func(upgrade Upgrade)
+
+	maxFileSize     int64
+	continueOnError bool
+	skipGoGenerate  bool
+
+	client     *http.Client
+	changelogs changelogCache
+}
+
+// Option configures an Upgrader.
+type Option func(*Upgrader)
+
+// WithModFile makes the Upgrader use the given go.mod file, rather than
+// reading it from the module directory. Upgrades modify it in place.
+func WithModFile(file *modfile.File) Option {
+	return func(u *Upgrader) { u.file = file }
+}
+
+// WithPackages makes the Upgrader rewrite imports in the given packages,
+// rather than loading the module's packages itself (e.g. to load them with
+// build tags, or with cgo enabled). They must have been loaded with (at least)
+// the NeedName, NeedCompiledGoFiles, NeedImports, NeedDeps, NeedTypes,
+// NeedSyntax and NeedModule modes, and with Tests set, for test files to be
+// rewritten. With WithMaxFileSize, they should be loaded with ParseFile, so
+// that files over the maximum size aren't parsed in full.
+func WithPackages(pkgs []*packages.Package) Option {
+	return func(u *Upgrader) { u.pkgs = pkgs }
+}
+
+// WithLister sets the Lister used to query module information (GoLister by
+// default).
+func WithLister(lister Lister) Option {
+	return func(u *Upgrader) { u.lister = lister }
+}
+
+// WithOutput sets the writer to which the changes made are reported, along
+// with warnings (e.g. about replace directives that couldn't be updated).
+// Nothing is reported by default.
+func WithOutput(w io.Writer) Option {
+	return func(u *Upgrader) { u.output = w }
+}
+
+// WithVerbose turns on detailed output (e.g. every module version queried).
+func WithVerbose(verbose bool) Option {
+	return func(u *Upgrader) { u.verbose = verbose }
+}
+
+// WithPrerelease makes pre-release versions candidates for upgrade, when a
+// major version has no stable release.
+func WithPrerelease(pre bool) Option {
+	return func(u *Upgrader) { u.pre = pre }
+}
+
+// WithDowngrade allows the module itself to be moved to a lower major version.
+func WithDowngrade(downgrade bool) Option {
+	return func(u *Upgrader) { u.downgrade = downgrade }
+}
+
+// WithMaxGap sets the number of consecutive missing major versions tolerated
+// when searching for higher major versions of a dependency (1 by default, so
+// that a single skipped major version doesn't end the search). With 0, the
+// search stops at the first missing major version.
+func WithMaxGap(n int) Option {
+	return func(u *Upgrader) { u.maxGap = n }
+}
+
+// WithBatchSize sets the number of major versions of a dependency to query at
+// once (1 by default).
+func WithBatchSize(n int) Option {
+	return func(u *Upgrader) { u.batchSize = n }
+}
+
+// WithBinarySearch makes UpgradeVersions search for the highest major version
+// of a dependency with an exponential, then binary search, rather than by
+// querying each major version in turn. It makes far fewer queries for modules
+// with many major versions, but only returns the highest one, and can miss it
+// if some major versions were skipped.
+func WithBinarySearch(binary bool) Option {
+	return func(u *Upgrader) { u.binary = binary }
+}
+
+// WithStrict makes UpgradeDependency, UpgradeAllDependencies and Remap refuse
+// to upgrade a dependency to a retracted version (returning an error wrapping
+// ErrRetracted), and UpgradeAllDependencies fail if any dependency has no
+// higher major version available, rather than leaving it as it is.
+func WithStrict(strict bool) Option {
+	return func(u *Upgrader) { u.strict = strict }
+}
+
+// WithIndirect makes UpgradeAllDependencies upgrade indirect dependencies too.
+// They're left as they are by default, since their imports (being in other
+// modules) can't be rewritten.
+func WithIndirect(indirect bool) Option {
+	return func(u *Upgrader) { u.indirect = indirect }
+}
+
+// WithExclude makes UpgradeAllDependencies leave the dependencies for which the
+// given function returns true as they are. Excluded dependencies are exempt
+// from WithStrict.
+func WithExclude(exclude func(modulePath string) bool) Option {
+	return func(u *Upgrader) { u.exclude = exclude }
+}
+
+// WithFilter makes UpgradeAllDependencies only consider the dependencies for
+// which the given function returns true. Unlike excluded dependencies, the
+// others aren't counted in the Summary at all.
+func WithFilter(filter func(modulePath string) bool) Option {
+	return func(u *Upgrader) { u.filter = filter }
+}
+
+// WithMainModules makes UpgradeAllDependencies treat requirements on the
+// modules for which the given function returns true (e.g. the other modules
+// in a workspace) like a requirement on the module itself, which is never
+// upgraded as though it were a dependency.
+func WithMainModules(mainModules func(modulePath string) bool) Option {
+	return func(u *Upgrader) { u.mainModules = mainModules }
+}
+
+// WithSince makes UpgradeAllDependencies leave the dependencies whose current
+// version was published after the given time (e.g. because they were upgraded
+// recently) as they are. Dependencies whose current version has no known
+// publication time are upgraded as usual.
+func WithSince(since time.Time) Option {
+	return func(u *Upgrader) { u.since = since }
+}
+
+// WithConcurrency sets the number of dependencies whose versions
+// UpgradeAllDependencies looks up at once (1 by default).
+func WithConcurrency(n int) Option {
+	return func(u *Upgrader) { u.concurrency = n }
+}
+
+// WithVersionCache makes LookupVersions (and so UpgradeAllDependencies) reuse
+// the versions held in the given cache, and add those it looks up to it.
+func WithVersionCache(cache VersionCache) Option {
+	return func(u *Upgrader) { u.cache = cache }
+}
+
+// WithPickVersion makes UpgradeDependency (when no version is given) and
+// UpgradeAllDependencies upgrade each dependency to the version returned by
+// the given function, from among the versions it can be upgraded to (in
+// ascending order, including any higher +incompatible version), rather than to
+// the latest one. If it returns an empty version, the dependency is skipped.
+func WithPickVersion(pick func(path string, versions []string) (string, error)) Option {
+	return func(u *Upgrader) { u.pick = pick }
+}
+
+// WithConfirm makes UpgradeAllDependencies ask the given function whether to
+// make each upgrade, before making it.
+func WithConfirm(confirm func(upgrade Upgrade) (Answer, error)) Option {
+	return func(u *Upgrader) { u.confirm = confirm }
+}
+
+// WithOnUpgrade makes UpgradeAllDependencies call the given function after
+// making each upgrade (e.g. to report more about it).
+func WithOnUpgrade(onUpgrade func(upgrade Upgrade)) Option {
+	return func(u *Upgrader) { u.onUpgrade = onUpgrade }
+}
+
+// WithMaxFileSize makes RewriteImports skip .go files larger than the given
+// number of bytes (typically huge generated files, which are unlikely to need
+// rewriting). Their sizes are checked before they're parsed (see ParseFile).
+// There is no limit by default.
+func WithMaxFileSize(n int64) Option {
+	return func(u *Upgrader) { u.maxFileSize = n }
+}
+
+// WithContinueOnError makes RewriteImports skip files whose imports can't be
+// rewritten, rather than failing. The files skipped are returned as FileErrors.
+func WithContinueOnError(continueOnError bool) Option {
+	return func(u *Upgrader) { u.continueOnError = continueOnError }
+}
+
+// WithSkipGoGenerate makes RewriteImports leave the module paths in
+// //go:generate directives as they are.
+func WithSkipGoGenerate(skip bool) Option {
+	return func(u *Upgrader) { u.skipGoGenerate = skip }
+}
+
+// WithListFlags sets extra flags to pass to the Lister (e.g. "-retracted").
+func WithListFlags(flags ...string) Option {
+	return func(u *Upgrader) { u.listFlags = flags }
+}
+
+// WithHTTPClient sets the client used to fetch release notes in Changelog
+// (http.DefaultClient by default).
+func WithHTTPClient(client *http.Client) Option {
+	return func(u *Upgrader) { u.client = client }
+}
+
+// New returns an Upgrader for the module in the given directory.
+func New(dir string, opts ...Option) *Upgrader {
+	u := &Upgrader{
+		dir:         dir,
+		lister:      GoLister{},
+		output:      ioutil.Discard,
+		maxGap:      1,
+		batchSize:   1,
+		concurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// ModFile returns the module's go.mod file, reading it from the module
+// directory the first time it is needed (unless given with WithModFile).
+func (u *Upgrader) ModFile() (*modfile.File, error) {
+	if u.file != nil {
+		return u.file, nil
+	}
+
+	filePath := filepath.Join(u.dir, "go.mod")
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading module file %s: %s", filePath, err)
+	}
+
+	file, err := modfile.Parse(filePath, b, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing module file %s: %s", filePath, err)
+	}
+	u.file = file
+	return file, nil
+}
+
+// FormatModFile returns the formatted contents of the go.mod file.
+func FormatModFile(file *modfile.File) ([]byte, error) {
+	file.SortBlocks()
+	file.Cleanup()
+	out, err := file.Format()
+	if err != nil {
+		return nil, fmt.Errorf("error formatting module file: %s", err)
+	}
+	return out, nil
+}
+
+// WriteModFile writes the (upgraded) go.mod file to the module directory.
+func (u *Upgrader) WriteModFile() error {
+	file, err := u.ModFile()
+	if err != nil {
+		return err
+	}
+
+	out, err := FormatModFile(file)
+	if err != nil {
+		return err
+	}
+
+	filePath := filepath.Join(u.dir, "go.mod")
+	if err := WriteFile(filePath, out); err != nil {
+		return fmt.Errorf("error writing module file %s: %s", filePath, err)
+	}
+	return nil
+}
+
+func (u *Upgrader) printf(format string, args ...any) {
+	fmt.Fprintf(u.output, format, args...)
+}
+
+// verbosef prints the message only when verbose output is turned on.
+func (u *Upgrader) verbosef(format string, args ...any) {
+	if u.verbose {
+		u.printf(format, args...)
+	}
+}
@@ -0,0 +1,179 @@
+package modupgrade
+
+import (
+	"context"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+)
+
+func TestWithModFile(t *testing.T) {
+	file := parseModFile(t, "module example.com/sample\n")
+
+	// The go.mod file is never read from the module directory (which
+	// doesn't exist), and is upgraded in place
+	u, err := New(filepath.Join(t.TempDir(), "missing"), WithModFile(file)).UpgradeModule("")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if u.NewPath != "example.com/sample/v2" || file.Module.Mod.Path != "example.com/sample/v2" {
+		t.Errorf("Expected module statement example.com/sample/v2, got %s (upgrade %s)", file.Module.Mod.Path, u)
+	}
+}
+
+func TestWithPackages(t *testing.T) {
+	// The module directory has no go.mod file, so its packages can't be
+	// loaded: only the given ones can be rewritten
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "main.go")
+	const src = "package main\n\nimport \"github.com/foo/bar/baz\"\n\nvar _ = baz.X\n"
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+
+	fset := token.NewFileSet()
+	fileAST, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	pkg := &packages.Package{
+		PkgPath: "example.com/sample",
+		Fset:    fset,
+		Syntax:  []*ast.File{fileAST},
+		Imports: map[string]*packages.Package{
+			"github.com/foo/bar/baz": {
+				PkgPath: "github.com/foo/bar/baz",
+				Module:  &packages.Module{Path: "github.com/foo/bar"},
+			},
+		},
+	}
+
+	u := New(dir, WithPackages([]*packages.Package{pkg}))
+	files, err := u.RewriteImports(context.Background(), []Upgrade{{
+		OldPath: "github.com/foo/bar",
+		NewPath: "github.com/foo/bar/v2",
+	}})
+	if err != nil {
+		t.Fatalf("Unexpected error rewriting imports: %s", err)
+	}
+	if len(files) != 1 || files[0].Name != filename || len(files[0].Rewrites) != 1 ||
+		files[0].Rewrites[0].NewImportPath != "github.com/foo/bar/v2/baz" {
+		t.Fatalf("Expected the import in %s to be rewritten to github.com/foo/bar/v2/baz, got %+v", filename, files)
+	}
+}
+
+// TestRewriteImportsContinueOnError checks that, with WithContinueOnError, a
+// file whose imports can't be rewritten is skipped and reported, and that files
+// over the maximum file size are skipped.
+func TestRewriteImportsContinueOnError(t *testing.T) {
+	dir := t.TempDir()
+	const source = "package sample\n\nimport _ \"github.com/foo/bar\"\n"
+
+	var (
+		fset  = token.NewFileSet()
+		files []*ast.File
+	)
+	for _, name := range []string{"a.go", "b.go"} {
+		filename := filepath.Join(dir, name)
+		contents := source
+		if name == "b.go" {
+			contents += "\n// " + strings.Repeat("x", 100) + "\n"
+		}
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing source file: %s", err)
+		}
+		fileAST, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatalf("Error parsing source file: %s", err)
+		}
+		files = append(files, fileAST)
+	}
+
+	// The import is missing from the package's imports, so can't be
+	// rewritten
+	pkg := &packages.Package{
+		PkgPath: "example.com/sample",
+		Fset:    fset,
+		Syntax:  files,
+	}
+	upgrades := []Upgrade{{OldPath: "github.com/foo/bar", NewPath: "github.com/foo/bar/v2", NewVersion: "v2.0.0"}}
+
+	if _, err := New(dir, WithPackages([]*packages.Package{pkg})).RewriteImports(context.Background(), upgrades); err == nil {
+		t.Fatalf("Expected error rewriting imports")
+	}
+
+	var out strings.Builder
+	u := New(dir, WithPackages([]*packages.Package{pkg}), WithOutput(&out),
+		WithContinueOnError(true), WithMaxFileSize(int64(len(source))),
+	)
+	rewritten, err := u.RewriteImports(context.Background(), upgrades)
+	var skipped FileErrors
+	if !errors.As(err, &skipped) || len(skipped) != 1 || !strings.Contains(skipped[0].Error(), "a.go") {
+		t.Fatalf("Expected a.go to be skipped, got: %v", err)
+	}
+	if len(rewritten) != 0 {
+		t.Errorf("Expected no files to be rewritten, got %d", len(rewritten))
+	}
+	if !strings.Contains(out.String(), "b.go: size 151B exceeds the maximum file size (-max-file-size 46B)") {
+		t.Errorf("Expected b.go to be skipped for its size, got: %q", out.String())
+	}
+}
+
+// TestRewriteImportsMaxFileSize checks that files over the maximum file size
+// are only parsed as far as their imports when the module's packages are
+// loaded, and that the rest of their package is still rewritten.
+func TestRewriteImportsMaxFileSize(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+	dir := t.TempDir()
+	sources := map[string]string{
+		"go.mod":     "module example.com/sample\n\ngo 1.16\n",
+		"old/old.go": "package old\n\nconst X = 1\n",
+		"a.go":       "package sample\n\nimport \"example.com/sample/old\"\n\nvar _ = old.X + Big\n",
+		"big.go": "package sample\n\nimport \"example.com/sample/old\"\n\nconst Big = old.X\n\n// " +
+			strings.Repeat("x", 100) + "\n",
+	}
+	for name, src := range sources {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("Error creating directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatalf("Error writing file: %s", err)
+		}
+	}
+
+	bigFile := filepath.Join(dir, "big.go")
+	fileAST, err := ParseFile(100)(token.NewFileSet(), bigFile, []byte(sources["big.go"]))
+	if err != nil {
+		t.Fatalf("Error parsing file: %s", err)
+	}
+	if len(fileAST.Decls) != 1 {
+		t.Errorf("Expected only the imports of big.go to be parsed, got %d declarations", len(fileAST.Decls))
+	}
+
+	var out strings.Builder
+	u := New(dir, WithOutput(&out), WithMaxFileSize(100))
+	files, err := u.RewriteImports(context.Background(), []Upgrade{
+		{OldPath: "example.com/sample/old", NewPath: "example.com/sample/new"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error rewriting imports: %s", err)
+	}
+	if len(files) != 1 || files[0].Name != filepath.Join(dir, "a.go") {
+		t.Errorf("Expected only a.go to be rewritten, got %+v", files)
+	}
+	if !strings.Contains(out.String(), "big.go: size 172B exceeds the maximum file size (-max-file-size 100B)") {
+		t.Errorf("Expected big.go to be skipped for its size, got: %q", out.String())
+	}
+	if strings.Contains(out.String(), "skipping package") {
+		t.Errorf("Expected no package to be skipped, got: %q", out.String())
+	}
+}
@@ -0,0 +1,530 @@
+package modupgrade
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// UpgradeVersions returns the highest available version of each major
+// version of the module higher than its current major version, in ascending
+// order.
+func (u *Upgrader) UpgradeVersions(ctx context.Context, path string) ([]string, error) {
+	modules, err := u.upgradeModules(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	for _, module := range modules {
+		versions = append(versions, module.Version)
+	}
+	return versions, nil
+}
+
+// upgradeModules returns the module info of the versions returned by
+// UpgradeVersions (including whether they're deprecated or retracted).
+func (u *Upgrader) upgradeModules(ctx context.Context, path string) ([]Module, error) {
+	// Split module path
+	prefix, pathMajor, ok := module.SplitPathVersion(path)
+	if !ok {
+		return nil, fmt.Errorf("invalid module path: %s", path)
+	}
+
+	var version int
+	if pathMajor != "" {
+		// If the dependency already has a major version in its import path,
+		// start our search for a higher major version there
+		var err error
+		version, err = strconv.Atoi(strings.TrimLeft(pathMajor, "/.v"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid major version '%s': %s", pathMajor, err)
+		}
+		version++
+	} else {
+		// If the dependency does not have a major version in its import path,
+		// get the highest available minor update version (including
+		// incompatible major versions, which allows us to skip over them and
+		// start at the first module-aware major version)
+		minorUpdateVersion, err := u.MinorUpdateVersion(ctx, path)
+		if err != nil {
+			return nil, fmt.Errorf("error getting minor update version for %s: %s", path, err)
+		}
+		version = searchStartMajor(minorUpdateVersion)
+	}
+
+	if u.binary {
+		modules, ok, err := u.searchUpgradeVersion(ctx, prefix, version)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return modules, nil
+		}
+		u.verbosef("Falling back to querying each major version of %s\n", path)
+	}
+
+	// TODO: Consider actually upgrading to higher incompatible versions? Not
+	// sure, because that could also be done with go get -u. It just seems
+	// strange if I'm on, say, v1.0.0+incompatible and it wouldn't upgrade me
+	// to, for example, v2.0.0+incompatible. Would need to ensure it's actually
+	// a higher major than the current version.
+	var (
+		upgradeModules []Module
+		missing        int // Consecutive major versions not found
+	)
+	for {
+		// Make batched calls to 'go list -m' for
+		// better performance (ideally, a single call).
+		var batch []string
+		for i := 0; i < u.batchSize; i++ {
+			modulePath := fmt.Sprintf("%s@v%d", majorPath(prefix, version), version)
+			batch = append(batch, modulePath)
+			version++
+		}
+
+		results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, batch...)
+		if err != nil {
+			return nil, fmt.Errorf("error getting module info: %s", err)
+		}
+
+		for _, result := range results {
+			if result.Error != nil {
+				u.verbosef("%s\n", result.Error.Err)
+
+				// Major versions are occasionally skipped, so only stop
+				// searching once more consecutive versions are missing
+				// than are tolerated
+				missing++
+				if missing > u.maxGap {
+					return upgradeModules, nil
+				}
+				continue
+			}
+			missing = 0
+
+			// Querying a major version returns its highest pre-release
+			// version if it has no stable release, which is only
+			// considered if pre-releases were asked for
+			if semver.Prerelease(result.Version) != "" && !u.pre {
+				u.verbosef("%s %s is a pre-release, skipping (pre-releases not enabled)\n", result.Path, result.Version)
+				continue
+			}
+
+			if len(result.Retracted) > 0 {
+				u.verbosef("%s %s is retracted: %s\n",
+					result.Path, result.Version, strings.Join(result.Retracted, "; "),
+				)
+			}
+			upgradeModules = append(upgradeModules, result)
+		}
+	}
+}
+
+// binarySearchProbes is the number of exponentially increasing major versions
+// queried to find an upper bound for the binary search (i.e. it can find up to
+// 2^binarySearchProbes-1 major versions above the current one).
+const binarySearchProbes = 8
+
+// searchUpgradeVersion returns the highest available version of the highest
+// major version of the module from start upwards (or none), found by querying
+// exponentially increasing major versions (start, start+1, start+3, start+7,
+// etc.) in a single call, then binary searching between the highest one found
+// and the lowest one missing. It reports false if the search can't be relied
+// on, and the major versions should be queried one by one instead: if a query
+// fails for any reason other than the version not existing (e.g. the proxy
+// can't be reached), or the highest major version is a pre-release.
+func (u *Upgrader) searchUpgradeVersion(ctx context.Context, prefix string, start int) ([]Module, bool, error) {
+	var (
+		found   = map[int]Module{}
+		lo      = start - 1 // The highest major version known to exist
+		hi      = -1        // The lowest major version known to be missing
+		majors  []int
+		queries []string
+	)
+	for i, step := 0, 1; i < binarySearchProbes; i, step = i+1, step*2 {
+		major := start + step - 1
+		majors = append(majors, major)
+		queries = append(queries, fmt.Sprintf("%s@v%d", majorPath(prefix, major), major))
+	}
+
+	results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, queries...)
+	if err != nil {
+		return nil, false, fmt.Errorf("error getting module info: %s", err)
+	}
+	for i, result := range results {
+		if result.Error != nil {
+			if !isMissingVersion(result.Error.Err) {
+				return nil, false, nil
+			}
+			hi = majors[i]
+			break
+		}
+		lo = majors[i]
+		found[lo] = result
+	}
+	if hi == -1 {
+		return nil, false, nil // Every probe exists, so there's no upper bound
+	}
+
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		query := fmt.Sprintf("%s@v%d", majorPath(prefix, mid), mid)
+		results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, query)
+		if err != nil {
+			return nil, false, fmt.Errorf("error getting module info: %s", err)
+		}
+		if len(results) == 0 {
+			return nil, false, nil
+		}
+		if results[0].Error != nil {
+			if !isMissingVersion(results[0].Error.Err) {
+				return nil, false, nil
+			}
+			hi = mid
+			continue
+		}
+		lo = mid
+		found[lo] = results[0]
+	}
+
+	if lo < start {
+		return nil, true, nil // No higher major version
+	}
+	result := found[lo]
+	if semver.Prerelease(result.Version) != "" && !u.pre {
+		return nil, false, nil
+	}
+	if len(result.Retracted) > 0 {
+		u.verbosef("%s %s is retracted: %s\n",
+			result.Path, result.Version, strings.Join(result.Retracted, "; "),
+		)
+	}
+	return []Module{result}, true, nil
+}
+
+// missingVersionErrors are the errors reported by the go command when a
+// queried module version doesn't exist.
+var missingVersionErrors = []string{
+	"no matching versions",
+	"not found",
+	"unknown revision",
+	"invalid version",
+}
+
+// isMissingVersion reports whether the error reported for a module query
+// means that the queried version doesn't exist.
+func isMissingVersion(msg string) bool {
+	for _, missing := range missingVersionErrors {
+		if strings.Contains(msg, missing) {
+			return true
+		}
+	}
+	return false
+}
+
+// LatestVersion returns the version to upgrade to by default, given the
+// available upgrade versions (in ascending order): the highest stable version,
+// if there is one, or else the highest pre-release version.
+func LatestVersion(versions []string) string {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if semver.Prerelease(versions[i]) == "" {
+			return versions[i]
+		}
+	}
+	return versions[len(versions)-1]
+}
+
+// searchStartMajor returns the first major version to search for, given the
+// highest available minor update version of a module whose path has no major
+// version suffix. Pseudo-versions (e.g. v0.0.0-20230601123456-abcdef012345)
+// are handled like any other version, based on their major component. If no
+// major version can be determined, the search starts at v2.
+func searchStartMajor(minorUpdateVersion string) int {
+	if !semver.IsValid(minorUpdateVersion) {
+		return 2
+	}
+
+	major, err := strconv.Atoi(strings.TrimPrefix(semver.Major(minorUpdateVersion), "v"))
+	if err != nil {
+		return 2
+	}
+
+	// Make sure not to try upgrading path to /v1
+	// (i.e. if the highest minor update version is v0.x.x)
+	if major < 1 {
+		major = 1
+	}
+	return major + 1
+}
+
+// IncompatibleUpgradeVersion returns the highest +incompatible version of the
+// module (one tagged with a major version above v1, but without a go.mod file),
+// if the given current version of the module is +incompatible as well, and the
+// returned version is of a higher major version. Such versions keep the path
+// of the module, so UpgradeVersions doesn't find them (it starts searching for
+// module-aware major versions above them). It returns an empty string if there
+// is no such version.
+func (u *Upgrader) IncompatibleUpgradeVersion(ctx context.Context, path, current string) (string, error) {
+	if semver.Build(current) != "+incompatible" {
+		return "", nil
+	}
+
+	latest, err := u.MinorUpdateVersion(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if semver.Build(latest) != "+incompatible" || semver.Compare(semver.Major(latest), semver.Major(current)) <= 0 {
+		return "", nil
+	}
+	return latest, nil
+}
+
+// Versions are the versions a dependency can be upgraded to, as returned by
+// LookupVersions.
+type Versions struct {
+	Upgrades     []string `json:"versions"`               // As returned by UpgradeVersions
+	Incompatible string   `json:"incompatible,omitempty"` // As returned by IncompatibleUpgradeVersion
+
+	// The deprecation messages of the deprecated module paths, and the
+	// retraction rationales of the retracted versions, among the upgrade
+	// versions, keyed by version
+	Deprecated map[string]string `json:"deprecated,omitempty"`
+	Retracted  map[string]string `json:"retracted,omitempty"`
+}
+
+// VersionCache caches the versions looked up by LookupVersions (e.g. between
+// runs). It must be safe for concurrent use.
+type VersionCache interface {
+	// Get returns the cached versions of the dependency at the given
+	// version, if there are any.
+	Get(path, version string) (Versions, bool)
+
+	// Put caches the versions of the dependency at the given version.
+	Put(path, version string, versions Versions)
+}
+
+// LookupVersions returns the versions the dependency at the given (current)
+// version can be upgraded to. With WithVersionCache, they're looked up in the
+// cache first, and added to it once looked up.
+func (u *Upgrader) LookupVersions(ctx context.Context, path, version string) (Versions, error) {
+	if u.cache != nil {
+		if versions, ok := u.cache.Get(path, version); ok {
+			return versions, nil
+		}
+	}
+
+	modules, err := u.upgradeModules(ctx, path)
+	if err != nil {
+		return Versions{}, err
+	}
+	incompatible, err := u.IncompatibleUpgradeVersion(ctx, path, version)
+	if err != nil {
+		return Versions{}, err
+	}
+
+	versions := Versions{Incompatible: incompatible}
+	for _, module := range modules {
+		versions.Upgrades = append(versions.Upgrades, module.Version)
+		if module.Deprecated != "" {
+			if versions.Deprecated == nil {
+				versions.Deprecated = map[string]string{}
+			}
+			versions.Deprecated[module.Version] = module.Deprecated
+		}
+		if len(module.Retracted) > 0 {
+			if versions.Retracted == nil {
+				versions.Retracted = map[string]string{}
+			}
+			versions.Retracted[module.Version] = strings.Join(module.Retracted, "; ")
+		}
+	}
+	if u.cache != nil {
+		u.cache.Put(path, version, versions)
+	}
+	return versions, nil
+}
+
+// prereleaseOnly reports whether the module has only pre-release versions
+// available (i.e. no stable release), given its currently required version.
+func (u *Upgrader) prereleaseOnly(ctx context.Context, path, version string) bool {
+	if semver.Prerelease(version) == "" {
+		return false
+	}
+
+	// The highest available minor update version is only a pre-release
+	// if there is no stable version of the module at all
+	latest, err := u.MinorUpdateVersion(ctx, path)
+	return err == nil && semver.Prerelease(latest) != ""
+}
+
+// MinorUpdateVersion returns the highest available version of the module
+// within its current major version (which may be its current version).
+func (u *Upgrader) MinorUpdateVersion(ctx context.Context, path string) (string, error) {
+	results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, path)
+	if err != nil {
+		return "", fmt.Errorf("error getting module info: %s", err)
+	}
+	if len(results) == 0 {
+		return "", fmt.Errorf("no module info returned for %s", path)
+	}
+	result := results[0]
+
+	// A module that isn't a dependency has no current version, so use its
+	// latest version instead
+	if result.Error != nil && strings.Contains(result.Error.Err, "not a known dependency") {
+		results, err = u.lister.ListModules(ctx, u.dir, u.listFlags, path+"@latest")
+		if err != nil {
+			return "", fmt.Errorf("error getting module info: %s", err)
+		}
+		if len(results) == 0 {
+			return "", fmt.Errorf("no module info returned for %s@latest", path)
+		}
+		result = results[0]
+	}
+
+	if result.Error != nil {
+		return "", fmt.Errorf("error getting module info for %s: %s", path, result.Error.Err)
+	}
+
+	if result.Update != nil {
+		if !semver.IsValid(result.Update.Version) {
+			return "", fmt.Errorf("invalid minor update version returned in module info: %s", result.Update.Version)
+		}
+		return result.Update.Version, nil
+	}
+
+	// Use current version if no update version is given
+	// (i.e. we're already at the highest available minor version)
+	if !semver.IsValid(result.Version) {
+		return "", fmt.Errorf("invalid version returned in module info: %s", result.Version)
+	}
+	return result.Version, nil
+}
+
+// ResolveVersion returns the path and full version of the module after
+// upgrading it to the given (possibly partial) version, e.g. v3 or v3.1. The
+// path depends on whether the version is incompatible or not.
+func (u *Upgrader) ResolveVersion(ctx context.Context, path, version string) (string, string, error) {
+	result, err := u.resolveModule(ctx, path, version)
+	if err != nil {
+		return "", "", err
+	}
+	return result.Path, result.Version, nil
+}
+
+// resolveModule returns the module info of the version returned by
+// ResolveVersion (including whether it's deprecated or retracted).
+func (u *Upgrader) resolveModule(ctx context.Context, path, version string) (Module, error) {
+	prefix, _, ok := module.SplitPathVersion(path)
+	if !ok {
+		return Module{}, fmt.Errorf("invalid module path: %s", path)
+	}
+
+	newPath, err := UpgradePath(path, version)
+	if err != nil {
+		return Module{}, fmt.Errorf("error upgrading module path %s to %s: %s", path, version, err)
+	}
+
+	queries := []string{fmt.Sprintf("%s@%s", newPath, version)} // Module-aware
+	if !isGopkgIn(path) {
+		queries = append(queries, fmt.Sprintf("%s@%s", prefix, version)) // Incompatible
+	}
+	results, err := u.lister.ListModules(ctx, u.dir, u.listFlags, queries...)
+	if err != nil {
+		return Module{}, fmt.Errorf("error getting module info: %s", err)
+	}
+
+	for _, result := range results {
+		if result.Error == nil {
+			return result, nil
+		}
+	}
+
+	return Module{}, fmt.Errorf("error getting version information: %s", results[0].Error.Err)
+}
+
+// UpgradePath returns the path of the module after upgrading it to the major
+// version of the given version. If no version is given, the module is upgraded
+// to the next major version. The path of a +incompatible version has no major
+// version suffix.
+func UpgradePath(path, version string) (string, error) {
+	prefix, pathMajor, ok := module.SplitPathVersion(path)
+	if !ok {
+		return "", fmt.Errorf("invalid module path: %s", path)
+	}
+
+	if semver.Build(version) == "+incompatible" {
+		return prefix, nil
+	}
+
+	if version == "" {
+		// If no version was specified, upgrade to next sequential version
+		if pathMajor == "" {
+			version = "v2"
+		} else {
+			num, err := strconv.Atoi(strings.TrimLeft(pathMajor, "/.v"))
+			if err != nil {
+				return "", fmt.Errorf("invalid major version in module path: %s", pathMajor)
+			}
+			num++
+			version = fmt.Sprintf("v%d", num)
+		}
+	}
+
+	major, err := strconv.Atoi(strings.TrimPrefix(semver.Major(version), "v"))
+	if err != nil {
+		return "", fmt.Errorf("invalid version: %s", version)
+	}
+	newPath := majorPath(prefix, major)
+	if err := module.CheckPath(newPath); err != nil {
+		return "", fmt.Errorf("invalid module path after upgrade - %s: %s", newPath, err)
+
+	}
+	return newPath, nil
+}
+
+// majorPath returns the path of the given major version of the module with the
+// given path prefix (i.e. without a major version suffix). Most modules have a
+// /vN suffix from v2 onwards, but gopkg.in modules always have a .vN suffix
+// (see https://labix.org/gopkg.in).
+func majorPath(prefix string, major int) string {
+	if isGopkgIn(prefix) {
+		return fmt.Sprintf("%s.v%d", prefix, major)
+	}
+	if major < 2 {
+		return prefix
+	}
+	return fmt.Sprintf("%s/v%d", prefix, major)
+}
+
+func isGopkgIn(path string) bool {
+	return strings.HasPrefix(path, "gopkg.in/")
+}
+
+// pathMajorNumber returns the major version number implied by the module
+// path (1 if it has no major version suffix).
+func pathMajorNumber(path string) int {
+	_, pathMajor, ok := module.SplitPathVersion(path)
+	if !ok || pathMajor == "" {
+		return 1
+	}
+	num, err := strconv.Atoi(strings.TrimLeft(pathMajor, "/.v"))
+	if err != nil {
+		return 1
+	}
+	return num
+}
+
+// versionMajorNumber returns the major version number of the given version,
+// treating v0 the same as v1 (since both share the same module path).
+func versionMajorNumber(version string) int {
+	num, err := strconv.Atoi(strings.TrimPrefix(semver.Major(version), "v"))
+	if err != nil || num < 1 {
+		return 1
+	}
+	return num
+}
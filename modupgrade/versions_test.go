@@ -0,0 +1,251 @@
+package modupgrade
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeLister is a Lister that returns canned results, rather than shelling
+// out to 'go list'.
+type fakeLister struct {
+	results []Module
+	err     error
+}
+
+func (l fakeLister) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]Module, error) {
+	return l.results, l.err
+}
+
+func TestGetMinorUpdateVersion(t *testing.T) {
+	upgrader := New(".", WithLister(fakeLister{results: []Module{{
+		Path:    "github.com/some/dependency",
+		Version: "v1.2.3",
+		Update:  &Module{Version: "v1.4.0"},
+	}}}))
+
+	version, err := upgrader.MinorUpdateVersion(context.Background(), "github.com/some/dependency")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if version != "v1.4.0" {
+		t.Errorf("Expected version v1.4.0, got %s", version)
+	}
+}
+
+func TestGetMinorUpdateVersionError(t *testing.T) {
+	upgrader := New(".", WithLister(fakeLister{results: []Module{{
+		Path:  "github.com/some/dependency",
+		Error: &ModuleError{Err: "module not found"},
+	}}}))
+
+	_, err := upgrader.MinorUpdateVersion(context.Background(), "github.com/some/dependency")
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+
+	// The error must include both the module path and the underlying error
+	for _, want := range []string{"github.com/some/dependency", "module not found"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %s", want, err)
+		}
+	}
+}
+
+func TestGetMinorUpdateVersionNoResults(t *testing.T) {
+	upgrader := New(".", WithLister(fakeLister{}))
+
+	if _, err := upgrader.MinorUpdateVersion(context.Background(), "github.com/some/dependency"); err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+}
+
+func TestSearchStartMajor(t *testing.T) {
+	tests := []struct {
+		version string
+		want    int
+	}{
+		{"v0.1.0", 2},
+		{"v1.2.3", 2},
+		{"v2.0.0+incompatible", 3},
+		{"v5.1.0+incompatible", 6},
+		{"v0.0.0-20230601123456-abcdef012345", 2},
+		{"v1.2.4-0.20230601123456-abcdef012345", 2},
+		{"v3.0.1-0.20230601123456-abcdef012345+incompatible", 4},
+		{"v1.0.0-rc.1", 2},
+		{"", 2},
+		{"not-a-version", 2},
+	}
+	for _, test := range tests {
+		if got := searchStartMajor(test.version); got != test.want {
+			t.Errorf("searchStartMajor(%q): expected %d, got %d", test.version, test.want, got)
+		}
+	}
+}
+
+func TestUpgradePathGopkgIn(t *testing.T) {
+	tests := []struct {
+		path    string
+		version string
+		want    string
+	}{
+		{"gopkg.in/yaml.v2", "", "gopkg.in/yaml.v3"},
+		{"gopkg.in/yaml.v2", "v3.0.1", "gopkg.in/yaml.v3"},
+		{"gopkg.in/yaml.v3", "v1", "gopkg.in/yaml.v1"},
+		{"gopkg.in/src-d/go-git.v4", "", "gopkg.in/src-d/go-git.v5"},
+		{"github.com/foo/bar", "v1", "github.com/foo/bar"},
+		{"github.com/foo/bar/v2", "", "github.com/foo/bar/v3"},
+	}
+	for _, test := range tests {
+		got, err := UpgradePath(test.path, test.version)
+		if err != nil {
+			t.Errorf("UpgradePath(%q, %q): unexpected error: %s", test.path, test.version, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("UpgradePath(%q, %q): expected %s, got %s", test.path, test.version, test.want, got)
+		}
+	}
+}
+
+// countingLister counts the calls made to the Lister it wraps.
+type countingLister struct {
+	Lister
+	calls int
+}
+
+func (l *countingLister) ListModules(ctx context.Context, dir string, extraFlags []string, modulePaths ...string) ([]Module, error) {
+	l.calls++
+	return l.Lister.ListModules(ctx, dir, extraFlags, modulePaths...)
+}
+
+func TestUpgradeVersionsBinarySearch(t *testing.T) {
+	tests := []struct {
+		highest  int    // Highest available major version
+		errMsg   string // Error reported for missing versions
+		expected string // Highest upgrade version
+		maxCalls int
+	}{
+		{highest: 2, expected: "", maxCalls: 1},
+		{highest: 3, expected: "v3.0.0", maxCalls: 2},
+		{highest: 30, expected: "v30.0.0", maxCalls: 6},
+
+		// Beyond the highest probe, every major version is queried
+		{highest: 200, expected: "v200.0.0", maxCalls: 300},
+
+		// Errors other than missing versions also fall back to querying
+		// every major version (and the tolerated missing version after
+		// the highest one)
+		{highest: 5, errMsg: "module lookup disabled by GOPROXY=off", expected: "v5.0.0", maxCalls: 6},
+	}
+	for _, test := range tests {
+		errMsg := test.errMsg
+		if errMsg == "" {
+			errMsg = "no matching versions for query"
+		}
+		lister := &countingLister{Lister: listerFunc(func(query string) Module {
+			path, version, _ := strings.Cut(query, "@")
+			if major, _ := strconv.Atoi(strings.TrimPrefix(version, "v")); major <= test.highest {
+				return Module{Path: path, Version: version + ".0.0"}
+			}
+			return Module{Path: path, Error: &ModuleError{Err: errMsg}}
+		})}
+
+		u := New(".", WithLister(lister), WithBinarySearch(true))
+		versions, err := u.UpgradeVersions(context.Background(), "github.com/foo/bar/v2")
+		if err != nil {
+			t.Fatalf("Highest v%d: unexpected error: %s", test.highest, err)
+		}
+
+		var latest string
+		if len(versions) > 0 {
+			latest = LatestVersion(versions)
+		}
+		if latest != test.expected {
+			t.Errorf("Highest v%d: expected %q, got %q", test.highest, test.expected, latest)
+		}
+		if lister.calls > test.maxCalls {
+			t.Errorf("Highest v%d: expected at most %d calls, got %d", test.highest, test.maxCalls, lister.calls)
+		}
+	}
+}
+
+func TestUpgradeVersionsMaxGap(t *testing.T) {
+	// v3 was skipped, and v6 and v7 were too
+	available := map[string]bool{"v2": true, "v4": true, "v5": true, "v8": true}
+	lister := listerFunc(func(query string) Module {
+		path, version, _ := strings.Cut(query, "@")
+		if available[version] {
+			return Module{Path: path, Version: version + ".0.0"}
+		}
+		return Module{Path: path, Error: &ModuleError{Err: "no matching versions for query"}}
+	})
+
+	tests := []struct {
+		maxGap   int
+		expected []string
+	}{
+		{maxGap: 0, expected: nil},
+		{maxGap: 1, expected: []string{"v4.0.0", "v5.0.0"}},
+		{maxGap: 2, expected: []string{"v4.0.0", "v5.0.0", "v8.0.0"}},
+	}
+	for _, test := range tests {
+		u := New(".", WithLister(lister), WithMaxGap(test.maxGap))
+		versions, err := u.UpgradeVersions(context.Background(), "github.com/foo/bar/v2")
+		if err != nil {
+			t.Fatalf("Max gap %d: unexpected error: %s", test.maxGap, err)
+		}
+		if !reflect.DeepEqual(versions, test.expected) {
+			t.Errorf("Max gap %d: expected %v, got %v", test.maxGap, test.expected, versions)
+		}
+	}
+
+	// A single skipped major version is tolerated by default
+	versions, err := New(".", WithLister(lister)).UpgradeVersions(context.Background(), "github.com/foo/bar/v2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(versions, []string{"v4.0.0", "v5.0.0"}) {
+		t.Errorf("Expected v4.0.0 and v5.0.0 by default, got %v", versions)
+	}
+}
+
+func TestIncompatibleUpgradeVersion(t *testing.T) {
+	lister := listerFunc(func(query string) Module {
+		return Module{
+			Path:    "github.com/docker/docker",
+			Version: "v20.10.0+incompatible",
+			Update:  &Module{Version: "v27.1.0+incompatible"},
+		}
+	})
+	u := New(".", WithLister(lister))
+
+	tests := []struct {
+		current  string
+		expected string
+	}{
+		{current: "v20.10.0+incompatible", expected: "v27.1.0+incompatible"},
+		{current: "v27.0.0+incompatible", expected: ""}, // Same major version
+		{current: "v1.2.3", expected: ""},               // Not incompatible
+	}
+	for _, test := range tests {
+		version, err := u.IncompatibleUpgradeVersion(context.Background(), "github.com/docker/docker", test.current)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", test.current, err)
+		}
+		if version != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.current, test.expected, version)
+		}
+	}
+
+	// Upgrading to an incompatible version keeps the module's path
+	path, err := UpgradePath("github.com/docker/docker", "v27.1.0+incompatible")
+	if err != nil {
+		t.Fatalf("Unexpected error getting upgrade path: %s", err)
+	}
+	if path != "github.com/docker/docker" {
+		t.Errorf("Expected github.com/docker/docker, got %s", path)
+	}
+}
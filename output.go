@@ -0,0 +1,17 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// stdout is where all human-readable output is written. In JSON mode, it is
+// discarded, so that only the JSON report is written to standard output.
+var stdout io.Writer = os.Stdout
+
+// writeJSON writes the given value to standard output as a single line of
+// JSON (so that multiple values form newline-delimited JSON).
+func writeJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/modfile"
+)
+
+// pinDependencies marks every direct dependency in the go.mod file as pinned,
+// and returns their module paths. Pinned dependencies are skipped when
+// upgrading all dependencies. With -inline-comments (or by default, unless
+// -upgrade-yaml is given), a "// pinned: do not upgrade" comment is appended to
+// each require line. With -upgrade-yaml, the returned paths are added to the
+// ignore-module list in the module's .upgrade.yaml file, once the changes are
+// applied. The required versions themselves are left untouched.
+func pinDependencies(file *modfile.File) []string {
+	inline := *inlineComments || !*upgradeYAML
+
+	var paths []string
+	for _, require := range file.Require {
+		if require.Indirect || (modupgrade.IsPinned(require) && !*upgradeYAML) {
+			continue
+		}
+
+		if inline && !modupgrade.IsPinned(require) {
+			line := require.Syntax
+			if len(line.Suffix) == 0 {
+				line.Suffix = []modfile.Comment{{
+					Token:  "// " + modupgrade.PinnedComment,
+					Suffix: true,
+				}}
+			} else {
+				// Keep any existing comment, and append to it
+				com := &line.Suffix[0]
+				com.Token = fmt.Sprintf("%s; %s", strings.TrimSpace(com.Token), modupgrade.PinnedComment)
+			}
+		}
+
+		paths = append(paths, require.Mod.Path)
+		fmt.Fprintf(stdout, "Pinned %s %s\n", require.Mod.Path, require.Mod.Version)
+	}
+	return paths
+}
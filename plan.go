@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+)
+
+// plan describes all of the changes an upgrade will make, before any of them
+// are written to disk.
+type plan struct {
+	upgrades []upgrade
+	files    []file
+	summary  *upgradeSummary // Only when upgrading all dependencies
+}
+
+// upgradeSummary counts the outcomes of upgrading all dependencies.
+type upgradeSummary struct {
+	Checked  int           `json:"checked"`
+	Upgraded int           `json:"upgraded"`
+	Latest   int           `json:"latest"`
+	Skipped  int           `json:"skipped"`
+	Excluded int           `json:"excluded"`
+	Errors   int           `json:"errors"`
+	Elapsed  time.Duration `json:"elapsed_ns"`
+}
+
+func (s upgradeSummary) print() {
+	w := tabwriter.NewWriter(stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintln(w, "\nSummary:")
+	fmt.Fprintf(w, "\tChecked:\t%d\n", s.Checked)
+	fmt.Fprintf(w, "\tUpgraded:\t%d\n", s.Upgraded)
+	fmt.Fprintf(w, "\tAlready latest:\t%d\n", s.Latest)
+	fmt.Fprintf(w, "\tSkipped:\t%d\n", s.Skipped)
+	fmt.Fprintf(w, "\tExcluded:\t%d\n", s.Excluded)
+	fmt.Fprintf(w, "\tErrors:\t%d\n", s.Errors)
+	fmt.Fprintf(w, "\tElapsed:\t%s\n", s.Elapsed)
+	w.Flush()
+}
+
+// print prints a summary of the plan. If detailed is true, the files whose
+// imports will be rewritten are listed under each module upgrade.
+func (p plan) print(detailed bool) {
+	fmt.Fprintf(stdout, "\nUpgrade plan: %d module(s) to upgrade, %d file(s) to rewrite\n",
+		len(p.upgrades), len(p.files),
+	)
+	for _, upgrade := range p.upgrades {
+		fmt.Fprintf(stdout, "\t%s\n", upgrade)
+		if detailed {
+			for _, filename := range p.affectedFiles(upgrade) {
+				fmt.Fprintf(stdout, "\t\t%s\n", filename)
+			}
+		}
+	}
+}
+
+// affectedFiles returns the names of the files whose imports will be
+// rewritten by the given upgrade.
+func (p plan) affectedFiles(u upgrade) []string {
+	var filenames []string
+	for _, file := range p.files {
+		for _, rewrite := range file.rewrites {
+			if rewrite.ModulePath == u.OldPath {
+				filenames = append(filenames, file.name)
+				break
+			}
+		}
+	}
+	return filenames
+}
+
+// planReport is the JSON representation of a plan, as output in JSON mode.
+type planReport struct {
+	Module   string          `json:"module"`
+	Upgrades []upgradeReport `json:"upgrades"`
+	Summary  *upgradeSummary `json:"summary,omitempty"`
+}
+
+type upgradeReport struct {
+	OldPath    string       `json:"old_path"`
+	OldVersion string       `json:"old_version,omitempty"`
+	NewPath    string       `json:"new_path"`
+	NewVersion string       `json:"new_version,omitempty"`
+	Files      []fileReport `json:"files"`
+}
+
+type fileReport struct {
+	Name    string         `json:"name"`
+	Imports []importReport `json:"imports"`
+}
+
+type importReport struct {
+	OldPath string `json:"old_path"`
+	NewPath string `json:"new_path"`
+}
+
+// report returns the JSON representation of the plan for the given module.
+func (p plan) report(modulePath string) planReport {
+	report := planReport{
+		Module:   modulePath,
+		Upgrades: []upgradeReport{},
+		Summary:  p.summary,
+	}
+	for _, u := range p.upgrades {
+		upgradeReport := upgradeReport{
+			OldPath:    u.OldPath,
+			OldVersion: u.OldVersion,
+			NewPath:    u.NewPath,
+			NewVersion: u.NewVersion,
+			Files:      []fileReport{},
+		}
+		for _, file := range p.files {
+			fileReport := fileReport{Name: file.name}
+			for _, rewrite := range file.rewrites {
+				if rewrite.ModulePath == u.OldPath {
+					fileReport.Imports = append(fileReport.Imports, importReport{
+						OldPath: rewrite.OldImportPath,
+						NewPath: rewrite.NewImportPath,
+					})
+				}
+			}
+			if len(fileReport.Imports) > 0 {
+				upgradeReport.Files = append(upgradeReport.Files, fileReport)
+			}
+		}
+		report.Upgrades = append(report.Upgrades, upgradeReport)
+	}
+	return report
+}
+
+// checkReport is the JSON representation of an available upgrade, as output
+// in check mode. Deprecated and Retracted describe the upgrade version.
+type checkReport struct {
+	CurrentPath    string `json:"current_path"`
+	CurrentVersion string `json:"current_version"`
+	UpgradePath    string `json:"upgrade_path"`
+	UpgradeVersion string `json:"upgrade_version"`
+	Deprecated     bool   `json:"deprecated"`
+	Retracted      bool   `json:"retracted"`
+}
+
+// checkReport returns the JSON representation of the upgrades available, as
+// found in check mode.
+func (p plan) checkReport() []checkReport {
+	reports := []checkReport{}
+	for _, u := range p.upgrades {
+		reports = append(reports, checkReport{
+			CurrentPath:    u.OldPath,
+			CurrentVersion: u.OldVersion,
+			UpgradePath:    u.NewPath,
+			UpgradeVersion: u.NewVersion,
+			Deprecated:     u.Deprecated != "",
+			Retracted:      u.Retracted != "",
+		})
+	}
+	return reports
+}
+
+// stdin is shared by all prompts, so that buffered input isn't lost between
+// them (e.g. when answers are piped in).
+var stdin = bufio.NewReader(os.Stdin)
+
+// promptOutput is where prompts are written: stderr, rather than stdout, so
+// that they aren't mixed into the tool's output (e.g. with -json).
+var promptOutput io.Writer = os.Stderr
+
+// prompt asks the user the given question, and returns their answer.
+func prompt(question string) (string, error) {
+	fmt.Fprint(promptOutput, question)
+
+	prompting.Store(true)
+	defer prompting.Store(false)
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		return "", fmt.Errorf("error reading answer: %s", err)
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// confirm prompts the user with the given question, and reports whether they
+// answered yes. Anything other than "y" or "yes" is treated as no.
+func confirm(question string) (bool, error) {
+	answer, err := prompt(fmt.Sprintf("%s [y/N]: ", question))
+	if err != nil {
+		return false, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// confirmUpgrade asks the user whether to apply the given upgrade, skip it,
+// or quit (skipping it and all remaining upgrades). Anything other than "y",
+// "yes", "q" or "quit" is treated as skip.
+func confirmUpgrade(u upgrade) (modupgrade.Answer, error) {
+	answer, err := prompt(fmt.Sprintf("%s\nApply? [y/N/q]: ", u))
+	if err != nil {
+		return modupgrade.Skip, err
+	}
+
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return modupgrade.Apply, nil
+	case "q", "quit":
+		return modupgrade.Quit, nil
+	default:
+		return modupgrade.Skip, nil
+	}
+}
+
+// isTerminal reports whether the given file is a terminal (rather than, e.g.,
+// a pipe or regular file).
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// maxVersionChoices caps the number of versions presented to the user when
+// picking a version (only the highest versions are presented).
+const maxVersionChoices = 20
+
+// promptVersion presents a numbered menu of the given versions (in ascending
+// order), and returns the one the user chose. Returns an empty string if the
+// user chose not to upgrade.
+func promptVersion(path string, versions []string) (string, error) {
+	if len(versions) > maxVersionChoices {
+		versions = versions[len(versions)-maxVersionChoices:]
+	}
+
+	fmt.Fprintf(promptOutput, "Available versions of %s:\n", path)
+	for i, version := range versions {
+		if i == len(versions)-1 {
+			fmt.Fprintf(promptOutput, "\t%d) %s (latest)\n", i+1, version)
+		} else {
+			fmt.Fprintf(promptOutput, "\t%d) %s\n", i+1, version)
+		}
+	}
+	fmt.Fprintf(promptOutput, "\t0) Don't upgrade\n")
+
+	for {
+		answer, err := prompt(fmt.Sprintf("Select a version [0-%d, default %d]: ", len(versions), len(versions)))
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			return versions[len(versions)-1], nil
+		}
+
+		choice, err := strconv.Atoi(answer)
+		if err != nil || choice < 0 || choice > len(versions) {
+			fmt.Fprintf(promptOutput, "Invalid choice: %s\n", answer)
+			continue
+		}
+		if choice == 0 {
+			return "", nil
+		}
+		return versions[choice-1], nil
+	}
+}
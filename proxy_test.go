@@ -0,0 +1,587 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// TestMain runs the tool itself, instead of the tests, when asked to by
+// runMain (i.e. in a subprocess of the test binary).
+func TestMain(m *testing.M) {
+	if os.Getenv("UPGRADE_TEST_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the tool with the given arguments in a subprocess, so that
+// the way it exits (e.g. with log.Fatalf) can be tested. It returns the
+// tool's combined output, and its exit code.
+func runMain(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "UPGRADE_TEST_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("Error running tool: %s", err)
+	}
+	return string(out), 0
+}
+
+// proxyModule is a synthetic module version served by the fake module proxy.
+// Its files are given relative to the module root, and must include go.mod.
+type proxyModule struct {
+	path    string
+	version string
+	files   map[string]string
+}
+
+// newFakeProxy starts a server that implements the module proxy protocol
+// (https://go.dev/ref/mod#goproxy-protocol) for the given modules, and returns
+// its URL, for use as GOPROXY. Anything else is reported as not found, which
+// the go command treats as the module (or version) not existing.
+func newFakeProxy(t *testing.T, modules ...proxyModule) string {
+	t.Helper()
+
+	byPath := map[string]map[string]proxyModule{}
+	for _, m := range modules {
+		if byPath[m.path] == nil {
+			byPath[m.path] = map[string]proxyModule{}
+		}
+		byPath[m.path][m.version] = m
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escapedPath, file, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/@v/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		path, err := module.UnescapePath(escapedPath)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		versions := byPath[path]
+
+		if file == "list" {
+			var list []string
+			for version := range versions {
+				list = append(list, version)
+			}
+			sort.Slice(list, func(i, j int) bool { return semver.Compare(list[i], list[j]) < 0 })
+			for _, version := range list {
+				w.Write([]byte(version + "\n"))
+			}
+			return
+		}
+
+		ext := filepath.Ext(file)
+		escapedVersion := strings.TrimSuffix(file, ext)
+		version, err := module.UnescapeVersion(escapedVersion)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		m, ok := versions[version]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		switch ext {
+		case ".info":
+			json.NewEncoder(w).Encode(struct {
+				Version string
+				Time    time.Time
+			}{m.version, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+		case ".mod":
+			w.Write([]byte(m.files["go.mod"]))
+		case ".zip":
+			w.Write(moduleZip(t, m))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// moduleZip returns the module's files in the zip format served by module
+// proxies, in which each file is prefixed with "path@version/".
+func moduleZip(t *testing.T, m proxyModule) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, contents := range m.files {
+		f, err := zw.Create(m.path + "@" + m.version + "/" + name)
+		if err != nil {
+			t.Fatalf("Error creating zip file entry: %s", err)
+		}
+		if _, err := f.Write([]byte(contents)); err != nil {
+			t.Fatalf("Error writing zip file entry: %s", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Error closing zip file: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// depModule returns a version of the synthetic dependency used by the
+// integration tests, at the given module path.
+func depModule(path, version string) proxyModule {
+	return proxyModule{
+		path:    path,
+		version: version,
+		files: map[string]string{
+			"go.mod": "module " + path + "\n\ngo 1.22\n",
+			"dep.go": "package dep\n\nfunc Hello() string { return \"hello\" }\n",
+		},
+	}
+}
+
+// setupIntegrationTest writes the given files to a new module directory, and
+// points the go command at a fake module proxy serving the given modules (with
+// a fresh module cache, and the checksum database disabled, so that nothing
+// is fetched from the network). It returns the module directory.
+func setupIntegrationTest(t *testing.T, files map[string]string, modules ...proxyModule) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("Skipping integration test: go command not found")
+	}
+
+	t.Setenv("GOPROXY", newFakeProxy(t, modules...))
+	t.Setenv("GOMODCACHE", t.TempDir())
+	t.Setenv("GOFLAGS", "-modcacherw") // So that the module cache can be cleaned up
+	t.Setenv("GOSUMDB", "off")
+	t.Setenv("GONOSUMDB", "")
+	t.Setenv("GOPRIVATE", "")
+	t.Setenv("GOWORK", "off")
+	t.Setenv("GOTOOLCHAIN", "local")
+
+	dir := t.TempDir()
+	for name, contents := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatalf("Error creating directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(contents), 0644); err != nil {
+			t.Fatalf("Error writing file %s: %s", name, err)
+		}
+	}
+
+	// Populate go.sum, as a real module would have
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("Error tidying module: %s\n%s", err, out)
+	}
+	return dir
+}
+
+func readTestFile(t *testing.T, filename string) string {
+	t.Helper()
+
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error reading file %s: %s", filename, err)
+	}
+	return string(contents)
+}
+
+func TestIntegrationUpgradeDependency(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+			"app.go": "package app\n\nimport \"example.com/dep\"\n\nvar Greeting = dep.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+		depModule("example.com/dep/v3", "v3.0.0"),
+		depModule("example.com/dep/v3", "v3.1.0"),
+	)
+
+	run(context.Background(), dir, "example.com/dep", "", false)
+
+	goMod := readTestFile(t, filepath.Join(dir, "go.mod"))
+	if !strings.Contains(goMod, "example.com/dep/v3 v3.1.0") {
+		t.Errorf("Expected go.mod to require example.com/dep/v3 v3.1.0, got:\n%s", goMod)
+	}
+	if strings.Contains(goMod, "example.com/dep v1.0.0") {
+		t.Errorf("Expected go.mod to no longer require example.com/dep v1.0.0, got:\n%s", goMod)
+	}
+
+	src := readTestFile(t, filepath.Join(dir, "app.go"))
+	if !strings.Contains(src, `"example.com/dep/v3"`) {
+		t.Errorf("Expected import of example.com/dep to be rewritten to example.com/dep/v3, got:\n%s", src)
+	}
+
+	// The snapshot taken before the upgrade is removed once it succeeds
+	if _, err := os.Stat(filepath.Join(dir, snapshotDir)); !os.IsNotExist(err) {
+		t.Errorf("Expected snapshot directory to be removed after upgrade, got: %v", err)
+	}
+}
+
+func TestIntegrationUpgradeStaged(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+			"app.go": "package app\n\nimport \"example.com/dep\"\n\nvar Greeting = dep.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+		depModule("example.com/dep/v2", "v2.1.0"),
+		depModule("example.com/dep/v3", "v3.0.0"),
+	)
+
+	var out bytes.Buffer
+	orig := stdout
+	stdout = &out
+	t.Cleanup(func() { stdout = orig })
+
+	runStaged(context.Background(), dir, "example.com/dep", "", false)
+
+	for _, stage := range []string{
+		"Stage 1/2: upgrading example.com/dep to v2",
+		"example.com/dep v1.0.0 -> example.com/dep/v2 v2.1.0",
+		"Stage 2/2: upgrading example.com/dep/v2 to v3",
+		"example.com/dep/v2 v2.1.0 -> example.com/dep/v3 v3.0.0",
+	} {
+		if !strings.Contains(out.String(), stage) {
+			t.Errorf("Expected output to contain %q, got:\n%s", stage, out.String())
+		}
+	}
+
+	goMod := readTestFile(t, filepath.Join(dir, "go.mod"))
+	if !strings.Contains(goMod, "example.com/dep/v3 v3.0.0") || strings.Contains(goMod, "example.com/dep/v2") {
+		t.Errorf("Expected go.mod to require only example.com/dep/v3 v3.0.0, got:\n%s", goMod)
+	}
+
+	src := readTestFile(t, filepath.Join(dir, "app.go"))
+	if !strings.Contains(src, `"example.com/dep/v3"`) {
+		t.Errorf("Expected import of example.com/dep to be rewritten to example.com/dep/v3, got:\n%s", src)
+	}
+}
+
+func TestIntegrationUpgradeAllDependencies(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire (\n\texample.com/dep v1.0.0\n\texample.com/other v1.0.0\n)\n",
+			"app.go": "package app\n\nimport (\n\t\"example.com/dep\"\n\t\"example.com/other\"\n)\n\nvar Greeting = dep.Hello() + other.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+		proxyModule{
+			path:    "example.com/other",
+			version: "v1.0.0",
+			files: map[string]string{
+				"go.mod":   "module example.com/other\n\ngo 1.22\n",
+				"other.go": "package other\n\nfunc Hello() string { return \"other\" }\n",
+			},
+		},
+	)
+
+	orig := *concurrency
+	*concurrency = 1
+	t.Cleanup(func() { *concurrency = orig })
+
+	run(context.Background(), dir, "all", "", false)
+
+	goMod := readTestFile(t, filepath.Join(dir, "go.mod"))
+	if !strings.Contains(goMod, "example.com/dep/v2 v2.0.0") {
+		t.Errorf("Expected go.mod to require example.com/dep/v2 v2.0.0, got:\n%s", goMod)
+	}
+	// Dependencies without a higher major version are left as they are
+	if !strings.Contains(goMod, "example.com/other v1.0.0") {
+		t.Errorf("Expected go.mod to still require example.com/other v1.0.0, got:\n%s", goMod)
+	}
+
+	src := readTestFile(t, filepath.Join(dir, "app.go"))
+	if !strings.Contains(src, `"example.com/dep/v2"`) || !strings.Contains(src, `"example.com/other"`) {
+		t.Errorf("Expected only import of example.com/dep to be rewritten, got:\n%s", src)
+	}
+}
+
+func TestIntegrationTransitive(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+			"app.go": "package app\n\nimport \"example.com/dep\"\n\nvar Greeting = dep.Hello()\n",
+		},
+		proxyModule{
+			path:    "example.com/dep",
+			version: "v1.0.0",
+			files: map[string]string{
+				"go.mod": "module example.com/dep\n\ngo 1.22\n\nrequire example.com/lib v1.0.0\n",
+				"dep.go": "package dep\n\nfunc Hello() string { return \"hello\" }\n",
+			},
+		},
+		depModule("example.com/lib", "v1.0.0"),
+		depModule("example.com/lib/v2", "v2.0.0"),
+	)
+	// The dependency doesn't import example.com/lib, so it's in the module
+	// graph, but not in go.mod (as it would be if it were imported)
+
+	var out bytes.Buffer
+	orig := stdout
+	stdout = &out
+	t.Cleanup(func() { stdout = orig })
+
+	origConcurrency := *concurrency
+	*concurrency = 1
+	t.Cleanup(func() { *concurrency = origConcurrency })
+
+	*transitive = true
+	t.Cleanup(func() { *transitive = false })
+
+	run(context.Background(), dir, "all", "", false)
+
+	if !strings.Contains(out.String(), "example.com/lib v1.0.0 -> example.com/lib/v2 v2.0.0") {
+		t.Errorf("Expected transitive dependency example.com/lib to be reported, got:\n%s", out.String())
+	}
+
+	// Transitive dependencies are only reported, not upgraded
+	goMod := readTestFile(t, filepath.Join(dir, "go.mod"))
+	if strings.Contains(goMod, "example.com/lib") {
+		t.Errorf("Expected go.mod not to require example.com/lib, got:\n%s", goMod)
+	}
+}
+
+func TestIntegrationBuild(t *testing.T) {
+	dir := setupIntegrationTest(t, map[string]string{
+		"go.mod": "module example.com/app\n\ngo 1.22\n",
+		"app.go": "package app\n\nvar Greeting string = 42\n",
+	})
+
+	err := build(context.Background(), dir)
+	if err == nil {
+		t.Fatalf("Expected error building module that doesn't compile")
+	}
+	// The build errors are included, so that they can be printed
+	if !strings.Contains(err.Error(), "app.go:3") {
+		t.Errorf("Expected error to include build errors, got: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "app.go"), []byte("package app\n\nvar Greeting = \"hello\"\n"), 0644); err != nil {
+		t.Fatalf("Error writing file: %s", err)
+	}
+	if err := build(context.Background(), dir); err != nil {
+		t.Errorf("Unexpected error building module: %s", err)
+	}
+}
+
+func readAuditLog(t *testing.T, filename string) []auditEntry {
+	t.Helper()
+
+	var entries []auditEntry
+	if err := json.Unmarshal([]byte(readTestFile(t, filename)), &entries); err != nil {
+		t.Fatalf("Error decoding audit log: %s", err)
+	}
+	return entries
+}
+
+func TestIntegrationAuditLogOnError(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+			"app.go": "package app\n\nimport \"example.com/dep\"\n\nvar Greeting = dep.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+	)
+	auditFile := filepath.Join(t.TempDir(), "audit.json")
+
+	// There's no such version, so the upgrade fails after querying it
+	out, code := runMain(t, "-d", dir, "-audit-log", auditFile, "example.com/dep", "v5.0.0")
+	if code != 1 {
+		t.Fatalf("Expected exit code 1, got %d:\n%s", code, out)
+	}
+
+	if entries := readAuditLog(t, auditFile); len(entries) == 0 {
+		t.Errorf("Expected audit log to record the queries made before the error, got none")
+	}
+}
+
+func TestIntegrationAuditLogVersions(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+			"app.go": "package app\n\nimport \"example.com/dep\"\n\nvar Greeting = dep.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+	)
+	auditFile := filepath.Join(t.TempDir(), "audit.json")
+
+	out, code := runMain(t, "-d", dir, "-audit-log", auditFile, "versions", "example.com/dep")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out)
+	}
+
+	if entries := readAuditLog(t, auditFile); len(entries) == 0 {
+		t.Errorf("Expected audit log to record the queries made listing versions, got none")
+	}
+}
+
+func TestIntegrationAuditLogCompare(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire example.com/dep v1.0.0\n",
+			"app.go": "package app\n\nimport \"example.com/dep\"\n\nvar Greeting = dep.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+	)
+	auditFile := filepath.Join(t.TempDir(), "audit.json")
+
+	out, code := runMain(t, "-d", dir, "-audit-log", auditFile, "compare", "example.com/dep")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "+require example.com/dep/v2 v2.0.0") {
+		t.Errorf("Expected go.mod diff of the upgrade, got:\n%s", out)
+	}
+
+	if entries := readAuditLog(t, auditFile); len(entries) == 0 {
+		t.Errorf("Expected audit log to record the queries made comparing go.mod files, got none")
+	}
+}
+
+func TestIntegrationLoadPackagesCgo(t *testing.T) {
+	dir := setupIntegrationTest(t, map[string]string{
+		"go.mod":   "module example.com/app\n\ngo 1.22\n",
+		"app.go":   "package app\n\nvar Greeting = \"hello\"\n",
+		"cgo.go":   "package app\n\n// int answer(void);\nimport \"C\"\n\nvar Answer = C.answer()\n",
+		"answer.c": "int answer(void) { return 42; }\n",
+	})
+	// Without a C compiler, the package's cgo files can't be processed
+	t.Setenv("CGO_ENABLED", "1")
+	t.Setenv("CC", filepath.Join(t.TempDir(), "cc"))
+
+	_, err := loadPackages(context.Background(), dir)
+	if err == nil {
+		t.Fatalf("Expected error loading package that uses cgo")
+	}
+	if !strings.Contains(err.Error(), "try running with -cgo-enabled") {
+		t.Errorf("Expected error to suggest -cgo-enabled, got: %s", err)
+	}
+}
+
+// TestIntegrationCheckJSON checks the JSON report of the upgrades available
+// in check mode, including whether the upgrade version is deprecated, both
+// when the versions are looked up, and when they're served from -cache-dir.
+func TestIntegrationCheckJSON(t *testing.T) {
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": "module example.com/app\n\ngo 1.22\n\nrequire (\n\texample.com/dep v1.0.0\n\texample.com/other v1.0.0\n)\n",
+			"app.go": "package app\n\nimport (\n\t\"example.com/dep\"\n\t\"example.com/other\"\n)\n\nvar Greeting = dep.Hello() + other.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		proxyModule{
+			path:    "example.com/dep/v2",
+			version: "v2.0.0",
+			files: map[string]string{
+				"go.mod": "// Deprecated: use example.com/newdep instead.\nmodule example.com/dep/v2\n\ngo 1.22\n",
+				"dep.go": "package dep\n\nfunc Hello() string { return \"hello\" }\n",
+			},
+		},
+		proxyModule{
+			path:    "example.com/other",
+			version: "v1.0.0",
+			files: map[string]string{
+				"go.mod":   "module example.com/other\n\ngo 1.22\n",
+				"other.go": "package other\n\nfunc Hello() string { return \"other\" }\n",
+			},
+		},
+	)
+	cacheDir := t.TempDir()
+
+	for _, run := range []string{"looked up", "cached"} {
+		cmd := exec.Command(os.Args[0], "-d", dir, "-check", "-json", "-cache-dir", cacheDir)
+		cmd.Env = append(os.Environ(), "UPGRADE_TEST_MAIN=1")
+		out, err := cmd.Output()
+		if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 2 {
+			t.Fatalf("Expected exit code 2 with upgrades available (%s), got: %v\n%s", run, err, out)
+		}
+
+		// Only the JSON report is written to stdout
+		var reports []checkReport
+		if err := json.Unmarshal(out, &reports); err != nil {
+			t.Fatalf("Error decoding JSON report (%s): %s\n%s", run, err, out)
+		}
+		expected := []checkReport{{
+			CurrentPath:    "example.com/dep",
+			CurrentVersion: "v1.0.0",
+			UpgradePath:    "example.com/dep/v2",
+			UpgradeVersion: "v2.0.0",
+			Deprecated:     true,
+		}}
+		if !reflect.DeepEqual(reports, expected) {
+			t.Errorf("Expected JSON report %+v (%s), got %+v", expected, run, reports)
+		}
+	}
+}
+
+func TestIntegrationStrict(t *testing.T) {
+	const goMod = "module example.com/app\n\ngo 1.22\n\nrequire (\n\texample.com/dep v1.0.0\n\texample.com/other v1.0.0\n)\n"
+	dir := setupIntegrationTest(t,
+		map[string]string{
+			"go.mod": goMod,
+			"app.go": "package app\n\nimport (\n\t\"example.com/dep\"\n\t\"example.com/other\"\n)\n\nvar Greeting = dep.Hello() + other.Hello()\n",
+		},
+		depModule("example.com/dep", "v1.0.0"),
+		depModule("example.com/dep/v2", "v2.0.0"),
+		proxyModule{
+			path:    "example.com/other",
+			version: "v1.0.0",
+			files: map[string]string{
+				"go.mod":   "module example.com/other\n\ngo 1.22\n",
+				"other.go": "package other\n\nfunc Hello() string { return \"other\" }\n",
+			},
+		},
+	)
+
+	// example.com/other has no higher major version, so nothing is upgraded
+	out, code := runMain(t, "-d", dir, "-strict", "all")
+	if code != 1 || !strings.Contains(out, "No higher major version available for 1 module(s) (-strict):\n\texample.com/other") {
+		t.Fatalf("Expected -strict to fail for example.com/other, got exit code %d:\n%s", code, out)
+	}
+	if contents := readTestFile(t, filepath.Join(dir, "go.mod")); contents != goMod {
+		t.Errorf("Expected go.mod file to be unmodified, got:\n%s", contents)
+	}
+
+	// Excluded modules are exempt
+	out, code = runMain(t, "-d", dir, "-strict", "-exclude", "example.com/other", "all")
+	if code != 0 {
+		t.Fatalf("Expected excluded module to be exempt from -strict, got exit code %d:\n%s", code, out)
+	}
+	if contents := readTestFile(t, filepath.Join(dir, "go.mod")); !strings.Contains(contents, "example.com/dep/v2 v2.0.0") {
+		t.Errorf("Expected example.com/dep to be upgraded, got:\n%s", contents)
+	}
+}
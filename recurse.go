@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// findModuleDirs returns the directories of all modules within the given
+// directory (including the directory itself, if it's a module), in lexical
+// order. Like the go command, it ignores vendor and testdata directories, and
+// directories whose names begin with "." or "_".
+func findModuleDirs(dir string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (name == "vendor" || name == "testdata" ||
+				strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Name() == "go.mod" {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error searching for go.mod files: %s", err)
+	}
+	return dirs, nil
+}
+
+// upgradeRecursive performs the requested upgrade in every module within the
+// given directory, one at a time. Each module is upgraded by a separate
+// invocation of the tool (with the same flags and arguments), so that an error
+// in one module doesn't abort the upgrade of the others. Errors are reported
+// once all modules have been processed.
+func upgradeRecursive(ctx context.Context, dir string) {
+	moduleDirs, err := findModuleDirs(dir)
+	if err != nil {
+		fatalf("Error finding modules: %s", err)
+	}
+	if len(moduleDirs) == 0 {
+		fatalf("No go.mod files found in %s", dir)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		fatalf("Error finding executable: %s", err)
+	}
+
+	// Each module's upgrade applies its own -timeout
+	ctx, cancel := withoutTimeout(ctx)
+	defer cancel()
+
+	var failed []string
+	for _, moduleDir := range moduleDirs {
+		fmt.Fprintf(stdout, "Upgrading module %s\n", moduleDir)
+
+		cmd := exec.CommandContext(ctx, executable, moduleArgs(moduleDir)...)
+		cmd.Cancel = func() error {
+			// Let the module's upgrade stop cleanly, and report its progress
+			return cmd.Process.Signal(os.Interrupt)
+		}
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			// The module's upgrade has already reported its progress
+			if interrupted(ctx) {
+				exit(interruptedExitCode)
+			}
+			// In dry-run mode, exit status 3 means there was nothing to
+			// change, which is not an error
+			if exitErr, ok := err.(*exec.ExitError); ok && *dryRun && exitErr.ExitCode() == 3 {
+				continue
+			}
+			// In check mode, exit status 2 means there are upgrades
+			// available, which is reported once all modules are checked
+			if exitErr, ok := err.(*exec.ExitError); ok && *check && exitErr.ExitCode() == 2 {
+				upgradesAvailable = true
+				continue
+			}
+			// Otherwise, exit status 2 means there was nothing to upgrade
+			if exitErr, ok := err.(*exec.ExitError); ok && !*dryRun && !*check && exitErr.ExitCode() == 2 {
+				continue
+			}
+			failed = append(failed, fmt.Sprintf("%s: %s", moduleDir, err))
+			continue
+		}
+		dryRunChanges = true
+		upgradesApplied = true
+	}
+
+	if len(failed) > 0 {
+		fatalf("Errors upgrading %d of %d module(s):\n\t%s",
+			len(failed), len(moduleDirs), strings.Join(failed, "\n\t"),
+		)
+	}
+}
+
+// moduleArgs returns the command line arguments with which to upgrade a single
+// module: the same flags and arguments this invocation was given, but for the
+// given module directory, and without recursing.
+func moduleArgs(moduleDir string) []string {
+	args := []string{"-d", moduleDir}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "d", "recurse":
+			return
+		}
+		args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	})
+	return append(args, flag.Args()...)
+}
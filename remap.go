@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/modfile"
+)
+
+// parseRemap parses a -remap entry of the form "old=new" or "old=new@version"
+// into its old path, new module path, and (optional) version.
+func parseRemap(s string) (oldPath, newPath, version string, err error) {
+	oldPath, newPath, ok := strings.Cut(s, "=")
+	if !ok || oldPath == "" || newPath == "" {
+		return "", "", "", fmt.Errorf("invalid remap %q (must be of the form old=new, or old=new@version)", s)
+	}
+	newPath, version, _ = strings.Cut(newPath, "@")
+	return oldPath, newPath, version, nil
+}
+
+// readModuleMap reads the module path mappings in the given -module-map file,
+// and returns them as -remap entries. Each line holds an old path and a new
+// module path (optionally with a version), separated by whitespace, as in
+// 'go mod edit -replace' (e.g. "example.com/old example.com/new@v1.2.3").
+// Blank lines, and comments starting with "#", are ignored.
+func readModuleMap(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening module map file: %s", err)
+	}
+	defer f.Close()
+
+	var (
+		entries []string
+		scanner = bufio.NewScanner(f)
+	)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: invalid mapping %q (must be of the form 'old new', or 'old new@version')", filename, line, strings.TrimSpace(text))
+		}
+		entries = append(entries, fields[0]+"="+fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading module map file: %s", err)
+	}
+	return entries, nil
+}
+
+// remapModules applies the extra module path mappings given with -remap (and
+// -module-map) to the go.mod file, and returns them as upgrades, so that their
+// imports are rewritten in the same pass as the upgrades themselves.
+func remapModules(ctx context.Context, dir string, file *modfile.File) []upgrade {
+	upgrader := newUpgrader(dir, file)
+
+	var upgrades []upgrade
+	for _, remap := range remaps {
+		oldPath, newPath, version, err := parseRemap(remap)
+		if err != nil {
+			fatalf("Error remapping module: %s", err)
+		}
+
+		u, err := upgrader.Remap(ctx, oldPath, newPath, version)
+		if errors.Is(err, modupgrade.ErrRetracted) {
+			fatalf("Refusing to upgrade to retracted version (-strict): %s", err)
+		}
+		if err != nil {
+			fatalf("Error remapping %s to %s: %s", oldPath, newPath, err)
+		}
+
+		fmt.Fprintf(stdout, "%s -> %s %s\n", u.OldPath, u.NewPath, u.NewVersion)
+		checkRetraction(u)
+		upgrades = append(upgrades, u)
+	}
+	return upgrades
+}
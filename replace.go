@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// replacement is a replace directive to add to the go.mod file along with the
+// upgrade, given with -replace.
+type replacement struct {
+	oldPath, oldVersion string
+	newPath, newVersion string
+}
+
+// parseReplace parses a -replace entry of the form "old[@version]=new[@version]",
+// following the rules of 'go mod edit -replace': the new path is either a local
+// directory (which can't have a version), or a module path with a version.
+func parseReplace(s string) (replacement, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" || to == "" {
+		return replacement{}, fmt.Errorf("invalid replacement %q (must be of the form old[@version]=new[@version])", s)
+	}
+
+	var r replacement
+	r.oldPath, r.oldVersion, _ = strings.Cut(from, "@")
+	if err := checkReplacePath(r.oldPath, r.oldVersion); err != nil {
+		return replacement{}, fmt.Errorf("invalid replacement %q: %s", s, err)
+	}
+
+	r.newPath, r.newVersion, _ = strings.Cut(to, "@")
+	if modfile.IsDirectoryPath(r.newPath) {
+		if r.newVersion != "" {
+			return replacement{}, fmt.Errorf("invalid replacement %q: local directory can't have a version", s)
+		}
+		return r, nil
+	}
+	if r.newVersion == "" {
+		return replacement{}, fmt.Errorf("invalid replacement %q: unversioned new path must be a local directory (e.g. ./%s)", s, to)
+	}
+	if err := checkReplacePath(r.newPath, r.newVersion); err != nil {
+		return replacement{}, fmt.Errorf("invalid replacement %q: %s", s, err)
+	}
+	return r, nil
+}
+
+// checkReplacePath checks that the module path (and version, if given) of one
+// side of a replacement are valid.
+func checkReplacePath(path, version string) error {
+	if version == "" {
+		return module.CheckImportPath(path)
+	}
+	return module.Check(path, version)
+}
+
+// addReplacements adds the replace directives given with -replace to the
+// go.mod file (replacing any existing replacement of the same module version).
+func addReplacements(file *modfile.File) {
+	for _, s := range replaces {
+		r, err := parseReplace(s)
+		if err != nil {
+			fatalf("Error adding replacement: %s", err)
+		}
+		if err := file.AddReplace(r.oldPath, r.oldVersion, r.newPath, r.newVersion); err != nil {
+			fatalf("Error adding replacement of %s: %s", r.oldPath, err)
+		}
+		if *verbose {
+			from, to, _ := strings.Cut(s, "=")
+			fmt.Fprintf(stdout, "Replacing %s with %s\n", from, to)
+		}
+	}
+}
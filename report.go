@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+)
+
+// reportEntry is the record of a single upgrade in the report written with
+// -report.
+type reportEntry struct {
+	Time       time.Time `json:"time"`
+	Module     string    `json:"module"`
+	OldPath    string    `json:"old_path"`
+	OldVersion string    `json:"old_version,omitempty"`
+	NewPath    string    `json:"new_path"`
+	NewVersion string    `json:"new_version,omitempty"`
+	Files      []string  `json:"files"`
+	Warnings   []string  `json:"warnings,omitempty"`
+}
+
+var report struct {
+	sync.Mutex
+	loaded  bool
+	entries []reportEntry
+}
+
+// recordReport adds the upgrades of the given plan (which have just been
+// applied to the module) to the report, and rewrites the report file. The file
+// is rewritten after every module's upgrade, rather than once at the end, so
+// that it is complete up to that point even if the tool is interrupted.
+func recordReport(filePath, modulePath string, p plan) error {
+	report.Lock()
+	defer report.Unlock()
+
+	// Entries are appended to an existing report (e.g. one written by a
+	// previous run, or for another module when upgrading recursively)
+	if !report.loaded {
+		b, err := ioutil.ReadFile(filePath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error reading report %s: %s", filePath, err)
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &report.entries); err != nil {
+				return fmt.Errorf("error parsing report %s: %s", filePath, err)
+			}
+		}
+		report.loaded = true
+	}
+
+	now := time.Now()
+	for _, u := range p.upgrades {
+		files := p.affectedFiles(u)
+		if files == nil {
+			files = []string{}
+		}
+		report.entries = append(report.entries, reportEntry{
+			Time:       now,
+			Module:     modulePath,
+			OldPath:    u.OldPath,
+			OldVersion: u.OldVersion,
+			NewPath:    u.NewPath,
+			NewVersion: u.NewVersion,
+			Files:      files,
+			Warnings:   upgradeWarnings(u),
+		})
+	}
+
+	entries := report.entries
+	if entries == nil {
+		entries = []reportEntry{}
+	}
+	out, err := json.MarshalIndent(entries, "", "\t")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %s", err)
+	}
+
+	// The report is replaced atomically, so that it is always valid JSON
+	if err := modupgrade.WriteFile(filePath, append(out, '\n')); err != nil {
+		return fmt.Errorf("error writing report %s: %s", filePath, err)
+	}
+	return nil
+}
+
+// upgradeWarnings returns the warnings about the new version of the upgraded
+// module (whether it's retracted or deprecated).
+func upgradeWarnings(u upgrade) []string {
+	var warnings []string
+	if u.Retracted != "" {
+		warnings = append(warnings, fmt.Sprintf("retracted: %s", u.Retracted))
+	}
+	if u.Deprecated != "" {
+		warnings = append(warnings, fmt.Sprintf("deprecated: %s", u.Deprecated))
+	}
+	return warnings
+}
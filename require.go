@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// addRequirement adds a requirement on the given module to the go.mod file (in
+// memory), when it isn't required already and the -require flag is given, so
+// that it can then be upgraded like any other dependency. The module is
+// required at the version selected in the module graph, if it's a transitive
+// dependency, or else at its latest version, as 'go get' would.
+func addRequirement(ctx context.Context, dir string, file *modfile.File, path string) {
+	results, err := lister.ListModules(ctx, dir, listFlags(), path)
+	if err == nil && len(results) > 0 && results[0].Error != nil &&
+		strings.Contains(results[0].Error.Err, "not a known dependency") {
+		results, err = lister.ListModules(ctx, dir, listFlags(), path+"@latest")
+	}
+	if err != nil {
+		exitIfInterrupted(ctx)
+		fatalf("Error getting module info for %s: %s", path, err)
+	}
+	if len(results) == 0 {
+		fatalf("Error getting module info for %s: no module info returned", path)
+	}
+	if results[0].Error != nil {
+		fatalf("Error getting module info for %s: %s", path, results[0].Error.Err)
+	}
+
+	version := results[0].Version
+	if err := file.AddRequire(path, version); err != nil {
+		fatalf("Error adding requirement on %s: %s", path, err)
+	}
+	fmt.Fprintf(stdout, "Added requirement on %s %s (-require)\n", path, version)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// interruptedExitCode is the exit status when the tool is interrupted by a
+// signal (the same as a shell's for a command killed by SIGINT).
+const interruptedExitCode = 130
+
+// Progress of the upgrades, so that the user can be told how far the tool got
+// if it's interrupted. Upgrades are planned once their versions are known, and
+// applied once the go.mod file and rewritten imports have been written.
+var (
+	upgradesPlanned atomic.Int64
+	upgradesWritten atomic.Int64
+)
+
+// prompting is set while the tool waits for the user to answer a prompt.
+var prompting atomic.Bool
+
+// notifyInterrupt returns a context that is cancelled when the tool receives
+// SIGINT or SIGTERM, which in turn cancels any in-progress go commands. The
+// tool then stops at the next opportunity, rather than immediately, so that it
+// doesn't stop part way through writing the go.mod file and rewritten imports.
+// A second signal stops it immediately, as does a signal received while it's
+// waiting for the user to answer a prompt (since nothing is in progress).
+func notifyInterrupt() (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, func() {
+		stop()
+		if prompting.Load() {
+			fmt.Fprintln(os.Stderr) // The prompt's line is unfinished
+			exitInterrupted()
+		}
+	})
+	return ctx, stop
+}
+
+// interrupted reports whether the tool has been interrupted by a signal.
+func interrupted(ctx context.Context) bool {
+	return ctx.Err() == context.Canceled
+}
+
+// exitIfInterrupted exits the tool if it has been interrupted by a signal.
+// It's called where interruption would otherwise be reported as an error (or
+// go unnoticed), e.g. when a go command fails because it was cancelled.
+func exitIfInterrupted(ctx context.Context) {
+	if interrupted(ctx) {
+		exitInterrupted()
+	}
+}
+
+// exitInterrupted reports how many of the planned upgrades were applied before
+// the tool was interrupted, then exits.
+func exitInterrupted() {
+	if planned := upgradesPlanned.Load(); planned > 0 {
+		fmt.Fprintf(os.Stderr, "interrupted; %d/%d upgrades applied\n", upgradesWritten.Load(), planned)
+	} else {
+		fmt.Fprintln(os.Stderr, "interrupted")
+	}
+	exit(interruptedExitCode)
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// sinceDate is the date given with -since, if any.
+var sinceDate time.Time
+
+// parseSince parses the date given with -since (e.g. 2024-01-01).
+func parseSince(value string) (time.Time, error) {
+	date, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (must be of the form YYYY-MM-DD)", value)
+	}
+	return date, nil
+}
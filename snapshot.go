@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+)
+
+// snapshotDir is the directory, relative to the module root, in which
+// snapshots of the files modified by an upgrade are stored until it succeeds.
+const snapshotDir = ".upgrade-snapshot"
+
+// snapshotManifestFile is the name of the file, within each snapshot, that
+// lists the files in the snapshot.
+const snapshotManifestFile = "manifest.json"
+
+// snapshot holds the original contents of a set of files, so that they can be
+// restored if necessary. Files that did not exist are recorded as nil.
+type snapshot map[string][]byte
+
+type snapshotManifest struct {
+	Created time.Time      `json:"created"`
+	Files   []snapshotFile `json:"files"`
+}
+
+type snapshotFile struct {
+	Path    string `json:"path"` // Relative to the module root
+	Existed bool   `json:"existed"`
+}
+
+func takeSnapshot(filenames ...string) (snapshot, error) {
+	snap := snapshot{}
+	for _, filename := range filenames {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading file %s: %s", filename, err)
+		}
+		snap[filename] = b
+	}
+	return snap, nil
+}
+
+func (s snapshot) restore() error {
+	for filename, b := range s {
+		if b == nil {
+			if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("error removing file %s: %s", filename, err)
+			}
+			continue
+		}
+		if err := modupgrade.WriteFile(filename, b); err != nil {
+			return fmt.Errorf("error restoring file %s: %s", filename, err)
+		}
+	}
+	return nil
+}
+
+// snapshotFiles returns the files that must be snapshotted before an upgrade
+// in order to be able to roll it back.
+func snapshotFiles(dir string, files []file) []string {
+	filenames := []string{
+		filepath.Join(dir, "go.mod"),
+		filepath.Join(dir, "go.sum"),
+	}
+	if *vendor && hasVendorDir(dir) {
+		filenames = append(filenames, vendorModulesFile(dir))
+	}
+	if *upgradeYAML {
+		filenames = append(filenames, filepath.Join(dir, configFileName))
+	}
+	for _, file := range files {
+		filenames = append(filenames, file.name)
+	}
+	return filenames
+}
+
+// save writes the snapshot to a new directory within the module's snapshot
+// directory (named after the current time), along with a manifest of the files
+// it contains, and returns the path of the new directory.
+func (s snapshot) save(dir string) (string, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("error getting absolute path of module directory: %s", err)
+	}
+
+	now := time.Now()
+	snapDir := filepath.Join(dir, snapshotDir, now.UTC().Format("20060102T150405.000000000Z"))
+
+	manifest := snapshotManifest{Created: now}
+	for filename, b := range s {
+		absFilename, err := filepath.Abs(filename)
+		if err != nil {
+			return "", fmt.Errorf("error getting absolute path of file %s: %s", filename, err)
+		}
+		rel, err := filepath.Rel(absDir, absFilename)
+		if err != nil {
+			return "", fmt.Errorf("error getting path of file %s relative to module directory: %s", filename, err)
+		}
+		manifest.Files = append(manifest.Files, snapshotFile{Path: rel, Existed: b != nil})
+		if b == nil {
+			continue
+		}
+
+		filePath := filepath.Join(snapDir, "files", rel)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return "", fmt.Errorf("error creating snapshot directory: %s", err)
+		}
+		if err := ioutil.WriteFile(filePath, b, 0644); err != nil {
+			return "", fmt.Errorf("error writing snapshot file %s: %s", filePath, err)
+		}
+	}
+	sort.Slice(manifest.Files, func(i, j int) bool {
+		return manifest.Files[i].Path < manifest.Files[j].Path
+	})
+
+	out, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return "", fmt.Errorf("error encoding snapshot manifest: %s", err)
+	}
+	if err := os.MkdirAll(snapDir, 0755); err != nil {
+		return "", fmt.Errorf("error creating snapshot directory: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(snapDir, snapshotManifestFile), append(out, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("error writing snapshot manifest: %s", err)
+	}
+	return snapDir, nil
+}
+
+// loadSnapshot reads the snapshot saved in the given snapshot directory of the
+// module in the given directory.
+func loadSnapshot(dir, snapDir string) (snapshot, error) {
+	b, err := ioutil.ReadFile(filepath.Join(snapDir, snapshotManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading snapshot manifest: %s", err)
+	}
+
+	var manifest snapshotManifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing snapshot manifest: %s", err)
+	}
+
+	snap := snapshot{}
+	for _, f := range manifest.Files {
+		filename := filepath.Join(dir, f.Path)
+		if !f.Existed {
+			snap[filename] = nil
+			continue
+		}
+
+		filePath := filepath.Join(snapDir, "files", f.Path)
+		b, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading snapshot file %s: %s", filePath, err)
+		}
+		snap[filename] = b
+	}
+	return snap, nil
+}
+
+// latestSnapshot returns the directory of the most recent snapshot saved for
+// the module in the given directory, or an empty string if there is none.
+func latestSnapshot(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, snapshotDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("error reading snapshot directory: %s", err)
+	}
+
+	// Snapshot directories are named after the time they were taken, so the
+	// last one (in lexical order) is the most recent
+	var latest string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() > latest {
+			latest = entry.Name()
+		}
+	}
+	if latest == "" {
+		return "", nil
+	}
+	return filepath.Join(dir, snapshotDir, latest), nil
+}
+
+// removeSnapshot removes every snapshot saved for the module in the given
+// directory.
+func removeSnapshot(dir string) error {
+	return os.RemoveAll(filepath.Join(dir, snapshotDir))
+}
+
+// rollback restores the files in the module in the given directory to their
+// state before the most recent upgrade that didn't complete successfully,
+// using the snapshot taken before it modified anything.
+func rollback(dir string) error {
+	snapDir, err := latestSnapshot(dir)
+	if err != nil {
+		return err
+	}
+	if snapDir == "" {
+		return fmt.Errorf("no snapshot to roll back to in %s", filepath.Join(dir, snapshotDir))
+	}
+
+	snap, err := loadSnapshot(dir, snapDir)
+	if err != nil {
+		return err
+	}
+	if err := snap.restore(); err != nil {
+		return err
+	}
+	if err := removeSnapshot(dir); err != nil {
+		return fmt.Errorf("error removing snapshot: %s", err)
+	}
+
+	fmt.Fprintf(stdout, "Restored %d file(s) from snapshot %s\n", len(snap), snapDir)
+	return nil
+}
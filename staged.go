@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/semver"
+)
+
+// runStaged upgrades the dependency one major version at a time (e.g. v1 to
+// v2, then v2 to v3), rather than straight to the highest major version (or
+// the given version), so that each stage is applied (and, with -build,
+// checked) separately. Major versions with only pre-releases are skipped,
+// unless they are the last stage.
+func runStaged(ctx context.Context, dir, path, version string, migrating bool) {
+	file := readModFile(dir)
+	if path == file.Module.Mod.Path {
+		run(ctx, dir, path, version, migrating) // Upgrading the module itself
+		return
+	}
+
+	versions, err := newUpgrader(dir, file).UpgradeVersions(ctx, path)
+	if err != nil {
+		exitIfInterrupted(ctx)
+		fatalf("Error finding upgrade versions: %s", err)
+	}
+	stages := upgradeStages(versions, version)
+	if len(stages) == 0 {
+		// Nothing to stage, so upgrade (or report why not) as usual
+		run(ctx, dir, path, version, migrating)
+		return
+	}
+
+	for i, stage := range stages {
+		fmt.Fprintf(stdout, "Stage %d/%d: upgrading %s to %s\n", i+1, len(stages), path, semver.Major(stage))
+
+		// Only the last stage is migrated, since the build and tests of
+		// intermediate stages aren't expected to pass
+		run(ctx, dir, path, stage, migrating && i == len(stages)-1)
+
+		path, err = modupgrade.UpgradePath(path, stage)
+		if err != nil {
+			fatalf("Error upgrading module path %s to %s: %s", path, stage, err)
+		}
+	}
+}
+
+// upgradeStages returns the versions to upgrade to in turn, given the
+// available upgrade versions (in ascending order, one per major version), and
+// the requested version, if any. Each stage is the highest version of its
+// major version, except the last, which is the requested version itself.
+func upgradeStages(versions []string, version string) []string {
+	if len(versions) == 0 {
+		return nil
+	}
+
+	target := version
+	if target == "" {
+		target = modupgrade.LatestVersion(versions)
+	}
+
+	var stages []string
+	for _, v := range versions {
+		if semver.Compare(semver.Major(v), semver.Major(target)) >= 0 {
+			break
+		}
+		if semver.Prerelease(v) == "" {
+			stages = append(stages, v)
+		}
+	}
+	return append(stages, target)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/modfile"
+)
+
+// reportTransitiveUpgrades reports the modules in the module's full dependency
+// graph (as listed by 'go list -m all') that have a higher major version
+// available, but aren't required in its go.mod file. These are only imported
+// by the module's dependencies, so can't be upgraded by the module itself, but
+// can hold it back (e.g. from adopting a dependency's new API, if the
+// dependency's own dependencies lag behind).
+func reportTransitiveUpgrades(ctx context.Context, dir string, file *modfile.File, required map[string]string) {
+	results, err := lister.ListModules(ctx, dir, nil, "all")
+	if err != nil {
+		exitIfInterrupted(ctx)
+		fatalf("Error listing transitive dependencies: %s", err)
+	}
+
+	var modules []modupgrade.Module
+	for _, result := range results {
+		if result.Main || result.Error != nil || workspaceModules[result.Path] {
+			continue
+		}
+		if _, ok := required[result.Path]; ok {
+			continue // Upgraded (or not) along with the direct dependencies
+		}
+		if len(filterModules) > 0 && !filterModules.match(result.Path) ||
+			ignoreModules.match(result.Path) {
+			continue
+		}
+		modules = append(modules, result)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+
+	// As with the direct dependencies, the lookups are made concurrently
+	upgrader := newUpgrader(dir, file)
+	var (
+		versions = make([][]string, len(modules))
+		errs     = make([]error, len(modules))
+		indexes  = make(chan int)
+		wg       = sync.WaitGroup{}
+	)
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if *verbose {
+					fmt.Fprintf(stdout, "Fetching %s (transitive)\n", modules[i].Path)
+				}
+				versions[i], errs[i] = upgrader.UpgradeVersions(ctx, modules[i].Path)
+			}
+		}()
+	}
+	for i := range modules {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	exitIfInterrupted(ctx)
+
+	var found int
+	for i, m := range modules {
+		if errs[i] != nil {
+			log.Printf("Error getting upgrade version for transitive dependency %s: %s", m.Path, errs[i])
+			continue
+		}
+		if len(versions[i]) == 0 {
+			continue
+		}
+
+		version := modupgrade.LatestVersion(versions[i])
+		newPath, err := modupgrade.UpgradePath(m.Path, version)
+		if err != nil {
+			fatalf("Error upgrading module path %s to %s: %s", m.Path, version, err)
+		}
+		if found == 0 {
+			fmt.Fprintln(stdout, "Transitive dependencies with a higher major version (required by dependencies, not upgraded):")
+		}
+		found++
+		fmt.Fprintf(stdout, "\t%s %s -> %s %s\n", m.Path, m.Version, newPath, version)
+	}
+	if found == 0 && *verbose {
+		fmt.Fprintln(stdout, "No transitive dependencies with a higher major version")
+	}
+}
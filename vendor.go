@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+)
+
+// vendorModulesFile returns the path of the vendor/modules.txt file in the
+// module directory.
+func vendorModulesFile(dir string) string {
+	return filepath.Join(dir, "vendor", "modules.txt")
+}
+
+// hasVendorDir reports whether the module has a vendor directory.
+func hasVendorDir(dir string) bool {
+	_, err := os.Stat(vendorModulesFile(dir))
+	return err == nil
+}
+
+// readVendorModules returns the paths of the modules listed in the
+// vendor/modules.txt file.
+func readVendorModules(dir string) ([]string, error) {
+	b, err := ioutil.ReadFile(vendorModulesFile(dir))
+	if err != nil {
+		return nil, fmt.Errorf("error reading vendor modules file: %s", err)
+	}
+
+	var modules []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "# ") {
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				modules = append(modules, fields[1])
+			}
+		}
+	}
+	return modules, nil
+}
+
+// vendorModulePath returns the path of the vendored module the given import
+// path belongs to (i.e. the longest matching module path), or an empty string
+// if it doesn't belong to any of them.
+func vendorModulePath(modules []string, importPath string) string {
+	var modulePath string
+	for _, m := range modules {
+		if modupgrade.BelongsToModule(importPath, m) && len(m) > len(modulePath) {
+			modulePath = m
+		}
+	}
+	return modulePath
+}
+
+// rewriteVendorImports rewrites the import paths affected by the given
+// upgrades in the .go files in the module's vendor directory. Vendored files
+// can't be loaded as packages, so they are parsed directly instead. As with
+// rewriteImports, the files are only modified in memory.
+func rewriteVendorImports(dir string, upgrades []upgrade) ([]file, error) {
+	upgradeMap := map[string]string{}
+	for _, upgrade := range upgrades {
+		if upgrade.NewPath != upgrade.OldPath {
+			upgradeMap[upgrade.OldPath] = upgrade.NewPath
+		}
+	}
+	if len(upgradeMap) == 0 {
+		return nil, nil
+	}
+
+	modules, err := readVendorModules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var modified []file
+	err = filepath.WalkDir(filepath.Join(dir, "vendor"), func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(filename, ".go") {
+			return nil
+		}
+
+		fset := token.NewFileSet()
+		fileAST, err := parser.ParseFile(fset, filename, nil, parser.ParseComments)
+		if err != nil {
+			return fmt.Errorf("error parsing file %s: %s", filename, err)
+		}
+
+		var rewrites []rewrite
+		for _, fileImp := range fileAST.Imports {
+			importPath := strings.Trim(fileImp.Path.Value, "\"")
+
+			// As in rewriteImports, compare module paths rather than import
+			// path prefixes, using the modules listed in modules.txt
+			modulePath := vendorModulePath(modules, importPath)
+			newPath, ok := upgradeMap[modulePath]
+			if !ok {
+				continue
+			}
+
+			if len(rewrites) == 0 && (*verbose || *dryRun && !*showDiff) {
+				fmt.Fprintf(stdout, "%s:\n", filename)
+			}
+
+			newImportPath := strings.Replace(importPath, modulePath, newPath, 1)
+			fileImp.Path.Value = fmt.Sprintf("\"%s\"", newImportPath)
+			rewrites = append(rewrites, rewrite{
+				ModulePath:    modulePath,
+				OldImportPath: importPath,
+				NewImportPath: newImportPath,
+			})
+
+			if *verbose || *dryRun {
+				fmt.Fprintf(stdout, "\t%s -> %s\n", importPath, newImportPath)
+			}
+		}
+
+		if len(rewrites) > 0 {
+			modified = append(modified, file{
+				name:     filename,
+				ast:      fileAST,
+				fset:     fset,
+				rewrites: rewrites,
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error rewriting vendored files: %s", err)
+	}
+
+	return modified, nil
+}
+
+// writeVendorModules updates the module paths and versions in the
+// vendor/modules.txt file to reflect the given upgrades.
+func writeVendorModules(dir string, upgrades []upgrade) error {
+	filePath := vendorModulesFile(dir)
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("error reading vendor modules file: %s", err)
+	}
+
+	upgradeMap := map[string]upgrade{}
+	for _, upgrade := range upgrades {
+		upgradeMap[upgrade.OldPath] = upgrade
+	}
+
+	lines := strings.Split(string(b), "\n")
+	var current *upgrade // Upgrade of the module whose packages are listed
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			// Module annotations (e.g. "## explicit") are left as-is
+		case strings.HasPrefix(line, "# "):
+			// Module line: "# path version" (possibly followed by a
+			// replacement, which is left as-is)
+			current = nil
+			fields := strings.Fields(line)
+			if len(fields) < 3 {
+				continue
+			}
+			if u, ok := upgradeMap[fields[1]]; ok {
+				current = &u
+				fields[1] = u.NewPath
+				if u.NewVersion != "" {
+					fields[2] = u.NewVersion
+				}
+				lines[i] = strings.Join(fields, " ")
+			}
+		case current != nil && (line == current.OldPath || strings.HasPrefix(line, current.OldPath+"/")):
+			// Package line
+			lines[i] = current.NewPath + strings.TrimPrefix(line, current.OldPath)
+		}
+	}
+
+	if err := modupgrade.WriteFile(filePath, []byte(strings.Join(lines, "\n"))); err != nil {
+		return fmt.Errorf("error writing vendor modules file %s: %s", filePath, err)
+	}
+	return nil
+}
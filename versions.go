@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/module"
+)
+
+// printVersions prints the latest version of every available major version
+// of the given module, from its current major version upwards, along with the
+// time each was released, and whether it is retracted or deprecated.
+func printVersions(ctx context.Context, dir, path string) {
+	if err := module.CheckPath(path); err != nil {
+		fatalf("Invalid module path %s: %s", path, err)
+	}
+
+	// Every major version is listed, including those with only pre-releases
+	upgrader := newUpgrader(dir, nil,
+		modupgrade.WithBinarySearch(false),
+		modupgrade.WithPrerelease(true),
+	)
+
+	current, err := upgrader.MinorUpdateVersion(ctx, path)
+	if err != nil {
+		fatalf("Error getting current major version of %s: %s", path, err)
+	}
+	versions, err := upgrader.UpgradeVersions(ctx, path)
+	if err != nil {
+		fatalf("Error finding major versions of %s: %s", path, err)
+	}
+	versions = append([]string{current}, versions...)
+
+	// Query each version again for its details, which the major version
+	// search doesn't keep
+	var queries []string
+	for _, version := range versions {
+		modulePath, err := modupgrade.UpgradePath(path, version)
+		if err != nil {
+			fatalf("Error getting module path of %s %s: %s", path, version, err)
+		}
+		queries = append(queries, modulePath+"@"+version)
+	}
+	results, err := lister.ListModules(ctx, dir, []string{"-retracted"}, queries...)
+	if err != nil {
+		fatalf("Error getting module info: %s", err)
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	for _, result := range results {
+		if result.Error != nil {
+			fatalf("Error getting module info for %s: %s", result.Path, result.Error.Err)
+		}
+
+		var released string
+		if result.Time != nil {
+			released = result.Time.Format("2006-01-02")
+		}
+
+		var notes []string
+		if len(result.Retracted) > 0 {
+			notes = append(notes, fmt.Sprintf("retracted: %s", strings.Join(result.Retracted, "; ")))
+		}
+		if result.Deprecated != "" {
+			notes = append(notes, fmt.Sprintf("deprecated: %s", result.Deprecated))
+		}
+		if len(notes) > 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Path, result.Version, released, strings.Join(notes, ", "))
+		} else {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", result.Path, result.Version, released)
+		}
+	}
+	w.Flush()
+}
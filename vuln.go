@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/nathanjcochran/upgrade/modupgrade"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// vulnDBIndex is the index of modules with known vulnerabilities, as served
+// at $GOVULNDB/index/modules.json.
+type vulnDBIndex []struct {
+	Path  string `json:"path"`
+	Vulns []struct {
+		ID    string `json:"id"`
+		Fixed string `json:"fixed"`
+	} `json:"vulns"`
+}
+
+// osvEntry is a vulnerability report in the OSV format, as served at
+// $GOVULNDB/ID/$id.json (only the fields used here are included).
+type osvEntry struct {
+	ID       string   `json:"id"`
+	Aliases  []string `json:"aliases"`
+	Summary  string   `json:"summary"`
+	Affected []struct {
+		Package struct {
+			Name string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string `json:"type"`
+			Events []struct {
+				Introduced string `json:"introduced"`
+				Fixed      string `json:"fixed"`
+			} `json:"events"`
+		} `json:"ranges"`
+	} `json:"affected"`
+}
+
+// affects reports whether the given version of the module is affected by the
+// vulnerability. If it is, it also returns the version that fixes it (within
+// the same module path), if there is one.
+func (e osvEntry) affects(path, version string) (bool, string) {
+	for _, affected := range e.Affected {
+		if affected.Package.Name != path {
+			continue
+		}
+		for _, r := range affected.Ranges {
+			if r.Type != "SEMVER" {
+				continue
+			}
+
+			// Events alternate between introducing and fixing the
+			// vulnerability, in ascending order
+			var introduced bool
+			for _, event := range r.Events {
+				switch {
+				case event.Introduced != "":
+					if event.Introduced == "0" || semver.Compare(version, "v"+event.Introduced) >= 0 {
+						introduced = true
+					}
+				case event.Fixed != "":
+					fixed := "v" + event.Fixed
+					if semver.Compare(version, fixed) < 0 {
+						if introduced {
+							return true, fixed
+						}
+					} else {
+						introduced = false
+					}
+				}
+			}
+			if introduced {
+				return true, ""
+			}
+		}
+	}
+	return false, ""
+}
+
+func (e osvEntry) String() string {
+	for _, alias := range e.Aliases {
+		if strings.HasPrefix(alias, "CVE-") {
+			return fmt.Sprintf("%s (%s)", e.ID, alias)
+		}
+	}
+	return e.ID
+}
+
+func vulnDB() string {
+	if db := os.Getenv("GOVULNDB"); db != "" {
+		return strings.TrimSuffix(db, "/")
+	}
+	return "https://vuln.go.dev"
+}
+
+func fetchJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request for %s: %s", url, err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error fetching %s: %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("error decoding %s: %s", url, err)
+	}
+	return nil
+}
+
+// reportVulnerabilities cross-references the module's direct dependencies
+// with the Go vulnerability database, and reports each known vulnerability
+// affecting them, along with the version that fixes it (which may be a higher
+// major version).
+func reportVulnerabilities(ctx context.Context, dir string, file *modfile.File) {
+	db := vulnDB()
+
+	var index vulnDBIndex
+	if err := fetchJSON(ctx, db+"/index/modules.json", &index); err != nil {
+		fatalf("Error fetching vulnerability database index: %s", err)
+	}
+	vulnIDs := map[string][]string{}
+	for _, module := range index {
+		for _, vuln := range module.Vulns {
+			vulnIDs[module.Path] = append(vulnIDs[module.Path], vuln.ID)
+		}
+	}
+
+	var found bool
+	for _, require := range file.Require {
+		if require.Indirect {
+			continue
+		}
+		path, version := require.Mod.Path, require.Mod.Version
+
+		for _, id := range vulnIDs[path] {
+			var entry osvEntry
+			if err := fetchJSON(ctx, fmt.Sprintf("%s/ID/%s.json", db, id), &entry); err != nil {
+				fatalf("Error fetching vulnerability %s: %s", id, err)
+			}
+
+			affected, fixed := entry.affects(path, version)
+			if !affected {
+				continue
+			}
+			found = true
+
+			fixedPath := path
+			if fixed == "" {
+				// If there's no fix within the current major version, check
+				// whether any of the higher major versions are unaffected
+				var err error
+				fixedPath, fixed, err = findMajorVersionFix(ctx, dir, path, entry)
+				if err != nil {
+					fatalf("Error finding upgrade versions for module %s: %s", path, err)
+				}
+			}
+
+			switch {
+			case fixed == "":
+				fmt.Fprintf(stdout, "%s %s has %s, no fixed version available\n", path, version, entry)
+			case fixedPath == path:
+				fmt.Fprintf(stdout, "%s %s has %s, fixed in %s\n", path, version, entry, fixed)
+			default:
+				fmt.Fprintf(stdout, "%s %s has %s, fixed in %s %s\n", path, version, entry, fixedPath, fixed)
+			}
+
+			if *jsonOutput {
+				report := vulnReport{
+					Path:    path,
+					Version: version,
+					ID:      entry.ID,
+					Aliases: entry.Aliases,
+					Summary: entry.Summary,
+				}
+				if fixed != "" {
+					report.FixedPath, report.FixedVersion = fixedPath, fixed
+				}
+				if err := writeJSON(report); err != nil {
+					fatalf("Error writing JSON report: %s", err)
+				}
+			}
+		}
+	}
+
+	if !found {
+		fmt.Fprintln(stdout, "No known vulnerabilities found in direct dependencies")
+	}
+}
+
+// vulnReport is the JSON representation of a vulnerability affecting a
+// dependency, as output in JSON mode.
+type vulnReport struct {
+	Path         string   `json:"path"`
+	Version      string   `json:"version"`
+	ID           string   `json:"id"`
+	Aliases      []string `json:"aliases,omitempty"`
+	Summary      string   `json:"summary,omitempty"`
+	FixedPath    string   `json:"fixed_path,omitempty"`
+	FixedVersion string   `json:"fixed_version,omitempty"`
+}
+
+// findMajorVersionFix returns the path and version of the lowest available
+// higher major version of the module that is not affected by the given
+// vulnerability, or empty strings if there isn't one.
+func findMajorVersionFix(ctx context.Context, dir, path string, entry osvEntry) (string, string, error) {
+	versions, err := newUpgrader(dir, nil).UpgradeVersions(ctx, path)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, version := range versions {
+		newPath, err := modupgrade.UpgradePath(path, version)
+		if err != nil {
+			return "", "", err
+		}
+		if affected, _ := entry.affects(newPath, version); !affected {
+			return newPath, version, nil
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+func isWorkspaceRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "go.work"))
+	return err == nil
+}
+
+// findWorkFile returns the path of the go.work file that puts the go command
+// in workspace mode in the given directory, found as the go command finds it:
+// the one named by GOWORK, if set, or else the first one in the directory or
+// one of its parents. It returns "" if workspace mode is off.
+func findWorkFile(dir string) string {
+	switch gowork := os.Getenv("GOWORK"); gowork {
+	case "off":
+		return ""
+	case "", "auto":
+	default:
+		return gowork
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if isWorkspaceRoot(absDir) {
+			return filepath.Join(absDir, "go.work")
+		}
+		parent := filepath.Dir(absDir)
+		if parent == absDir {
+			return ""
+		}
+		absDir = parent
+	}
+}
+
+func readWorkFile(dir string) *modfile.WorkFile {
+	// Read and parse the go.work file
+	filePath := filepath.Join(dir, "go.work")
+	b, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		fatalf("Error reading workspace file %s: %s", filePath, err)
+	}
+
+	file, err := modfile.ParseWork(filePath, b, nil)
+	if err != nil {
+		fatalf("Error parsing workspace file %s: %s", filePath, err)
+	}
+
+	return file
+}
+
+// workspaceModules holds the paths of the modules in the workspace being
+// upgraded, if any. Like the module itself, they are main modules, so
+// requirements on them are not treated as dependencies to upgrade.
+var workspaceModules = map[string]bool{}
+
+// upgradeWorkspace performs the requested upgrade in every module of the
+// workspace rooted in the given directory. When upgrading a single dependency,
+// modules that don't require it are skipped.
+func upgradeWorkspace(ctx context.Context, dir, path, version string, migrating bool) {
+	work := readWorkFile(dir)
+
+	for _, moduleDir := range workspaceModuleDirs(dir, work) {
+		if !workspaceTarget(path) && !requires(readModFile(moduleDir), path) {
+			if *verbose {
+				fmt.Fprintf(stdout, "Skipping workspace module %s: does not require %s\n", moduleDir, path)
+			}
+			continue
+		}
+
+		fmt.Fprintf(stdout, "Upgrading workspace module %s\n", moduleDir)
+		run(ctx, moduleDir, path, version, migrating)
+	}
+
+	if *workSync {
+		cmd := exec.CommandContext(ctx, "go", "work", "sync")
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			fatalf("Error executing 'go work sync' command: %s\n%s", err, out)
+		}
+	}
+}
+
+// workspaceTarget reports whether the given special target applies to every
+// module in a workspace (rather than to a single dependency).
+func workspaceTarget(path string) bool {
+	switch path {
+	case "all", "pin", "security", "remap":
+		return true
+	}
+	return false
+}
+
+// requires reports whether the module file requires the given module.
+func requires(file *modfile.File, path string) bool {
+	for _, require := range file.Require {
+		if require.Mod.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceModuleDirs returns the directories of the modules in the
+// workspace, sorted so that modules required by other modules in the
+// workspace come before the modules that require them.
+func workspaceModuleDirs(dir string, work *modfile.WorkFile) []string {
+	var (
+		dirs  []string
+		files = map[string]*modfile.File{} // Keyed by module directory
+		paths = map[string]string{}        // Module path -> module directory
+	)
+	for _, use := range work.Use {
+		moduleDir := filepath.Join(dir, use.Path)
+		if filepath.IsAbs(use.Path) {
+			moduleDir = use.Path
+		}
+
+		file := readModFile(moduleDir)
+		dirs = append(dirs, moduleDir)
+		files[moduleDir] = file
+		paths[file.Module.Mod.Path] = moduleDir
+		workspaceModules[file.Module.Mod.Path] = true
+	}
+
+	// Depth-first topological sort, preserving the order of the use
+	// directives where possible
+	var (
+		sorted  []string
+		visited = map[string]bool{}
+		visit   func(moduleDir string)
+	)
+	visit = func(moduleDir string) {
+		if visited[moduleDir] {
+			return
+		}
+		visited[moduleDir] = true
+
+		for _, require := range files[moduleDir].Require {
+			if depDir, ok := paths[require.Mod.Path]; ok {
+				visit(depDir)
+			}
+		}
+		sorted = append(sorted, moduleDir)
+	}
+	for _, moduleDir := range dirs {
+		visit(moduleDir)
+	}
+
+	return sorted
+}